@@ -0,0 +1,457 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/jacekolszak/deebee/compacter"
+	"github.com/jacekolszak/deebee/replicator"
+	"github.com/jacekolszak/deebee/store"
+)
+
+type versionJSON struct {
+	Time     string `json:"time"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum,omitempty"` // valid, invalid or unknown
+	Error    string `json:"error,omitempty"`
+}
+
+const (
+	checksumValid   = "valid"
+	checksumInvalid = "invalid"
+	checksumUnknown = "unknown" // could not be verified, for example written with checksum algorithm unknown to the tool
+)
+
+func newVersionJSON(v store.Version) versionJSON {
+	return versionJSON{
+		Time: formatTime(v.Time),
+		Size: v.Size,
+	}
+}
+
+func versionsCommand(env *environment, args []string) error {
+	flags := env.flagSet()
+	noVerify := flags.Bool("no-verify", false, "do not read versions to check their checksums")
+	args, err := env.parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	s, err := openForReading(args[0])
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	versions, err := s.Versions()
+	if err != nil {
+		return err
+	}
+	var statuses map[int64]versionJSON
+	if !*noVerify {
+		report, err := s.Verify(context.Background())
+		if err != nil {
+			return err
+		}
+		statuses = checksumStatuses(report)
+	}
+	results := make([]versionJSON, len(versions))
+	for i, v := range versions {
+		results[i] = newVersionJSON(v)
+		if status, ok := statuses[v.Time.UnixNano()]; ok {
+			results[i].Checksum, results[i].Error = status.Checksum, status.Error
+		}
+	}
+	return env.printVersions(results)
+}
+
+type verifyReportJSON struct {
+	Verified           []versionJSON `json:"verified"`
+	Corrupted          []versionJSON `json:"corrupted"`
+	Unverifiable       []versionJSON `json:"unverifiable"`
+	OrphanedDataFiles  []string      `json:"orphanedDataFiles"`
+	StrayChecksumFiles []string      `json:"strayChecksumFiles"`
+	UnparsableFiles    []string      `json:"unparsableFiles"`
+	Quarantined        []string      `json:"quarantined"`
+}
+
+// verifiableStore is implemented by both store.Store and store.ReadOnlyStore
+type verifiableStore interface {
+	Verify(context.Context, ...store.VerifyOption) (store.VerifyReport, error)
+	Close() error
+}
+
+func verifyCommand(env *environment, args []string) error {
+	flags := env.flagSet()
+	quarantine := flags.Bool("quarantine", false, "move corrupted versions into quarantine subdirectory")
+	args, err := env.parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	var s verifiableStore
+	var options []store.VerifyOption
+	if *quarantine {
+		s, err = openForWriting(args[0], store.FailWhenMissingDir)
+		options = append(options, store.Quarantine)
+	} else {
+		s, err = openForReading(args[0])
+	}
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	report, err := s.Verify(context.Background(), options...)
+	if err != nil {
+		return err
+	}
+
+	if env.json {
+		err = env.printJSON(newVerifyReportJSON(report))
+	} else {
+		err = env.printVerifyReport(report)
+	}
+	if err != nil {
+		return err
+	}
+	if !report.OK() {
+		return errFailed
+	}
+	return nil
+}
+
+func newVerifyReportJSON(report store.VerifyReport) verifyReportJSON {
+	result := verifyReportJSON{
+		Verified:           []versionJSON{},
+		Corrupted:          []versionJSON{},
+		Unverifiable:       []versionJSON{},
+		OrphanedDataFiles:  nonNil(report.OrphanedDataFiles),
+		StrayChecksumFiles: nonNil(report.StrayChecksumFiles),
+		UnparsableFiles:    nonNil(report.UnparsableFiles),
+		Quarantined:        nonNil(report.Quarantined),
+	}
+	for _, v := range report.Verified {
+		verified := newVersionJSON(v)
+		verified.Checksum = checksumValid
+		result.Verified = append(result.Verified, verified)
+	}
+	for _, c := range report.Corrupted {
+		corrupted := newVersionJSON(c.Version)
+		corrupted.Checksum = checksumInvalid
+		corrupted.Error = c.Err.Error()
+		result.Corrupted = append(result.Corrupted, corrupted)
+	}
+	for _, u := range report.Unverifiable {
+		unverifiable := newVersionJSON(u.Version)
+		unverifiable.Checksum = checksumUnknown
+		unverifiable.Error = u.Err.Error()
+		result.Unverifiable = append(result.Unverifiable, unverifiable)
+	}
+	return result
+}
+
+// nonNil makes sure that empty slice is encoded as [] instead of null
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func (e *environment) printVerifyReport(report store.VerifyReport) error {
+	for _, c := range report.Corrupted {
+		_, _ = fmt.Fprintf(e.stdout, "corrupted version %s: %s\n", formatTime(c.Version.Time), c.Err)
+	}
+	for _, u := range report.Unverifiable {
+		_, _ = fmt.Fprintf(e.stdout, "unverifiable version %s: %s\n", formatTime(u.Version.Time), u.Err)
+	}
+	for _, name := range report.OrphanedDataFiles {
+		_, _ = fmt.Fprintf(e.stdout, "orphaned data file %s\n", name)
+	}
+	for _, name := range report.StrayChecksumFiles {
+		_, _ = fmt.Fprintf(e.stdout, "stray checksum file %s\n", name)
+	}
+	for _, name := range report.UnparsableFiles {
+		_, _ = fmt.Fprintf(e.stdout, "unparsable file %s\n", name)
+	}
+	for _, name := range report.Quarantined {
+		_, _ = fmt.Fprintf(e.stdout, "quarantined file %s\n", name)
+	}
+	versions := len(report.Verified) + len(report.Corrupted)
+	_, err := fmt.Fprintf(e.stdout, "%d versions verified, %d corrupted, %d unverifiable\n", versions,
+		len(report.Corrupted), len(report.Unverifiable))
+	return err
+}
+
+// checksumStatuses returns checksum status and error of each version in the report by unix nano time
+func checksumStatuses(report store.VerifyReport) map[int64]versionJSON {
+	statuses := map[int64]versionJSON{}
+	for _, v := range report.Verified {
+		statuses[v.Time.UnixNano()] = versionJSON{Checksum: checksumValid}
+	}
+	for _, c := range report.Corrupted {
+		statuses[c.Version.Time.UnixNano()] = versionJSON{Checksum: checksumInvalid, Error: c.Err.Error()}
+	}
+	for _, u := range report.Unverifiable {
+		statuses[u.Version.Time.UnixNano()] = versionJSON{Checksum: checksumUnknown, Error: u.Err.Error()}
+	}
+	return statuses
+}
+
+func catCommand(env *environment, args []string) error {
+	flags := env.flagSet()
+	versionTime := flags.String("time", "", "time of the version, latest version is used by default")
+	args, err := env.parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	var options []store.ReaderOption
+	if *versionTime != "" {
+		t, err := parseTime(*versionTime)
+		if err != nil {
+			return err
+		}
+		options = append(options, store.Time(t))
+	}
+
+	s, err := openForReading(args[0])
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	reader, err := s.Reader(options...)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(env.stdout, reader)
+	if closeErr := reader.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func rmCommand(env *environment, args []string) error {
+	flags := env.flagSet()
+	args, err := env.parse(flags, args, 2, -1)
+	if err != nil {
+		return err
+	}
+
+	var times []time.Time
+	for _, arg := range args[1:] {
+		t, err := parseTime(arg)
+		if err != nil {
+			return err
+		}
+		times = append(times, t)
+	}
+
+	s, err := openForWriting(args[0], store.FailWhenMissingDir)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	versions, err := s.Versions()
+	if err != nil {
+		return err
+	}
+	// all versions are found before deleting any, so nothing is deleted when one of the times is wrong
+	var toDelete []store.Version
+	for _, t := range times {
+		v, found := findVersion(versions, t)
+		if !found {
+			return store.NewVersionNotFoundError(fmt.Sprintf("version %s not found", formatTime(t)))
+		}
+		toDelete = append(toDelete, v)
+	}
+	var deleted []versionJSON
+	for _, v := range toDelete {
+		if err = s.DeleteVersion(v.Time); err != nil {
+			return err
+		}
+		deleted = append(deleted, newVersionJSON(v))
+	}
+	return env.printVersions(deleted)
+}
+
+func findVersion(versions []store.Version, t time.Time) (store.Version, bool) {
+	for _, v := range versions {
+		if v.Time.Equal(t) {
+			return v, true
+		}
+	}
+	return store.Version{}, false
+}
+
+func compactCommand(env *environment, args []string) error {
+	flags := env.flagSet()
+	dryRun := flags.Bool("dry-run", false, "only print versions which would be deleted")
+	keepLast := flags.Int("keep-last", 0, "keep n most recent versions")
+	keepYoungerThan := flags.Duration("keep-younger-than", 0, "keep versions younger than given duration")
+	keepHourly := flags.Int("keep-hourly", 0, "keep the latest version for each of n last hours")
+	keepDaily := flags.Int("keep-daily", 0, "keep the latest version for each of n last days")
+	keepWeekly := flags.Int("keep-weekly", 0, "keep the latest version for each of n last weeks")
+	maxTotalSize := flags.Int64("max-total-size", 0, "delete oldest versions until the total size in bytes is not greater than given value")
+	args, err := env.parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	var options []compacter.Option
+	set := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	if set["keep-last"] {
+		options = append(options, compacter.KeepLast(*keepLast))
+	}
+	if set["keep-younger-than"] {
+		options = append(options, compacter.KeepYoungerThan(*keepYoungerThan))
+	}
+	if set["keep-hourly"] {
+		options = append(options, compacter.KeepHourly(*keepHourly))
+	}
+	if set["keep-daily"] {
+		options = append(options, compacter.KeepDaily(*keepDaily))
+	}
+	if set["keep-weekly"] {
+		options = append(options, compacter.KeepWeekly(*keepWeekly))
+	}
+	if set["max-total-size"] {
+		options = append(options, compacter.MaxTotalSize(*maxTotalSize))
+	}
+
+	s, err := openForWriting(args[0], store.FailWhenMissingDir)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	var deleted []store.Version
+	if *dryRun {
+		deleted, err = compacter.DryRun(s, options...)
+	} else {
+		options = append(options, compacter.OnEvent(func(e compacter.Event) {
+			if e, ok := e.(compacter.VersionDeleted); ok {
+				deleted = append(deleted, e.Version)
+			}
+		}))
+		err = compacter.RunOnce(s, options...)
+	}
+	if err != nil {
+		return err
+	}
+
+	results := make([]versionJSON, len(deleted))
+	for i, v := range deleted {
+		results[i] = newVersionJSON(v)
+	}
+	return env.printVersions(results)
+}
+
+func replicateCommand(env *environment, args []string) error {
+	flags := env.flagSet()
+	args, err := env.parse(flags, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	from, err := openForReading(args[0])
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+
+	to, err := openForWriting(args[1])
+	if err != nil {
+		return err
+	}
+	defer to.Close()
+
+	if err = replicator.CopyFromTo(from, to); err != nil {
+		return err
+	}
+	return env.printLatestVersion(to)
+}
+
+func importCommand(env *environment, args []string) error {
+	flags := env.flagSet()
+	versionTime := flags.String("time", "", "time of the new version, current time is used by default")
+	args, err := env.parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	var options []store.WriterOption
+	if *versionTime != "" {
+		t, err := parseTime(*versionTime)
+		if err != nil {
+			return err
+		}
+		options = append(options, store.WriteTime(t))
+	}
+
+	s, err := openForWriting(args[0])
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	writer, err := s.Writer(options...)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(writer, env.stdin); err != nil {
+		writer.AbortAndClose()
+		return fmt.Errorf("error reading standard input: %w", err)
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+	return env.printVersions([]versionJSON{newVersionJSON(writer.Version())})
+}
+
+func (e *environment) printLatestVersion(s *store.Store) error {
+	versions, err := s.Versions()
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return store.NewVersionNotFoundError("no version found")
+	}
+	return e.printVersions([]versionJSON{newVersionJSON(versions[len(versions)-1])})
+}
+
+func (e *environment) printVersions(versions []versionJSON) error {
+	if e.json {
+		if versions == nil {
+			versions = []versionJSON{}
+		}
+		return e.printJSON(versions)
+	}
+
+	header := []string{"TIME", "SIZE"}
+	withChecksum := len(versions) > 0 && versions[0].Checksum != ""
+	if withChecksum {
+		header = append(header, "CHECKSUM")
+	}
+	rows := make([][]string, len(versions))
+	for i, v := range versions {
+		rows[i] = []string{v.Time, strconv.FormatInt(v.Size, 10)}
+		if withChecksum {
+			rows[i] = append(rows[i], v.Checksum)
+		}
+	}
+	return e.printTable(header, rows)
+}
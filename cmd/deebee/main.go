@@ -0,0 +1,197 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+// Command deebee inspects and repairs DeeBee stores.
+//
+// Usage:
+//
+//	deebee <command> [flags] <arguments>
+//
+// Run "deebee help" to see all commands. Every command accepts -json flag which makes the output easy to process
+// by scripts. Commands which only read the store open it with store.OpenReadOnly, commands which modify the store
+// open it with store.ExclusiveLock.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jacekolszak/deebee/store"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+type command struct {
+	name        string
+	arguments   string
+	description string
+	run         func(env *environment, args []string) error
+}
+
+var commands = []command{
+	{name: "versions", arguments: "<dir>", description: "list versions with their size and checksum status", run: versionsCommand},
+	{name: "cat", arguments: "<dir>", description: "write version data to standard output", run: catCommand},
+	{name: "verify", arguments: "<dir>", description: "verify checksums of all versions and find files not belonging to any version", run: verifyCommand},
+	{name: "rm", arguments: "<dir> <time>...", description: "remove versions", run: rmCommand},
+	{name: "compact", arguments: "<dir>", description: "remove old versions", run: compactCommand},
+	{name: "replicate", arguments: "<from dir> <to dir>", description: "copy the latest version to another store", run: replicateCommand},
+	{name: "import", arguments: "<dir>", description: "write data from standard input as a new version", run: importCommand},
+}
+
+// environment is passed to commands. It makes the command testable without touching process-wide state.
+type environment struct {
+	command command
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+	json    bool
+}
+
+// errUsage is returned by commands when arguments are invalid
+var errUsage = errors.New("invalid usage")
+
+// errFailed is returned by commands which already reported the problem, but must exit with non-zero code
+var errFailed = errors.New("failed")
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		printUsage(stderr)
+		if len(args) == 0 {
+			return exitUsage
+		}
+		return exitOK
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+		env := &environment{command: cmd, stdin: stdin, stdout: stdout, stderr: stderr}
+		err := cmd.run(env, args[1:])
+		switch {
+		case err == nil:
+			return exitOK
+		case errors.Is(err, flag.ErrHelp):
+			return exitOK
+		case errors.Is(err, errUsage):
+			return exitUsage
+		case errors.Is(err, errFailed):
+			return exitError
+		default:
+			_, _ = fmt.Fprintf(stderr, "deebee %s: %s\n", cmd.name, err)
+			return exitError
+		}
+	}
+
+	_, _ = fmt.Fprintf(stderr, "deebee: unknown command %s\n", args[0])
+	printUsage(stderr)
+	return exitUsage
+}
+
+func printUsage(w io.Writer) {
+	_, _ = fmt.Fprintln(w, "Usage: deebee <command> [flags] <arguments>")
+	_, _ = fmt.Fprintln(w)
+	_, _ = fmt.Fprintln(w, "Commands:")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		_, _ = fmt.Fprintf(tw, "  %s %s\t%s\n", cmd.name, cmd.arguments, cmd.description)
+	}
+	_ = tw.Flush()
+	_, _ = fmt.Fprintln(w)
+	_, _ = fmt.Fprintln(w, `Run "deebee <command> -h" to see command flags.`)
+}
+
+// flagSet returns a flag set with -json flag already defined
+func (e *environment) flagSet() *flag.FlagSet {
+	flags := flag.NewFlagSet(e.command.name, flag.ContinueOnError)
+	flags.SetOutput(e.stderr)
+	flags.BoolVar(&e.json, "json", false, "print output in JSON format")
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(e.stderr, "Usage: deebee %s [flags] %s\n\n", e.command.name, e.command.arguments)
+		_, _ = fmt.Fprintf(e.stderr, "Flags:\n")
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parse parses flags and makes sure that the number of remaining arguments is within [min, max] range.
+// Negative max means no limit.
+func (e *environment) parse(flags *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, errUsage
+	}
+	remaining := flags.Args()
+	if len(remaining) < min || (max >= 0 && len(remaining) > max) {
+		flags.Usage()
+		return nil, errUsage
+	}
+	return remaining, nil
+}
+
+// printJSON prints value as indented JSON
+func (e *environment) printJSON(v interface{}) error {
+	encoder := json.NewEncoder(e.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// printTable prints rows as aligned columns
+func (e *environment) printTable(header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(e.stdout, 0, 4, 2, ' ', 0)
+	printRow(tw, header)
+	for _, row := range rows {
+		printRow(tw, row)
+	}
+	return tw.Flush()
+}
+
+func printRow(w io.Writer, columns []string) {
+	for i, column := range columns {
+		if i > 0 {
+			_, _ = fmt.Fprint(w, "\t")
+		}
+		_, _ = fmt.Fprint(w, column)
+	}
+	_, _ = fmt.Fprintln(w)
+}
+
+const timeFormat = time.RFC3339Nano
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+func parseTime(s string) (time.Time, error) {
+	t, err := time.Parse(timeFormat, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %s, must be in RFC 3339 format, for example %s", s, formatTime(time.Now()))
+	}
+	return t, nil
+}
+
+// openForReading opens the store without modifying the directory, so it can be used on read-only filesystems and
+// while another process is writing to it
+func openForReading(dir string) (*store.ReadOnlyStore, error) {
+	return store.OpenReadOnly(dir)
+}
+
+func openForWriting(dir string, options ...store.Option) (*store.Store, error) {
+	return store.Open(dir, append(options, store.ExclusiveLock)...)
+}
@@ -0,0 +1,355 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"hash/fnv"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+
+	t.Run("should print usage when no command is given", func(t *testing.T) {
+		result := runCommand(t, "")
+		assert.Equal(t, exitUsage, result.code)
+		assert.Contains(t, result.stderr, "Usage:")
+	})
+
+	t.Run("should return usage error for unknown command", func(t *testing.T) {
+		result := runCommand(t, "", "unknown")
+		assert.Equal(t, exitUsage, result.code)
+		assert.Contains(t, result.stderr, "unknown command")
+	})
+
+	t.Run("should return usage error when arguments are missing", func(t *testing.T) {
+		for _, command := range commands {
+			t.Run(command.name, func(t *testing.T) {
+				result := runCommand(t, "", command.name)
+				assert.Equal(t, exitUsage, result.code)
+			})
+		}
+	})
+
+	t.Run("should return error when store directory does not exist", func(t *testing.T) {
+		result := runCommand(t, "", "versions", tests.TempDir(t)+"/missing")
+		assert.Equal(t, exitError, result.code)
+		assert.Contains(t, result.stderr, "does not exist")
+	})
+}
+
+func TestVersions(t *testing.T) {
+
+	t.Run("should list versions", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		v1 := writeVersion(t, dir, "v1")
+		v2 := writeVersion(t, dir, "version2")
+		// when
+		result := runCommand(t, "", "versions", "-json", dir)
+		// then
+		require.Equal(t, exitOK, result.code)
+		assert.Equal(t,
+			[]versionJSON{
+				{Time: formatTime(v1.Time), Size: 2, Checksum: checksumValid},
+				{Time: formatTime(v2.Time), Size: 8, Checksum: checksumValid},
+			},
+			decodeVersions(t, result.stdout))
+	})
+
+	t.Run("should print table", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		v := writeVersion(t, dir, "data")
+		// when
+		result := runCommand(t, "", "versions", dir)
+		// then
+		require.Equal(t, exitOK, result.code)
+		assert.Contains(t, result.stdout, "TIME")
+		assert.Contains(t, result.stdout, formatTime(v.Time))
+	})
+
+	t.Run("should report checksums of versions", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		writeVersion(t, dir, "corrupted")
+		corruptDataFiles(t, dir)
+		s, err := store.Open(dir, store.Checksum(store.ChecksumAlgorithm{Name: "fnv128a", NewHash: fnv.New128a}))
+		require.NoError(t, err)
+		tests.WriteData(t, s, []byte("custom algorithm"))
+		writeVersion(t, dir, "valid")
+		// when
+		result := runCommand(t, "", "versions", "-json", dir)
+		// then
+		require.Equal(t, exitOK, result.code)
+		versions := decodeVersions(t, result.stdout)
+		require.Len(t, versions, 3)
+		assert.Equal(t, checksumInvalid, versions[0].Checksum)
+		assert.NotEmpty(t, versions[0].Error)
+		assert.Equal(t, checksumUnknown, versions[1].Checksum)
+		assert.Contains(t, versions[1].Error, "fnv128a")
+		assert.Equal(t, checksumValid, versions[2].Checksum)
+		assert.Empty(t, versions[2].Error)
+	})
+
+	t.Run("should not verify checksums", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		writeVersion(t, dir, "data")
+		tests.CorruptFiles(t, dir)
+		// when
+		result := runCommand(t, "", "versions", "-json", "-no-verify", dir)
+		// then
+		require.Equal(t, exitOK, result.code)
+		versions := decodeVersions(t, result.stdout)
+		require.Len(t, versions, 1)
+		assert.Empty(t, versions[0].Checksum)
+	})
+}
+
+func TestVerify(t *testing.T) {
+
+	t.Run("should succeed when all versions are valid", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		writeVersion(t, dir, "data")
+		// when
+		result := runCommand(t, "", "verify", dir)
+		// then
+		assert.Equal(t, exitOK, result.code)
+		assert.Contains(t, result.stdout, "1 versions verified, 0 corrupted")
+	})
+
+	t.Run("should fail when version is corrupted", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		writeVersion(t, dir, "data")
+		corruptDataFiles(t, dir)
+		// when
+		result := runCommand(t, "", "verify", "-json", dir)
+		// then
+		assert.Equal(t, exitError, result.code)
+		report := decodeVerifyReport(t, result.stdout)
+		assert.Empty(t, report.Verified)
+		require.Len(t, report.Corrupted, 1)
+		assert.Equal(t, checksumInvalid, report.Corrupted[0].Checksum)
+		assert.NotEmpty(t, report.Corrupted[0].Error)
+	})
+
+	t.Run("should fail when there are orphaned files", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		writeVersion(t, dir, "data")
+		tests.TouchFile(t, dir+"/2021-01-01T00_00_00Z.data")
+		// when
+		result := runCommand(t, "", "verify", dir)
+		// then
+		assert.Equal(t, exitError, result.code)
+		assert.Contains(t, result.stdout, "orphaned data file 2021-01-01T00_00_00Z.data")
+	})
+
+	t.Run("should quarantine corrupted version", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		writeVersion(t, dir, "data")
+		corruptDataFiles(t, dir)
+		// when
+		result := runCommand(t, "", "verify", "-json", "-quarantine", dir)
+		// then
+		assert.Equal(t, exitError, result.code)
+		report := decodeVerifyReport(t, result.stdout)
+		assert.Len(t, report.Quarantined, 2)
+		assertVersions(t, dir)
+	})
+}
+
+func TestCat(t *testing.T) {
+
+	t.Run("should print latest version", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		writeVersion(t, dir, "old")
+		writeVersion(t, dir, "new")
+		// when
+		result := runCommand(t, "", "cat", dir)
+		// then
+		require.Equal(t, exitOK, result.code)
+		assert.Equal(t, "new", result.stdout)
+	})
+
+	t.Run("should print version with given time", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		old := writeVersion(t, dir, "old")
+		writeVersion(t, dir, "new")
+		// when
+		result := runCommand(t, "", "cat", "-time", formatTime(old.Time), dir)
+		// then
+		require.Equal(t, exitOK, result.code)
+		assert.Equal(t, "old", result.stdout)
+	})
+
+	t.Run("should return error for invalid time", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		writeVersion(t, dir, "data")
+		// when
+		result := runCommand(t, "", "cat", "-time", "yesterday", dir)
+		// then
+		assert.Equal(t, exitError, result.code)
+		assert.Contains(t, result.stderr, "invalid time")
+	})
+}
+
+func TestRm(t *testing.T) {
+
+	t.Run("should remove version", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		v1 := writeVersion(t, dir, "v1")
+		v2 := writeVersion(t, dir, "v2")
+		// when
+		result := runCommand(t, "", "rm", "-json", dir, formatTime(v1.Time))
+		// then
+		require.Equal(t, exitOK, result.code)
+		assert.Equal(t, []versionJSON{{Time: formatTime(v1.Time), Size: 2}}, decodeVersions(t, result.stdout))
+		assertVersions(t, dir, v2)
+	})
+
+	t.Run("should return error when version does not exist", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		v := writeVersion(t, dir, "v1")
+		// when
+		result := runCommand(t, "", "rm", dir, formatTime(v.Time.Add(time.Second)))
+		// then
+		assert.Equal(t, exitError, result.code)
+		assertVersions(t, dir, v)
+	})
+
+	t.Run("should not remove any version when one of them does not exist", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		v1 := writeVersion(t, dir, "v1")
+		v2 := writeVersion(t, dir, "v2")
+		// when
+		result := runCommand(t, "", "rm", dir, formatTime(v1.Time), formatTime(v2.Time), formatTime(v2.Time.Add(time.Second)))
+		// then
+		assert.Equal(t, exitError, result.code)
+		assertVersions(t, dir, v1, v2)
+	})
+}
+
+func TestCompact(t *testing.T) {
+
+	t.Run("should remove old versions", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		v1 := writeVersion(t, dir, "v1")
+		v2 := writeVersion(t, dir, "v2")
+		v3 := writeVersion(t, dir, "v3")
+		// when
+		result := runCommand(t, "", "compact", "-json", "-keep-last", "2", dir)
+		// then
+		require.Equal(t, exitOK, result.code)
+		assert.Equal(t, []versionJSON{{Time: formatTime(v1.Time), Size: 2}}, decodeVersions(t, result.stdout))
+		assertVersions(t, dir, v2, v3)
+	})
+
+	t.Run("should not remove versions in dry run", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		v1 := writeVersion(t, dir, "v1")
+		v2 := writeVersion(t, dir, "v2")
+		// when
+		result := runCommand(t, "", "compact", "-json", "-dry-run", dir)
+		// then
+		require.Equal(t, exitOK, result.code)
+		assert.Equal(t, []versionJSON{{Time: formatTime(v1.Time), Size: 2}}, decodeVersions(t, result.stdout))
+		assertVersions(t, dir, v1, v2)
+	})
+}
+
+func TestReplicate(t *testing.T) {
+
+	t.Run("should copy latest version", func(t *testing.T) {
+		from := tests.TempDir(t)
+		to := tests.TempDir(t)
+		writeVersion(t, from, "old")
+		latest := writeVersion(t, from, "new")
+		// when
+		result := runCommand(t, "", "replicate", "-json", from, to)
+		// then
+		require.Equal(t, exitOK, result.code)
+		assert.Equal(t, []versionJSON{{Time: formatTime(latest.Time), Size: 3}}, decodeVersions(t, result.stdout))
+		assertVersions(t, to, latest)
+	})
+}
+
+func TestImport(t *testing.T) {
+
+	t.Run("should write data from stdin", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		// when
+		result := runCommand(t, "data", "import", dir)
+		// then
+		require.Equal(t, exitOK, result.code)
+		assert.Equal(t, "data", runCommand(t, "", "cat", dir).stdout)
+	})
+
+	t.Run("should write version with given time", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		versionTime := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+		// when
+		result := runCommand(t, "data", "import", "-json", "-time", formatTime(versionTime), dir)
+		// then
+		require.Equal(t, exitOK, result.code)
+		assert.Equal(t, []versionJSON{{Time: formatTime(versionTime), Size: 4}}, decodeVersions(t, result.stdout))
+	})
+}
+
+type commandResult struct {
+	code   int
+	stdout string
+	stderr string
+}
+
+func runCommand(t *testing.T, stdin string, args ...string) commandResult {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	t.Log(stderr.String())
+	return commandResult{code: code, stdout: stdout.String(), stderr: stderr.String()}
+}
+
+func writeVersion(t *testing.T, dir string, data string) store.Version {
+	s, err := store.Open(dir)
+	require.NoError(t, err)
+	return tests.WriteData(t, s, []byte(data))
+}
+
+// corruptDataFiles corrupts data files only, because corrupted checksum file might look like written with
+// unknown checksum algorithm
+func corruptDataFiles(t *testing.T, dir string) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.data"))
+	require.NoError(t, err)
+	for _, file := range files {
+		tests.CorruptFile(t, file)
+	}
+}
+
+func decodeVersions(t *testing.T, output string) []versionJSON {
+	var versions []versionJSON
+	require.NoError(t, json.Unmarshal([]byte(output), &versions))
+	return versions
+}
+
+func decodeVerifyReport(t *testing.T, output string) verifyReportJSON {
+	var report verifyReportJSON
+	require.NoError(t, json.Unmarshal([]byte(output), &report))
+	return report
+}
+
+func assertVersions(t *testing.T, dir string, expected ...store.Version) {
+	s, err := store.Open(dir)
+	require.NoError(t, err)
+	versions, err := s.Versions()
+	require.NoError(t, err)
+	require.Len(t, versions, len(expected))
+	for i, v := range versions {
+		assert.True(t, expected[i].Time.Equal(v.Time))
+	}
+}
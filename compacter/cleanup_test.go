@@ -0,0 +1,66 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package compacter_test
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/jacekolszak/deebee/compacter"
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanup(t *testing.T) {
+
+	t.Run("should remove orphaned files", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s, err := store.Open(dir)
+		require.NoError(t, err)
+		tests.WriteData(t, s, []byte("data"))
+		orphan := path.Join(dir, "2021-01-01T00_00_00Z.data")
+		tests.TouchFile(t, orphan)
+		recorder := &tests.EventRecorder{}
+		// when
+		err = compacter.RunOnce(s,
+			compacter.Cleanup(store.GracePeriod(0)),
+			compacter.OnEvent(recordEvent(recorder)),
+		)
+		// then
+		require.NoError(t, err)
+		assert.NoFileExists(t, orphan)
+		events := recorder.Events()
+		require.Len(t, events, 2)
+		removed, ok := events[0].(compacter.OrphanedFilesRemoved)
+		require.True(t, ok, "OrphanedFilesRemoved expected")
+		assert.Equal(t, []string{"2021-01-01T00_00_00Z.data"}, removed.Files)
+	})
+
+	t.Run("should not remove orphaned files in dry run", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s, err := store.Open(dir)
+		require.NoError(t, err)
+		orphan := path.Join(dir, "2021-01-01T00_00_00Z.data")
+		tests.TouchFile(t, orphan)
+		old := time.Now().Add(-2 * time.Hour)
+		require.NoError(t, os.Chtimes(orphan, old, old))
+		// when
+		_, err = compacter.DryRun(s, compacter.Cleanup())
+		// then
+		require.NoError(t, err)
+		assert.FileExists(t, orphan)
+	})
+
+	t.Run("should return error when store does not support cleanup", func(t *testing.T) {
+		s := storeWithDelete{&tests.StoreMock{}}
+		// when
+		err := compacter.RunOnce(s, compacter.Cleanup())
+		// then
+		assert.Error(t, err)
+	})
+}
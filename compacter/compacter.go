@@ -13,39 +13,99 @@ import (
 
 	"github.com/jacekolszak/deebee/codec"
 	"github.com/jacekolszak/deebee/store"
+	"github.com/jacekolszak/deebee/throttle"
 )
 
+// RunOnce deletes versions older than the latest integral one. By default all such versions are deleted.
+// Keep options can be used to retain some of them, MaxTotalSize to limit the disk space used by the store.
 func RunOnce(s Store, options ...Option) error {
 	if s == nil {
 		return errors.New("nil store")
 	}
 
-	_, err := applyOptions(options)
+	opts, err := applyOptions(options)
 	if err != nil {
 		return err
 	}
 
-	versions, err := s.Versions()
+	_, err = opts.compact(context.Background(), s, false)
+	return err
+}
+
+// DryRun returns versions which would be deleted by RunOnce, without deleting them.
+func DryRun(s Store, options ...Option) ([]store.Version, error) {
+	if s == nil {
+		return nil, errors.New("nil store")
+	}
+
+	opts, err := applyOptions(options)
 	if err != nil {
-		return fmt.Errorf("error getting versions: %w", err)
+		return nil, err
 	}
 
-	if len(versions) > 1 {
-		latestVersion, err := codec.ReadLatest(s, readAllDiscarding)
-		if err != nil {
-			return fmt.Errorf("error getting latest integral version: %w", err)
+	return opts.compact(context.Background(), s, true)
+}
+
+// compact cancels throttled reading of versions when ctx is done
+func (o *Options) compact(ctx context.Context, s Store, dryRun bool) ([]store.Version, error) {
+	start := time.Now()
+	var (
+		deleted []store.Version
+		err     error
+	)
+	run := func() {
+		deleted, err = o.deleteVersions(ctx, s, dryRun)
+		if err == nil && o.cleanup != nil && !dryRun {
+			err = o.removeOrphanedFiles(s)
 		}
-		for _, v := range versions {
-			if v == latestVersion {
-				return nil
-			}
-			if err := s.DeleteVersion(v.Time); err != nil {
-				return fmt.Errorf("error when deleting version: %w", err)
-			}
+	}
+	if o.lowIO {
+		throttle.LowIOPriority(run)
+	} else {
+		run()
+	}
+	if err != nil {
+		o.emit(CompactionFailed{Err: err, Duration: time.Since(start)})
+		return nil, err
+	}
+	o.emit(CompactionSucceeded{Deleted: deleted, DryRun: dryRun, Duration: time.Since(start)})
+	return deleted, nil
+}
+
+func (o *Options) deleteVersions(ctx context.Context, s Store, dryRun bool) ([]store.Version, error) {
+	versions, err := s.Versions()
+	if err != nil {
+		return nil, fmt.Errorf("error getting versions: %w", err)
+	}
+
+	if len(versions) <= 1 {
+		return nil, nil
+	}
+
+	latestVersion, err := codec.ReadLatest(s, func(reader io.Reader) error {
+		return o.readAllDiscarding(ctx, reader)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting latest integral version: %w", err)
+	}
+	latestIntegral := 0
+	for i, v := range versions {
+		if v == latestVersion {
+			latestIntegral = i
 		}
 	}
 
-	return nil
+	toDelete := o.versionsToDelete(versions, latestIntegral)
+	if dryRun {
+		return toDelete, nil
+	}
+	for _, v := range toDelete {
+		if err := s.DeleteVersion(v.Time); err != nil {
+			return nil, fmt.Errorf("error when deleting version: %w", err)
+		}
+		o.emit(VersionDeleted{Version: v})
+	}
+	return toDelete, nil
 }
 
 func Start(ctx context.Context, s Store, options ...Option) error {
@@ -61,7 +121,7 @@ func Start(ctx context.Context, s Store, options ...Option) error {
 	for {
 		select {
 		case <-time.After(opts.interval):
-			if err := RunOnce(s, options...); err != nil {
+			if _, err := opts.compact(ctx, s, false); err != nil && len(opts.listeners) == 0 {
 				log.Printf("compacter.RunOnce failed: %s", err)
 			}
 		case <-ctx.Done():
@@ -79,7 +139,13 @@ type Store interface {
 type Option func(options *Options) error
 
 type Options struct {
-	interval time.Duration
+	interval     time.Duration
+	policies     []retentionPolicy
+	maxTotalSize int64
+	cleanup      []store.CleanupOption // nil when orphaned files should not be removed
+	limiter      *throttle.Limiter
+	lowIO        bool
+	listeners    []func(Event)
 }
 
 func Interval(d time.Duration) Option {
@@ -89,6 +155,51 @@ func Interval(d time.Duration) Option {
 	}
 }
 
+// Cleanup removes files left by interrupted writes after versions were deleted. Store must implement
+// Cleanup method, such as *store.Store does. See store.Store.Cleanup for details.
+func Cleanup(options ...store.CleanupOption) Option {
+	return func(o *Options) error {
+		o.cleanup = append([]store.CleanupOption{}, options...)
+		return nil
+	}
+}
+
+// Throttle limits the rate of reading versions, which are read to find the latest integral one
+func Throttle(limiter *throttle.Limiter) Option {
+	return func(o *Options) error {
+		if limiter == nil {
+			return errors.New("nil limiter")
+		}
+		o.limiter = limiter
+		return nil
+	}
+}
+
+// LowIOPriority runs compaction with idle I/O scheduling class. See throttle.LowIOPriority for details.
+var LowIOPriority Option = func(o *Options) error {
+	o.lowIO = true
+	return nil
+}
+
+type cleaner interface {
+	Cleanup(...store.CleanupOption) (store.CleanupReport, error)
+}
+
+func (o *Options) removeOrphanedFiles(s Store) error {
+	c, ok := s.(cleaner)
+	if !ok {
+		return errors.New("store does not support cleanup")
+	}
+	report, err := c.Cleanup(o.cleanup...)
+	if err != nil {
+		return fmt.Errorf("error removing orphaned files: %w", err)
+	}
+	if len(report.Removed) > 0 {
+		o.emit(OrphanedFilesRemoved{Files: report.Removed})
+	}
+	return nil
+}
+
 func applyOptions(options []Option) (*Options, error) {
 	opts := &Options{
 		interval: time.Minute,
@@ -105,7 +216,10 @@ func applyOptions(options []Option) (*Options, error) {
 	return opts, nil
 }
 
-func readAllDiscarding(reader io.Reader) error {
+func (o *Options) readAllDiscarding(ctx context.Context, reader io.Reader) error {
+	if o.limiter != nil {
+		reader = throttle.NewReader(ctx, reader, o.limiter)
+	}
 	block := make([]byte, 512)
 	for {
 		_, err := reader.Read(block)
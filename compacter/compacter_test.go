@@ -12,6 +12,7 @@ import (
 	"github.com/jacekolszak/deebee/compacter"
 	"github.com/jacekolszak/deebee/internal/tests"
 	"github.com/jacekolszak/deebee/store"
+	"github.com/jacekolszak/deebee/throttle"
 	otiai10 "github.com/otiai10/copy"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -58,6 +59,31 @@ func TestRunOnce(t *testing.T) {
 	})
 }
 
+func TestThrottle(t *testing.T) {
+
+	t.Run("should return error for nil limiter", func(t *testing.T) {
+		err := compacter.RunOnce(tests.OpenStore(t), compacter.Throttle(nil))
+		assert.Error(t, err)
+	})
+
+	t.Run("should compact with limited throughput", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		tests.WriteData(t, s, []byte("v1"))
+		tests.WriteData(t, s, make([]byte, 600))
+		limiter, err := throttle.NewLimiter(10000, 100)
+		require.NoError(t, err)
+		start := time.Now()
+		// when
+		err = compacter.RunOnce(s, compacter.Throttle(limiter), compacter.LowIOPriority)
+		// then
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, int64(time.Since(start)), int64(40*time.Millisecond))
+		versions, err := s.Versions()
+		require.NoError(t, err)
+		assert.Len(t, versions, 1)
+	})
+}
+
 func TestStart(t *testing.T) {
 
 	t.Run("should return error for nil store", func(t *testing.T) {
@@ -79,6 +105,24 @@ func TestStart(t *testing.T) {
 		assert.NoError(t, err)
 	})
 
+	t.Run("should interrupt throttled compaction once context is cancelled", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		tests.WriteData(t, s, []byte("v1"))
+		tests.WriteData(t, s, make([]byte, 1000))
+		limiter, err := throttle.NewLimiter(1, 1)
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		async := tests.RunAsync(func() {
+			err = compacter.Start(ctx, s, compacter.Interval(time.Millisecond), compacter.Throttle(limiter))
+		})
+		time.Sleep(10 * time.Millisecond) // let compaction start
+		// when
+		cancel()
+		// then
+		async.WaitOrFailAfter(t, time.Second)
+		assert.NoError(t, err)
+	})
+
 	t.Run("should skip nil option", func(t *testing.T) {
 		s := tests.OpenStore(t)
 		ctx, cancel := context.WithCancel(context.Background())
@@ -0,0 +1,87 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package compacter
+
+import (
+	"time"
+
+	"github.com/jacekolszak/deebee/internal/eventlog"
+	"github.com/jacekolszak/deebee/store"
+)
+
+// Event is emitted by RunOnce, DryRun and Start. It is one of VersionDeleted, OrphanedFilesRemoved,
+// CompactionSucceeded or CompactionFailed.
+type Event interface {
+	event()
+}
+
+// VersionDeleted is emitted after each deleted version
+type VersionDeleted struct {
+	Version store.Version
+}
+
+// OrphanedFilesRemoved is emitted when files left by interrupted writes were removed. See Cleanup option.
+type OrphanedFilesRemoved struct {
+	Files []string
+}
+
+// CompactionSucceeded is emitted after successful compaction. When DryRun is true, Deleted versions were not
+// actually deleted.
+type CompactionSucceeded struct {
+	Deleted  []store.Version
+	DryRun   bool
+	Duration time.Duration
+}
+
+// CompactionFailed is emitted when compaction failed. Some versions might have been deleted already.
+type CompactionFailed struct {
+	Err      error
+	Duration time.Duration
+}
+
+func (VersionDeleted) event()       {}
+func (OrphanedFilesRemoved) event() {}
+func (CompactionSucceeded) event()  {}
+func (CompactionFailed) event()     {}
+
+// OnEvent registers a listener receiving events. Listener is called by the goroutine running compaction, which
+// waits until it returns.
+func OnEvent(listener func(Event)) Option {
+	return func(options *Options) error {
+		options.listeners = append(options.listeners, listener)
+		return nil
+	}
+}
+
+// Logger is a structured logger used by Log option, such as *slog.Logger
+type Logger = eventlog.Logger
+
+// Log logs all events using logger. By default, Start logs failures using the standard log package, unless
+// a Logger or event listener was given.
+func Log(logger Logger) Option {
+	return OnEvent(func(e Event) {
+		eventlog.Write(logger, logEntries(e)...)
+	})
+}
+
+func logEntries(e Event) []eventlog.Entry {
+	switch e := e.(type) {
+	case VersionDeleted:
+		return []eventlog.Entry{eventlog.Info("compacter deleted version", "version", e.Version.Time)}
+	case OrphanedFilesRemoved:
+		return []eventlog.Entry{eventlog.Info("compacter removed orphaned files", "files", e.Files)}
+	case CompactionSucceeded:
+		return []eventlog.Entry{eventlog.Debug("compaction succeeded", "deleted", len(e.Deleted), "dryRun", e.DryRun,
+			"duration", e.Duration)}
+	case CompactionFailed:
+		return []eventlog.Entry{eventlog.Error("compaction failed", "error", e.Err, "duration", e.Duration)}
+	}
+	return nil
+}
+
+func (o *Options) emit(e Event) {
+	for _, listener := range o.listeners {
+		listener(e)
+	}
+}
@@ -0,0 +1,147 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package compacter_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jacekolszak/deebee/compacter"
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnEvent(t *testing.T) {
+
+	t.Run("should emit events for deleted versions", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		v1 := tests.WriteData(t, s, []byte("v1"))
+		v2 := tests.WriteData(t, s, []byte("v2"))
+		tests.WriteData(t, s, []byte("v3"))
+		recorder := &tests.EventRecorder{}
+		// when
+		err := compacter.RunOnce(s, compacter.OnEvent(recordEvent(recorder)))
+		// then
+		require.NoError(t, err)
+		events := recorder.Events()
+		require.Len(t, events, 3)
+		assertVersionDeleted(t, v1, events[0])
+		assertVersionDeleted(t, v2, events[1])
+		succeeded, ok := events[2].(compacter.CompactionSucceeded)
+		require.True(t, ok, "CompactionSucceeded expected")
+		assert.Len(t, succeeded.Deleted, 2)
+		assert.False(t, succeeded.DryRun)
+	})
+
+	t.Run("should emit CompactionSucceeded for DryRun", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		tests.WriteData(t, s, []byte("v1"))
+		tests.WriteData(t, s, []byte("v2"))
+		recorder := &tests.EventRecorder{}
+		// when
+		_, err := compacter.DryRun(s, compacter.OnEvent(recordEvent(recorder)))
+		// then
+		require.NoError(t, err)
+		events := recorder.Events()
+		require.Len(t, events, 1)
+		succeeded, ok := events[0].(compacter.CompactionSucceeded)
+		require.True(t, ok, "CompactionSucceeded expected")
+		assert.Len(t, succeeded.Deleted, 1)
+		assert.True(t, succeeded.DryRun)
+	})
+
+	t.Run("should emit CompactionFailed", func(t *testing.T) {
+		versionsError := errors.New("versions failed")
+		s := &tests.StoreMock{ReturnVersionsError: versionsError}
+		recorder := &tests.EventRecorder{}
+		// when
+		err := compacter.RunOnce(storeWithDelete{s}, compacter.OnEvent(recordEvent(recorder)))
+		// then
+		require.Error(t, err)
+		events := recorder.Events()
+		require.Len(t, events, 1)
+		failed, ok := events[0].(compacter.CompactionFailed)
+		require.True(t, ok, "CompactionFailed expected")
+		assert.ErrorIs(t, failed.Err, versionsError)
+	})
+
+	t.Run("should emit events when running in the background", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		tests.WriteData(t, s, []byte("v1"))
+		tests.WriteData(t, s, []byte("v2"))
+		recorder := &tests.EventRecorder{}
+		ctx, cancel := context.WithCancel(context.Background())
+		async := tests.RunAsync(func() {
+			_ = compacter.Start(ctx, s, compacter.Interval(time.Millisecond), compacter.OnEvent(recordEvent(recorder)))
+		})
+		// then
+		assert.Eventually(t, func() bool {
+			for _, e := range recorder.Events() {
+				if _, ok := e.(compacter.VersionDeleted); ok {
+					return true
+				}
+			}
+			return false
+		}, time.Second, time.Millisecond)
+		// cleanup
+		cancel()
+		async.WaitOrFailAfter(t, time.Second)
+	})
+}
+
+func TestLog(t *testing.T) {
+
+	t.Run("should log events", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		v1 := tests.WriteData(t, s, []byte("v1"))
+		tests.WriteData(t, s, []byte("v2"))
+		logger := &tests.LoggerMock{}
+		// when
+		err := compacter.RunOnce(s, compacter.Log(logger))
+		// then
+		require.NoError(t, err)
+		entries := logger.Entries()
+		require.Len(t, entries, 2)
+		assert.Equal(t, "INFO", entries[0].Level)
+		require.Len(t, entries[0].Args, 2)
+		assert.Equal(t, "version", entries[0].Args[0])
+		assert.True(t, v1.Time.Equal(entries[0].Args[1].(time.Time)))
+		assert.Equal(t, "DEBUG", entries[1].Level)
+	})
+
+	t.Run("should log failure", func(t *testing.T) {
+		s := &tests.StoreMock{ReturnVersionsError: errors.New("versions failed")}
+		logger := &tests.LoggerMock{}
+		// when
+		_ = compacter.RunOnce(storeWithDelete{s}, compacter.Log(logger))
+		// then
+		entries := logger.Entries()
+		require.Len(t, entries, 1)
+		assert.Equal(t, "ERROR", entries[0].Level)
+	})
+}
+
+func recordEvent(recorder *tests.EventRecorder) func(compacter.Event) {
+	return func(e compacter.Event) {
+		recorder.Record(e)
+	}
+}
+
+func assertVersionDeleted(t *testing.T, expected store.Version, event interface{}) {
+	deleted, ok := event.(compacter.VersionDeleted)
+	require.True(t, ok, "VersionDeleted expected")
+	assert.True(t, expected.Time.Equal(deleted.Version.Time))
+}
+
+type storeWithDelete struct {
+	*tests.StoreMock
+}
+
+func (s storeWithDelete) DeleteVersion(time.Time) error {
+	return nil
+}
@@ -0,0 +1,139 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package compacter
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jacekolszak/deebee/store"
+)
+
+// retentionPolicy marks versions which should be kept. Versions are sorted by time, oldest first.
+type retentionPolicy func(versions []store.Version, now time.Time, keep []bool)
+
+// KeepLast keeps n most recent versions
+func KeepLast(n int) Option {
+	return func(options *Options) error {
+		if n < 0 {
+			return errors.New("negative number of versions to keep")
+		}
+		options.policies = append(options.policies, func(versions []store.Version, _ time.Time, keep []bool) {
+			for i := len(versions) - 1; i >= 0 && i >= len(versions)-n; i-- {
+				keep[i] = true
+			}
+		})
+		return nil
+	}
+}
+
+// KeepYoungerThan keeps all versions younger than d
+func KeepYoungerThan(d time.Duration) Option {
+	return func(options *Options) error {
+		if d < 0 {
+			return errors.New("negative duration")
+		}
+		options.policies = append(options.policies, func(versions []store.Version, now time.Time, keep []bool) {
+			for i, v := range versions {
+				if now.Sub(v.Time) < d {
+					keep[i] = true
+				}
+			}
+		})
+		return nil
+	}
+}
+
+// KeepHourly keeps the most recent version for each of the last n hours for which there are versions
+func KeepHourly(n int) Option {
+	return keepOnePerPeriod(n, func(t time.Time) string {
+		return t.UTC().Format("2006-01-02T15")
+	})
+}
+
+// KeepDaily keeps the most recent version for each of the last n days for which there are versions
+func KeepDaily(n int) Option {
+	return keepOnePerPeriod(n, func(t time.Time) string {
+		return t.UTC().Format("2006-01-02")
+	})
+}
+
+// KeepWeekly keeps the most recent version for each of the last n ISO weeks for which there are versions
+func KeepWeekly(n int) Option {
+	return keepOnePerPeriod(n, func(t time.Time) string {
+		year, week := t.UTC().ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+}
+
+func keepOnePerPeriod(n int, period func(time.Time) string) Option {
+	return func(options *Options) error {
+		if n < 0 {
+			return errors.New("negative number of periods to keep")
+		}
+		options.policies = append(options.policies, func(versions []store.Version, _ time.Time, keep []bool) {
+			periods := 0
+			lastPeriod := ""
+			for i := len(versions) - 1; i >= 0 && periods < n; i-- {
+				p := period(versions[i].Time)
+				if p != lastPeriod {
+					keep[i] = true
+					lastPeriod = p
+					periods++
+				}
+			}
+		})
+		return nil
+	}
+}
+
+// MaxTotalSize deletes the oldest versions, including the ones retained by Keep options, until the total size of
+// versions does not exceed the given number of bytes. The latest integral version and versions newer than it are
+// never deleted though. Size must be positive.
+func MaxTotalSize(bytes int64) Option {
+	return func(options *Options) error {
+		if bytes <= 0 {
+			return errors.New("total size must be positive")
+		}
+		options.maxTotalSize = bytes
+		return nil
+	}
+}
+
+// versionsToDelete returns versions older than the latest integral one which are not retained by any policy.
+// latestIntegral is an index of the latest integral version.
+func (o *Options) versionsToDelete(versions []store.Version, latestIntegral int) []store.Version {
+	keep := make([]bool, len(versions))
+	for i := latestIntegral; i < len(versions); i++ {
+		keep[i] = true
+	}
+	now := time.Now()
+	for _, policy := range o.policies {
+		policy(versions, now, keep)
+	}
+
+	if o.maxTotalSize > 0 { // 0 when MaxTotalSize was not used
+		var totalSize int64
+		for i, v := range versions {
+			if keep[i] {
+				totalSize += v.Size
+			}
+		}
+		for i := 0; i < latestIntegral && totalSize > o.maxTotalSize; i++ {
+			if keep[i] {
+				keep[i] = false
+				totalSize -= versions[i].Size
+			}
+		}
+	}
+
+	var toDelete []store.Version
+	for i, v := range versions {
+		if !keep[i] {
+			toDelete = append(toDelete, v)
+		}
+	}
+	return toDelete
+}
@@ -0,0 +1,211 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package compacter_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jacekolszak/deebee/compacter"
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetentionPolicies(t *testing.T) {
+
+	t.Run("should return error for negative values", func(t *testing.T) {
+		options := map[string]compacter.Option{
+			"KeepLast":        compacter.KeepLast(-1),
+			"KeepYoungerThan": compacter.KeepYoungerThan(-time.Second),
+			"KeepHourly":      compacter.KeepHourly(-1),
+			"KeepDaily":       compacter.KeepDaily(-1),
+			"KeepWeekly":      compacter.KeepWeekly(-1),
+			"MaxTotalSize":    compacter.MaxTotalSize(-1),
+		}
+		for name, option := range options {
+			t.Run(name, func(t *testing.T) {
+				err := compacter.RunOnce(tests.OpenStore(t), option)
+				assert.Error(t, err)
+			})
+		}
+	})
+
+	t.Run("KeepLast should keep n most recent versions", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		writeVersions(t, s, "2021-01-01T10:00:00Z", "2021-01-01T11:00:00Z", "2021-01-01T12:00:00Z", "2021-01-01T13:00:00Z")
+		// when
+		err := compacter.RunOnce(s, compacter.KeepLast(3))
+		// then
+		require.NoError(t, err)
+		assertVersions(t, s, "2021-01-01T11:00:00Z", "2021-01-01T12:00:00Z", "2021-01-01T13:00:00Z")
+	})
+
+	t.Run("KeepYoungerThan should keep versions younger than given duration", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		now := time.Now()
+		old := tests.WriteData(t, s, []byte("old"), store.WriteTime(now.Add(-2*time.Hour)))
+		young := tests.WriteData(t, s, []byte("young"), store.WriteTime(now.Add(-time.Minute)))
+		latest := tests.WriteData(t, s, []byte("latest"), store.WriteTime(now))
+		// when
+		toDelete, err := compacter.DryRun(s, compacter.KeepYoungerThan(time.Hour))
+		// then
+		require.NoError(t, err)
+		require.Len(t, toDelete, 1)
+		assert.True(t, old.Time.Equal(toDelete[0].Time))
+		// and when
+		err = compacter.RunOnce(s, compacter.KeepYoungerThan(time.Hour))
+		// then
+		require.NoError(t, err)
+		versions := readVersions(t, s)
+		require.Len(t, versions, 2)
+		assert.True(t, young.Time.Equal(versions[0].Time))
+		assert.True(t, latest.Time.Equal(versions[1].Time))
+	})
+
+	t.Run("KeepHourly should keep the most recent version in each hour", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		writeVersions(t, s,
+			"2021-01-01T09:30:00Z",
+			"2021-01-01T10:10:00Z", "2021-01-01T10:50:00Z",
+			"2021-01-01T11:10:00Z", "2021-01-01T11:20:00Z",
+			"2021-01-01T12:00:00Z")
+		// when
+		err := compacter.RunOnce(s, compacter.KeepHourly(3))
+		// then
+		require.NoError(t, err)
+		assertVersions(t, s, "2021-01-01T10:50:00Z", "2021-01-01T11:20:00Z", "2021-01-01T12:00:00Z")
+	})
+
+	t.Run("KeepDaily should keep the most recent version in each day", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		writeVersions(t, s,
+			"2021-01-01T10:00:00Z", "2021-01-01T20:00:00Z",
+			"2021-01-03T10:00:00Z", "2021-01-03T20:00:00Z")
+		// when
+		err := compacter.RunOnce(s, compacter.KeepDaily(7))
+		// then
+		require.NoError(t, err)
+		assertVersions(t, s, "2021-01-01T20:00:00Z", "2021-01-03T20:00:00Z")
+	})
+
+	t.Run("KeepWeekly should keep the most recent version in each week", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		writeVersions(t, s,
+			"2021-01-04T10:00:00Z", "2021-01-06T10:00:00Z", // Monday and Wednesday of week 1
+			"2021-01-11T10:00:00Z", "2021-01-12T10:00:00Z", // Monday and Tuesday of week 2
+			"2021-01-18T10:00:00Z")
+		// when
+		err := compacter.RunOnce(s, compacter.KeepWeekly(2))
+		// then
+		require.NoError(t, err)
+		assertVersions(t, s, "2021-01-12T10:00:00Z", "2021-01-18T10:00:00Z")
+	})
+
+	t.Run("should keep versions retained by any policy", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		writeVersions(t, s,
+			"2021-01-01T10:00:00Z", "2021-01-01T20:00:00Z",
+			"2021-01-02T10:00:00Z", "2021-01-02T11:00:00Z", "2021-01-02T12:00:00Z")
+		// when
+		err := compacter.RunOnce(s, compacter.KeepLast(2), compacter.KeepDaily(2))
+		// then
+		require.NoError(t, err)
+		assertVersions(t, s, "2021-01-01T20:00:00Z", "2021-01-02T11:00:00Z", "2021-01-02T12:00:00Z")
+	})
+
+	t.Run("MaxTotalSize should return error for zero size", func(t *testing.T) {
+		err := compacter.RunOnce(tests.OpenStore(t), compacter.MaxTotalSize(0))
+		assert.Error(t, err)
+	})
+
+	t.Run("MaxTotalSize should delete oldest versions retained by policies", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		writeVersions(t, s, "2021-01-01T10:00:00Z", "2021-01-01T11:00:00Z", "2021-01-01T12:00:00Z", "2021-01-01T13:00:00Z")
+		// when
+		err := compacter.RunOnce(s, compacter.KeepLast(4), compacter.MaxTotalSize(2*versionSize))
+		// then
+		require.NoError(t, err)
+		assertVersions(t, s, "2021-01-01T12:00:00Z", "2021-01-01T13:00:00Z")
+	})
+
+	t.Run("MaxTotalSize should never delete the latest integral version", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		writeVersions(t, s, "2021-01-01T10:00:00Z", "2021-01-01T11:00:00Z")
+		// when
+		err := compacter.RunOnce(s, compacter.KeepLast(2), compacter.MaxTotalSize(1))
+		// then
+		require.NoError(t, err)
+		assertVersions(t, s, "2021-01-01T11:00:00Z")
+	})
+
+	t.Run("should keep versions newer than the latest integral one", func(t *testing.T) {
+		s := storeWithLastVersionCorrupted(t)
+		// when
+		err := compacter.RunOnce(s, compacter.KeepLast(1))
+		// then
+		require.NoError(t, err)
+		assert.Len(t, readVersions(t, s), 2) // one integral and one corrupted
+	})
+}
+
+func TestDryRun(t *testing.T) {
+
+	t.Run("should return error for nil store", func(t *testing.T) {
+		_, err := compacter.DryRun(nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("should return versions to delete without deleting them", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		writeVersions(t, s, "2021-01-01T10:00:00Z", "2021-01-01T11:00:00Z", "2021-01-01T12:00:00Z")
+		versionsBefore := readVersions(t, s)
+		// when
+		toDelete, err := compacter.DryRun(s, compacter.KeepLast(2))
+		// then
+		require.NoError(t, err)
+		require.Len(t, toDelete, 1)
+		assert.Equal(t, versionsBefore[0], toDelete[0])
+		assert.Equal(t, versionsBefore, readVersions(t, s))
+	})
+
+	t.Run("should return nothing for store with one version", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		writeVersions(t, s, "2021-01-01T10:00:00Z")
+		// when
+		toDelete, err := compacter.DryRun(s)
+		// then
+		require.NoError(t, err)
+		assert.Empty(t, toDelete)
+	})
+}
+
+var versionSize = int64(len("data"))
+
+func writeVersions(t *testing.T, s *store.Store, times ...string) {
+	for _, v := range times {
+		tests.WriteData(t, s, []byte("data"), store.WriteTime(parseTime(t, v)))
+	}
+}
+
+func assertVersions(t *testing.T, s *store.Store, times ...string) {
+	versions := readVersions(t, s)
+	require.Len(t, versions, len(times))
+	for i, v := range times {
+		assert.True(t, parseTime(t, v).Equal(versions[i].Time), "expected %s, got %s", v, versions[i].Time)
+	}
+}
+
+func readVersions(t *testing.T, s *store.Store) []store.Version {
+	versions, err := s.Versions()
+	require.NoError(t, err)
+	return versions
+}
+
+func parseTime(t *testing.T, s string) time.Time {
+	parsed, err := time.Parse(time.RFC3339, s)
+	require.NoError(t, err)
+	return parsed
+}
@@ -0,0 +1,126 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+// Package encryption provides at-rest encryption of state versions. Data is encrypted with AES-GCM in chunks, so
+// versions of any size can be written and read without loading them into memory. Each version is encrypted with
+// its own key derived from the master key, so nonces are never reused, no matter how many versions are written.
+// Each version starts with a header containing the id of the master key, so versions written with previous keys
+// are still readable after the key was rotated.
+package encryption
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jacekolszak/deebee/store"
+)
+
+// Store is a store which can be wrapped with encryption. store.Store implements this interface.
+type Store interface {
+	Versions() ([]store.Version, error)
+	Reader(...store.ReaderOption) (store.Reader, error)
+	Writer(...store.WriterOption) (store.Writer, error)
+	DeleteVersion(time.Time) error
+}
+
+// Wrap returns a store which encrypts data written to s and decrypts data read from s.
+func Wrap(s Store, keys KeyProvider) (*EncryptedStore, error) {
+	if s == nil {
+		return nil, errors.New("nil store")
+	}
+	if keys == nil {
+		return nil, errors.New("nil key provider")
+	}
+	return &EncryptedStore{store: s, keys: keys}, nil
+}
+
+// EncryptedStore can be used everywhere where store.Store is used - with codec, compacter and replicator packages.
+// Please note that version sizes returned by Versions are sizes of encrypted data.
+type EncryptedStore struct {
+	store Store
+	keys  KeyProvider
+}
+
+func (s *EncryptedStore) Versions() ([]store.Version, error) {
+	return s.store.Versions()
+}
+
+func (s *EncryptedStore) Reader(options ...store.ReaderOption) (store.Reader, error) {
+	reader, err := s.store.Reader(options...)
+	if err != nil {
+		return nil, err
+	}
+	return NewReader(reader, s.keys), nil
+}
+
+func (s *EncryptedStore) Writer(options ...store.WriterOption) (store.Writer, error) {
+	writer, err := s.store.Writer(options...)
+	if err != nil {
+		return nil, err
+	}
+	encryptingWriter, err := NewWriter(writer, s.keys)
+	if err != nil {
+		writer.AbortAndClose()
+		return nil, err
+	}
+	return encryptingWriter, nil
+}
+
+func (s *EncryptedStore) DeleteVersion(t time.Time) error {
+	return s.store.DeleteVersion(t)
+}
+
+// KeyProvider provides keys for encryption and decryption. Keys must be 16, 24 or 32 bytes long to select
+// AES-128, AES-192 or AES-256.
+type KeyProvider interface {
+	// CurrentKey returns the key used for encrypting new versions
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with a given id. It is used for decrypting versions.
+	Key(id string) ([]byte, error)
+}
+
+// NewKeyRing returns KeyProvider with a static set of keys. currentID is the id of the key used for encrypting new
+// versions. All keys can be used for decryption. Key ids must be at most 255 bytes long.
+func NewKeyRing(currentID string, keys map[string][]byte) (*KeyRing, error) {
+	if _, ok := keys[currentID]; !ok {
+		return nil, fmt.Errorf("current key %s not found", currentID)
+	}
+	copied := map[string][]byte{}
+	for id, key := range keys {
+		if len(id) > maxKeyIDLength {
+			return nil, fmt.Errorf("key id %s is longer than %d bytes", id, maxKeyIDLength)
+		}
+		if err := validateKey(key); err != nil {
+			return nil, fmt.Errorf("invalid key %s: %w", id, err)
+		}
+		copied[id] = append([]byte{}, key...)
+	}
+	return &KeyRing{current: currentID, keys: copied}, nil
+}
+
+type KeyRing struct {
+	current string
+	keys    map[string][]byte
+}
+
+func (k *KeyRing) CurrentKey() (string, []byte, error) {
+	return k.current, k.keys[k.current], nil
+}
+
+func (k *KeyRing) Key(id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("key %s not found", id)
+	}
+	return key, nil
+}
+
+func validateKey(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	default:
+		return fmt.Errorf("invalid key length %d: must be 16, 24 or 32", len(key))
+	}
+}
@@ -0,0 +1,275 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package encryption_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/jacekolszak/deebee/codec"
+	"github.com/jacekolszak/deebee/compacter"
+	"github.com/jacekolszak/deebee/encryption"
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	key1 = bytes.Repeat([]byte{1}, 32)
+	key2 = bytes.Repeat([]byte{2}, 16)
+)
+
+func TestWrap(t *testing.T) {
+
+	t.Run("should return error for nil store", func(t *testing.T) {
+		s, err := encryption.Wrap(nil, keyRing(t, "1"))
+		assert.Error(t, err)
+		assert.Nil(t, s)
+	})
+
+	t.Run("should return error for nil key provider", func(t *testing.T) {
+		s, err := encryption.Wrap(tests.OpenStore(t), nil)
+		assert.Error(t, err)
+		assert.Nil(t, s)
+	})
+}
+
+func TestNewKeyRing(t *testing.T) {
+
+	t.Run("should return error when current key is missing", func(t *testing.T) {
+		_, err := encryption.NewKeyRing("missing", map[string][]byte{"1": key1})
+		assert.Error(t, err)
+	})
+
+	t.Run("should return error for invalid key length", func(t *testing.T) {
+		_, err := encryption.NewKeyRing("1", map[string][]byte{"1": []byte("short")})
+		assert.Error(t, err)
+	})
+
+	t.Run("should return error for too long key id", func(t *testing.T) {
+		id := string(bytes.Repeat([]byte("a"), 256))
+		_, err := encryption.NewKeyRing(id, map[string][]byte{id: key1})
+		assert.Error(t, err)
+	})
+}
+
+func TestEncryptedStore(t *testing.T) {
+
+	t.Run("should read encrypted data", func(t *testing.T) {
+		sizes := []int{0, 1, 65535, 65536, 65537, 3*65536 + 100}
+		for _, size := range sizes {
+			t.Run(fmt.Sprintf("size %d", size), func(t *testing.T) {
+				s := encryptedStore(t, tests.OpenStore(t), keyRing(t, "1"))
+				data := newData(size)
+				writeData(t, s, data)
+				// when
+				dataRead := readData(t, s)
+				// then
+				assert.Equal(t, data, dataRead)
+			})
+		}
+	})
+
+	t.Run("should not store plaintext on disk", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s := encryptedStore(t, openStore(t, dir), keyRing(t, "1"))
+		data := []byte("secret data")
+		// when
+		writeData(t, s, data)
+		// then
+		for _, content := range filesContent(t, dir) {
+			assert.NotContains(t, string(content), string(data))
+		}
+	})
+
+	t.Run("should read versions encrypted with previous key after key rotation", func(t *testing.T) {
+		plainStore := tests.OpenStore(t)
+		oldData := []byte("old")
+		oldVersion := writeData(t, encryptedStore(t, plainStore, keyRing(t, "1")), oldData)
+		s := encryptedStore(t, plainStore, keyRing(t, "2"))
+		newData := []byte("new")
+		newVersion := writeData(t, s, newData)
+		// expect
+		assert.Equal(t, oldData, readData(t, s, store.Time(oldVersion.Time)))
+		assert.Equal(t, newData, readData(t, s, store.Time(newVersion.Time)))
+	})
+
+	t.Run("should read versions encrypted in legacy format", func(t *testing.T) {
+		plainStore := tests.OpenStore(t)
+		// "legacy data" encrypted with key1 using DBE1 format
+		legacy, err := hex.DecodeString("4442453101316eaeaa3e846675010000001b52a1db082861f29ead5530f184f15ae31cf1c318f47bace91ccd41")
+		require.NoError(t, err)
+		tests.WriteData(t, plainStore, legacy)
+		s := encryptedStore(t, plainStore, keyRing(t, "1"))
+		// when
+		data := readData(t, s)
+		// then
+		assert.Equal(t, []byte("legacy data"), data)
+	})
+
+	t.Run("should encrypt each version with different salt", func(t *testing.T) {
+		plainStore := tests.OpenStore(t)
+		s := encryptedStore(t, plainStore, keyRing(t, "1"))
+		v1 := writeData(t, s, []byte("data"))
+		v2 := writeData(t, s, []byte("data"))
+		// when
+		encrypted1 := tests.ReadData(t, plainStore, store.Time(v1.Time))
+		encrypted2 := tests.ReadData(t, plainStore, store.Time(v2.Time))
+		// then
+		headerLength := len("DBE2") + 1 + len("1") + 32
+		assert.Equal(t, []byte("DBE2"), encrypted1[:4])
+		assert.NotEqual(t, encrypted1[:headerLength], encrypted2[:headerLength])
+		assert.NotEqual(t, encrypted1[headerLength:], encrypted2[headerLength:])
+	})
+
+	t.Run("should return error when key is not available", func(t *testing.T) {
+		plainStore := tests.OpenStore(t)
+		writeData(t, encryptedStore(t, plainStore, keyRing(t, "2")), []byte("data"))
+		keys, err := encryption.NewKeyRing("1", map[string][]byte{"1": key1})
+		require.NoError(t, err)
+		s := encryptedStore(t, plainStore, keys)
+		// when
+		_, err = codec.Read(s, readAll)
+		// then
+		assert.Error(t, err)
+	})
+
+	t.Run("should return error when data is not encrypted", func(t *testing.T) {
+		plainStore := tests.OpenStore(t)
+		tests.WriteData(t, plainStore, []byte("not encrypted data"))
+		s := encryptedStore(t, plainStore, keyRing(t, "1"))
+		// when
+		_, err := codec.Read(s, readAll)
+		// then
+		assert.Error(t, err)
+	})
+
+	t.Run("should return error when encrypted data was tampered", func(t *testing.T) {
+		modifications := map[string]func([]byte) []byte{
+			"flipped bit": func(data []byte) []byte {
+				data[len(data)-1] ^= 1
+				return data
+			},
+			"truncated": func(data []byte) []byte {
+				return data[:len(data)-100]
+			},
+			"last chunk removed": func(data []byte) []byte {
+				return data[:len(data)-emptyChunkLength]
+			},
+			"appended data": func(data []byte) []byte {
+				return append(data, 1)
+			},
+		}
+		for name, modify := range modifications {
+			t.Run(name, func(t *testing.T) {
+				// store without integrity check so only encryption can detect tampering
+				plainStore := tests.OpenStore(t, store.NoIntegrityCheck)
+				writeData(t, encryptedStore(t, plainStore, keyRing(t, "1")), newData(65536))
+				encrypted := tests.ReadData(t, plainStore)
+				tests.WriteData(t, plainStore, modify(encrypted))
+				s := encryptedStore(t, plainStore, keyRing(t, "1"))
+				// when
+				_, err := codec.Read(s, readAll)
+				// then
+				assert.Error(t, err)
+			})
+		}
+	})
+
+	t.Run("should abort writer when encoder failed", func(t *testing.T) {
+		s := encryptedStore(t, tests.OpenStore(t), keyRing(t, "1"))
+		encoder := func(io.Writer) error {
+			return fmt.Errorf("error")
+		}
+		// when
+		err := codec.Write(s, encoder)
+		// then
+		require.Error(t, err)
+		versions, err := s.Versions()
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+
+	t.Run("should compact encrypted store", func(t *testing.T) {
+		s := encryptedStore(t, tests.OpenStore(t), keyRing(t, "1"))
+		writeData(t, s, []byte("v1"))
+		writeData(t, s, []byte("v2"))
+		// when
+		err := compacter.RunOnce(s)
+		// then
+		require.NoError(t, err)
+		versions, err := s.Versions()
+		require.NoError(t, err)
+		assert.Len(t, versions, 1)
+	})
+}
+
+const emptyChunkLength = 5 + 16 // chunk header and GCM tag
+
+func keyRing(t *testing.T, current string) encryption.KeyProvider {
+	keys, err := encryption.NewKeyRing(current, map[string][]byte{"1": key1, "2": key2})
+	require.NoError(t, err)
+	return keys
+}
+
+func encryptedStore(t *testing.T, s encryption.Store, keys encryption.KeyProvider) *encryption.EncryptedStore {
+	encrypted, err := encryption.Wrap(s, keys)
+	require.NoError(t, err)
+	return encrypted
+}
+
+func openStore(t *testing.T, dir string) *store.Store {
+	s, err := store.Open(dir)
+	require.NoError(t, err)
+	return s
+}
+
+func writeData(t *testing.T, s codec.WriteOnlyStore, data []byte) store.Version {
+	writer, err := s.Writer()
+	require.NoError(t, err)
+	_, err = writer.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	return writer.Version()
+}
+
+func readData(t *testing.T, s codec.ReadOnlyStore, options ...store.ReaderOption) []byte {
+	reader, err := s.Reader(options...)
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	return data
+}
+
+func readAll(reader io.Reader) error {
+	_, err := io.ReadAll(reader)
+	return err
+}
+
+func newData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	return data
+}
+
+func filesContent(t *testing.T, dir string) [][]byte {
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	var contents [][]byte
+	for _, file := range files {
+		content, err := ioutil.ReadFile(path.Join(dir, file.Name()))
+		require.NoError(t, err)
+		contents = append(contents, content)
+	}
+	return contents
+}
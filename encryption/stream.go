@@ -0,0 +1,333 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/jacekolszak/deebee/store"
+)
+
+// Encrypted data format:
+//
+//	header: magic (4 bytes) | key id length (1 byte) | key id | salt (32 bytes)
+//	chunk:  last chunk flag (1 byte) | sealed data length (4 bytes, big endian) | sealed data
+//
+// Each version is encrypted with its own key, derived from the master key and random salt using HKDF-SHA256.
+// Therefore nonces can be simply built from the chunk number and last chunk flag - they are never reused with
+// the same key, no matter how many versions are written. Each chunk contains at most chunkSize bytes of plaintext
+// and is sealed with AES-GCM. Header is used as additional authenticated data. This way reordering, removing or
+// truncating chunks, as well as changing the header, is detected when reading.
+//
+// Legacy format (magic DBE1) has 7 bytes of random nonce prefix instead of salt, and chunks are sealed with the
+// master key directly. It is still supported for reading.
+const (
+	magic             = "DBE2"
+	legacyMagic       = "DBE1"
+	maxKeyIDLength    = math.MaxUint8
+	saltLength        = 32
+	noncePrefixLength = 7
+	chunkSize         = 64 * 1024
+	chunkHeaderLength = 5
+	lastChunk         = 1
+)
+
+// keyDerivationInfo binds derived keys to their purpose
+const keyDerivationInfo = "deebee encryption " + magic
+
+// NewWriter returns a writer which encrypts data using the current key from KeyProvider and writes it to w.
+func NewWriter(w store.Writer, keys KeyProvider) (store.Writer, error) {
+	if w == nil {
+		return nil, errors.New("nil writer")
+	}
+	if keys == nil {
+		return nil, errors.New("nil key provider")
+	}
+	keyID, key, err := keys.CurrentKey()
+	if err != nil {
+		return nil, fmt.Errorf("error getting current key: %w", err)
+	}
+	if len(keyID) > maxKeyIDLength {
+		return nil, fmt.Errorf("key id %s is longer than %d bytes", keyID, maxKeyIDLength)
+	}
+	if err = validateKey(key); err != nil {
+		return nil, fmt.Errorf("invalid key %s: %w", keyID, err)
+	}
+	salt := make([]byte, saltLength)
+	if _, err = rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("error generating salt: %w", err)
+	}
+	aead, err := newAEAD(deriveKey(key, salt))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %s: %w", keyID, err)
+	}
+
+	header := encodeHeader(keyID, salt)
+	if _, err = w.Write(header); err != nil {
+		return nil, fmt.Errorf("error writing encryption header: %w", err)
+	}
+
+	return &writer{
+		writer:      w,
+		aead:        aead,
+		header:      header,
+		noncePrefix: make([]byte, noncePrefixLength),
+		plaintext:   make([]byte, 0, chunkSize),
+	}, nil
+}
+
+type writer struct {
+	writer      store.Writer
+	aead        cipher.AEAD
+	header      []byte
+	noncePrefix []byte
+	counter     uint32
+	plaintext   []byte // buffered data of the current chunk
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(w.plaintext) == chunkSize {
+			// chunk is sealed only when more data arrives, because the last chunk must be marked as such
+			if err := w.writeChunk(0); err != nil {
+				return written, err
+			}
+		}
+		n := copy(w.plaintext[len(w.plaintext):chunkSize], p)
+		w.plaintext = w.plaintext[:len(w.plaintext)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *writer) writeChunk(flag byte) error {
+	if w.counter == math.MaxUint32 {
+		return errors.New("too much data to encrypt")
+	}
+	sealed := w.aead.Seal(nil, nonce(w.noncePrefix, w.counter, flag), w.plaintext, w.header)
+	w.counter++
+	w.plaintext = w.plaintext[:0]
+
+	chunkHeader := make([]byte, chunkHeaderLength)
+	chunkHeader[0] = flag
+	binary.BigEndian.PutUint32(chunkHeader[1:], uint32(len(sealed)))
+	if _, err := w.writer.Write(chunkHeader); err != nil {
+		return err
+	}
+	_, err := w.writer.Write(sealed)
+	return err
+}
+
+func (w *writer) Close() error {
+	if err := w.writeChunk(lastChunk); err != nil {
+		w.writer.AbortAndClose()
+		return fmt.Errorf("error writing last encrypted chunk: %w", err)
+	}
+	return w.writer.Close()
+}
+
+func (w *writer) Version() store.Version {
+	return w.writer.Version()
+}
+
+func (w *writer) AbortAndClose() {
+	w.writer.AbortAndClose()
+}
+
+// NewReader returns a reader which decrypts data read from r. The key is taken from KeyProvider using the key id
+// saved in the header.
+func NewReader(r store.Reader, keys KeyProvider) store.Reader {
+	return &reader{
+		reader: r,
+		keys:   keys,
+	}
+}
+
+type reader struct {
+	reader      store.Reader
+	keys        KeyProvider
+	aead        cipher.AEAD // nil until header is read
+	header      []byte
+	noncePrefix []byte
+	counter     uint32
+	plaintext   []byte // decrypted data not returned yet
+	lastChunk   bool
+	err         error
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.aead == nil {
+		if r.err = r.readHeader(); r.err != nil {
+			return 0, r.err
+		}
+	}
+	for len(r.plaintext) == 0 {
+		if r.lastChunk {
+			r.err = r.readEOF()
+			return 0, r.err
+		}
+		if r.err = r.readChunk(); r.err != nil {
+			return 0, r.err
+		}
+	}
+	n := copy(p, r.plaintext)
+	r.plaintext = r.plaintext[n:]
+	return n, nil
+}
+
+func (r *reader) readHeader() error {
+	if r.keys == nil {
+		return errors.New("nil key provider")
+	}
+	fixed := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(r.reader, fixed); err != nil {
+		return fmt.Errorf("error reading encryption header: %w", unexpectedEOF(err))
+	}
+	legacy := string(fixed[:len(magic)]) == legacyMagic
+	if string(fixed[:len(magic)]) != magic && !legacy {
+		return errors.New("data is not encrypted or encryption format is not supported")
+	}
+	trailerLength := saltLength
+	if legacy {
+		trailerLength = noncePrefixLength
+	}
+	variable := make([]byte, int(fixed[len(magic)])+trailerLength)
+	if _, err := io.ReadFull(r.reader, variable); err != nil {
+		return fmt.Errorf("error reading encryption header: %w", unexpectedEOF(err))
+	}
+	keyID := string(variable[:len(variable)-trailerLength])
+	trailer := variable[len(variable)-trailerLength:]
+	key, err := r.keys.Key(keyID)
+	if err != nil {
+		return fmt.Errorf("error getting key %s: %w", keyID, err)
+	}
+	if err = validateKey(key); err != nil {
+		return fmt.Errorf("invalid key %s: %w", keyID, err)
+	}
+	if legacy {
+		r.noncePrefix = trailer
+	} else {
+		r.noncePrefix = make([]byte, noncePrefixLength)
+		key = deriveKey(key, trailer)
+	}
+	r.aead, err = newAEAD(key)
+	if err != nil {
+		return fmt.Errorf("invalid key %s: %w", keyID, err)
+	}
+	r.header = append(fixed, variable...)
+	return nil
+}
+
+func (r *reader) readChunk() error {
+	chunkHeader := make([]byte, chunkHeaderLength)
+	if _, err := io.ReadFull(r.reader, chunkHeader); err != nil {
+		return fmt.Errorf("error reading encrypted chunk: %w", unexpectedEOF(err))
+	}
+	flag := chunkHeader[0]
+	length := binary.BigEndian.Uint32(chunkHeader[1:])
+	if length > chunkSize+uint32(r.aead.Overhead()) {
+		return fmt.Errorf("encrypted chunk too big: %d", length)
+	}
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(r.reader, sealed); err != nil {
+		return fmt.Errorf("error reading encrypted chunk: %w", unexpectedEOF(err))
+	}
+	plaintext, err := r.aead.Open(sealed[:0], nonce(r.noncePrefix, r.counter, flag), sealed, r.header)
+	if err != nil {
+		return fmt.Errorf("error decrypting chunk %d: %w", r.counter, err)
+	}
+	r.counter++
+	r.plaintext = plaintext
+	r.lastChunk = flag == lastChunk
+	return nil
+}
+
+// readEOF makes sure there is no data after the last chunk. Underlying reader must be read until io.EOF is returned,
+// because store.Reader validates the checksum then.
+func (r *reader) readEOF() error {
+	n, err := r.reader.Read(make([]byte, 1))
+	if n > 0 {
+		return errors.New("unexpected data after last encrypted chunk")
+	}
+	if err == nil {
+		return r.readEOF()
+	}
+	return err
+}
+
+func (r *reader) Close() error {
+	return r.reader.Close()
+}
+
+func (r *reader) Version() store.Version {
+	return r.reader.Version()
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encodeHeader(keyID string, salt []byte) []byte {
+	var header bytes.Buffer
+	header.WriteString(magic)
+	header.WriteByte(byte(len(keyID)))
+	header.WriteString(keyID)
+	header.Write(salt)
+	return header.Bytes()
+}
+
+// deriveKey derives the key of a single version. Derived key has the same length as the master key, so the same
+// AES variant is used.
+func deriveKey(masterKey, salt []byte) []byte {
+	return hkdf(masterKey, salt, []byte(keyDerivationInfo), len(masterKey))
+}
+
+// hkdf implements HKDF-SHA256 (RFC 5869) returning at most 32 bytes, which is enough for AES keys
+func hkdf(secret, salt, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	pseudoRandomKey := extract.Sum(nil)
+
+	expand := hmac.New(sha256.New, pseudoRandomKey)
+	expand.Write(info)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)[:length]
+}
+
+func nonce(prefix []byte, counter uint32, flag byte) []byte {
+	n := make([]byte, noncePrefixLength+5)
+	copy(n, prefix)
+	binary.BigEndian.PutUint32(n[noncePrefixLength:], counter)
+	n[len(n)-1] = flag
+	return n
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,32 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package encryption
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test Case 1 taken from RFC 5869. Only the first 32 bytes of output keying material are checked.
+func TestHKDF(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x0b}, 22)
+	salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+	// when
+	key := hkdf(secret, salt, info, 32)
+	// then
+	assert.Equal(t, "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf", hex.EncodeToString(key))
+}
+
+func TestDeriveKey(t *testing.T) {
+	masterKey := bytes.Repeat([]byte{1}, 16)
+	key1 := deriveKey(masterKey, bytes.Repeat([]byte{1}, saltLength))
+	key2 := deriveKey(masterKey, bytes.Repeat([]byte{2}, saltLength))
+	assert.Len(t, key1, 16)
+	assert.NotEqual(t, key1, key2)
+	assert.NotEqual(t, masterKey, key1)
+}
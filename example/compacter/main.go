@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/jacekolszak/deebee/compacter"
@@ -23,6 +24,13 @@ func main() {
 		}
 	}
 
+	// check which versions would be deleted when retaining 3 most recent versions and one version for each day
+	toDelete, err := compacter.DryRun(s, compacter.KeepLast(3), compacter.KeepDaily(7))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Versions to delete: %+v\n", toDelete)
+
 	// run compacter once
 	err = compacter.RunOnce(s)
 	if err != nil {
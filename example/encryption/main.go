@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/jacekolszak/deebee/encryption"
+	"github.com/jacekolszak/deebee/json"
+	"github.com/jacekolszak/deebee/store"
+)
+
+// This example shows how to encrypt state saved on disk
+func main() {
+	s, err := store.Open("/tmp/deebee")
+	if err != nil {
+		panic(err)
+	}
+
+	key := make([]byte, 32) // in real application key should be loaded from a secure location, such as KMS
+	if _, err = rand.Read(key); err != nil {
+		panic(err)
+	}
+	keys, err := encryption.NewKeyRing("key-1", map[string][]byte{"key-1": key})
+	if err != nil {
+		panic(err)
+	}
+
+	encryptedStore, err := encryption.Wrap(s, keys)
+	if err != nil {
+		panic(err)
+	}
+
+	err = json.Write(encryptedStore, map[string]string{"secret": "value"})
+	if err != nil {
+		panic(err)
+	}
+
+	out := map[string]string{}
+	version, err := json.Read(encryptedStore, &out)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Version %+v decrypted: %+v", version, out)
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 
+	"github.com/jacekolszak/deebee/compacter"
+	"github.com/jacekolszak/deebee/openmetrics"
 	"github.com/jacekolszak/deebee/store"
 )
 
-// This example shows how to get Store metrics
+// This example shows how to get Store metrics and how to expose them in OpenMetrics format
 func main() {
 	s, err := store.Open("/tmp/deebee")
 	if err != nil {
@@ -14,5 +18,19 @@ func main() {
 	}
 
 	metrics := s.Metrics()
-	fmt.Printf("%+v", metrics)
+	fmt.Printf("%+v\n", metrics)
+
+	collector := openmetrics.NewCollector()
+	if err = collector.RegisterStore("local", s); err != nil {
+		panic(err)
+	}
+	go func() {
+		_ = compacter.Start(context.Background(), s, collector.CompacterEvents("local"))
+	}()
+
+	http.Handle("/metrics", collector)
+	// Prometheus can now scrape http://localhost:8080/metrics
+	if err = http.ListenAndServe(":8080", nil); err != nil {
+		panic(err)
+	}
 }
@@ -6,6 +6,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/jacekolszak/deebee/memstore"
 	"github.com/jacekolszak/deebee/store"
 	"github.com/stretchr/testify/require"
 )
@@ -17,6 +18,13 @@ func TestFunction(t *testing.T) {
 		_ = Function(s)   // use dependency injection to pass a Store instance to function under test
 		// some assertion goes here
 	})
+
+	t.Run("this test shows how to use in-memory store, which is faster and does not create any files", func(t *testing.T) {
+		s, err := memstore.New()
+		require.NoError(t, err)
+		_ = Function(s.Store)
+		// some assertion goes here
+	})
 }
 
 func openStore(t *testing.T, options ...store.Option) *store.Store {
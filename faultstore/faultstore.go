@@ -0,0 +1,332 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+// Package faultstore injects failures into a store, so recovery of the application can be tested: errors on
+// the Nth write, short writes, no space left on device, corrupted data, slow syncs and crashes in the middle of
+// a write.
+//
+// Wrap decorates any store and injects failures on the store API level. FS decorates a filesystem used by
+// store.Store (see store.FileSystem option) and injects failures on the file level, so their effects - such as
+// data file written without a checksum file after a crash - are left on disk exactly as in real life:
+//
+//	fsys, _ := faultstore.FS(store.OS, faultstore.CrashBeforeChecksum)
+//	s, _ := store.Open(dir, store.FileSystem(fsys))
+package faultstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jacekolszak/deebee/store"
+)
+
+// ErrInjected is returned by operations which failed because of injected fault
+var ErrInjected = errors.New("injected fault")
+
+// ErrCrashed is returned by all operations after the crash was simulated. See CrashBeforeChecksum.
+var ErrCrashed = errors.New("crashed")
+
+type Option func(*Options) error
+
+type Options struct {
+	failNthWrite        int           // 0 when disabled
+	maxWriteSize        int           // 0 when disabled
+	spaceLeft           int64         // negative when unlimited
+	corruptOffset       int64         // negative when disabled
+	syncDelay           time.Duration // 0 when disabled
+	crashBeforeChecksum bool
+}
+
+// FailNthWrite makes the nth call to Write (counted from 1, across all writers) fail with ErrInjected.
+// Nothing is written by this call.
+func FailNthWrite(n int) Option {
+	return func(o *Options) error {
+		if n < 1 {
+			return errors.New("n must be greater than zero")
+		}
+		o.failNthWrite = n
+		return nil
+	}
+}
+
+// ShortWrites makes Write write at most max bytes at once. When more bytes are given, Write returns
+// io.ErrShortWrite.
+func ShortWrites(max int) Option {
+	return func(o *Options) error {
+		if max < 1 {
+			return errors.New("max must be greater than zero")
+		}
+		o.maxWriteSize = max
+		return nil
+	}
+}
+
+// NoSpaceLeft makes Write fail with syscall.ENOSPC once total number of written bytes reaches limit
+func NoSpaceLeft(limit int64) Option {
+	return func(o *Options) error {
+		if limit < 0 {
+			return errors.New("negative limit")
+		}
+		o.spaceLeft = limit
+		return nil
+	}
+}
+
+// CorruptByte flips bits of the byte at given offset of every read version. When used with Wrap, reading
+// corrupted version ends with checksum error, just like reading corrupted file from store.Store. When used with
+// FS, only data files are corrupted and the error is returned by the store.
+func CorruptByte(offset int64) Option {
+	return func(o *Options) error {
+		if offset < 0 {
+			return errors.New("negative offset")
+		}
+		o.corruptOffset = offset
+		return nil
+	}
+}
+
+// SlowSync delays each sync by d. When used with Wrap, Writer.Close is delayed.
+func SlowSync(d time.Duration) Option {
+	return func(o *Options) error {
+		if d < 0 {
+			return errors.New("negative delay")
+		}
+		o.syncDelay = d
+		return nil
+	}
+}
+
+// CrashBeforeChecksum simulates a crash after data file was written, but before checksum file was. All operations
+// return ErrCrashed afterwards. Reopen the store without fault injection to simulate a restart.
+//
+// When used with FS, the data file is left on disk without checksum file. When used with Wrap, the version is
+// aborted, because the store API does not allow to leave it half-written.
+var CrashBeforeChecksum Option = func(o *Options) error {
+	o.crashBeforeChecksum = true
+	return nil
+}
+
+func applyOptions(options []Option) (*Options, error) {
+	opts := &Options{
+		spaceLeft:     -1,
+		corruptOffset: -1,
+	}
+	for _, apply := range options {
+		if apply == nil {
+			continue
+		}
+		if err := apply(opts); err != nil {
+			return nil, fmt.Errorf("error applying option: %w", err)
+		}
+	}
+	return opts, nil
+}
+
+// injector keeps state of injected faults. It is shared by all readers and writers of a given store or filesystem.
+type injector struct {
+	opts *Options
+
+	mutex   sync.Mutex
+	writes  int
+	written int64
+	crashed bool
+}
+
+func newInjector(options []Option) (*injector, error) {
+	opts, err := applyOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	return &injector{opts: opts}, nil
+}
+
+func (i *injector) write(p []byte, write func([]byte) (int, error)) (int, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.crashed {
+		return 0, ErrCrashed
+	}
+	i.writes++
+	if i.writes == i.opts.failNthWrite {
+		return 0, fmt.Errorf("write #%d failed: %w", i.writes, ErrInjected)
+	}
+
+	var injectedErr error
+	if i.opts.maxWriteSize > 0 && len(p) > i.opts.maxWriteSize {
+		p = p[:i.opts.maxWriteSize]
+		injectedErr = io.ErrShortWrite
+	}
+	if i.opts.spaceLeft >= 0 {
+		if remaining := i.opts.spaceLeft - i.written; int64(len(p)) > remaining {
+			p = p[:remaining]
+			injectedErr = syscall.ENOSPC
+		}
+	}
+
+	n, err := write(p)
+	i.written += int64(n)
+	if err != nil {
+		return n, err
+	}
+	return n, injectedErr
+}
+
+// corrupt corrupts p, which was read from the given offset. Returns true when p was corrupted.
+func (i *injector) corrupt(offset int64, p []byte) bool {
+	corruptOffset := i.opts.corruptOffset
+	if corruptOffset < offset || corruptOffset >= offset+int64(len(p)) {
+		return false
+	}
+	p[corruptOffset-offset] ^= 0xFF
+	return true
+}
+
+func (i *injector) sync(sync func() error) error {
+	time.Sleep(i.opts.syncDelay)
+	if err := i.checkCrashed(); err != nil {
+		return err
+	}
+	return sync()
+}
+
+func (i *injector) crash() error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.crashed = true
+	return ErrCrashed
+}
+
+func (i *injector) checkCrashed() error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.crashed {
+		return ErrCrashed
+	}
+	return nil
+}
+
+// Store is a store which can be wrapped. store.Store implements this interface.
+type Store interface {
+	Versions() ([]store.Version, error)
+	Reader(...store.ReaderOption) (store.Reader, error)
+	Writer(...store.WriterOption) (store.Writer, error)
+	DeleteVersion(time.Time) error
+}
+
+// Wrap returns a store injecting faults into s
+func Wrap(s Store, options ...Option) (*FaultyStore, error) {
+	if s == nil {
+		return nil, errors.New("nil store")
+	}
+	injector, err := newInjector(options)
+	if err != nil {
+		return nil, err
+	}
+	return &FaultyStore{store: s, injector: injector}, nil
+}
+
+// FaultyStore can be used everywhere where store.Store is used - with codec, compacter and replicator packages.
+type FaultyStore struct {
+	store    Store
+	injector *injector
+}
+
+func (s *FaultyStore) Versions() ([]store.Version, error) {
+	if err := s.injector.checkCrashed(); err != nil {
+		return nil, err
+	}
+	return s.store.Versions()
+}
+
+func (s *FaultyStore) Reader(options ...store.ReaderOption) (store.Reader, error) {
+	if err := s.injector.checkCrashed(); err != nil {
+		return nil, err
+	}
+	reader, err := s.store.Reader(options...)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyReader{Reader: reader, injector: s.injector}, nil
+}
+
+func (s *FaultyStore) Writer(options ...store.WriterOption) (store.Writer, error) {
+	if err := s.injector.checkCrashed(); err != nil {
+		return nil, err
+	}
+	writer, err := s.store.Writer(options...)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyWriter{Writer: writer, injector: s.injector}, nil
+}
+
+func (s *FaultyStore) DeleteVersion(t time.Time) error {
+	if err := s.injector.checkCrashed(); err != nil {
+		return err
+	}
+	return s.store.DeleteVersion(t)
+}
+
+type faultyReader struct {
+	store.Reader
+	injector  *injector
+	offset    int64
+	corrupted bool
+}
+
+func (r *faultyReader) Read(p []byte) (int, error) {
+	if err := r.injector.checkCrashed(); err != nil {
+		return 0, err
+	}
+	n, err := r.Reader.Read(p)
+	if r.injector.corrupt(r.offset, p[:n]) {
+		r.corrupted = true
+	}
+	r.offset += int64(n)
+	if err == io.EOF && r.corrupted {
+		return n, r.checksumError()
+	}
+	return n, err
+}
+
+func (r *faultyReader) Close() error {
+	if err := r.Reader.Close(); err != nil {
+		return err
+	}
+	if r.corrupted {
+		return r.checksumError()
+	}
+	return nil
+}
+
+func (r *faultyReader) checksumError() error {
+	return fmt.Errorf("invalid checksum when reading version %s: %w", r.Version().Time, ErrInjected)
+}
+
+type faultyWriter struct {
+	store.Writer
+	injector *injector
+}
+
+func (w *faultyWriter) Write(p []byte) (int, error) {
+	return w.injector.write(p, w.Writer.Write)
+}
+
+func (w *faultyWriter) Close() error {
+	if w.injector.opts.crashBeforeChecksum {
+		w.Writer.AbortAndClose()
+		return w.injector.crash()
+	}
+	if err := w.injector.sync(func() error { return nil }); err != nil {
+		w.Writer.AbortAndClose()
+		return err
+	}
+	return w.Writer.Close()
+}
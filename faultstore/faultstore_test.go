@@ -0,0 +1,184 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package faultstore_test
+
+import (
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jacekolszak/deebee/codec"
+	"github.com/jacekolszak/deebee/faultstore"
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/memstore"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrap(t *testing.T) {
+
+	t.Run("should return error for nil store", func(t *testing.T) {
+		_, err := faultstore.Wrap(nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("should return error for invalid options", func(t *testing.T) {
+		options := map[string]faultstore.Option{
+			"FailNthWrite": faultstore.FailNthWrite(0),
+			"ShortWrites":  faultstore.ShortWrites(0),
+			"NoSpaceLeft":  faultstore.NoSpaceLeft(-1),
+			"CorruptByte":  faultstore.CorruptByte(-1),
+			"SlowSync":     faultstore.SlowSync(-1),
+		}
+		for name, option := range options {
+			t.Run(name, func(t *testing.T) {
+				_, err := faultstore.Wrap(newMemStore(t), option)
+				assert.Error(t, err)
+			})
+		}
+	})
+
+	t.Run("should pass data through when no option was given", func(t *testing.T) {
+		s, err := faultstore.Wrap(newMemStore(t))
+		require.NoError(t, err)
+		writeData(t, s, "data")
+		// when
+		data, err := readData(s)
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "data", data)
+	})
+}
+
+func TestFailNthWrite(t *testing.T) {
+	s, err := faultstore.Wrap(newMemStore(t), faultstore.FailNthWrite(2))
+	require.NoError(t, err)
+	writer, err := s.Writer()
+	require.NoError(t, err)
+	defer writer.AbortAndClose()
+	// when
+	_, err1 := writer.Write([]byte("1"))
+	n, err2 := writer.Write([]byte("2"))
+	_, err3 := writer.Write([]byte("3"))
+	// then
+	assert.NoError(t, err1)
+	assert.ErrorIs(t, err2, faultstore.ErrInjected)
+	assert.Equal(t, 0, n)
+	assert.NoError(t, err3)
+}
+
+func TestShortWrites(t *testing.T) {
+	s, err := faultstore.Wrap(newMemStore(t), faultstore.ShortWrites(2))
+	require.NoError(t, err)
+	writer, err := s.Writer()
+	require.NoError(t, err)
+	defer writer.AbortAndClose()
+	// when
+	n, err := writer.Write([]byte("data"))
+	// then
+	assert.ErrorIs(t, err, io.ErrShortWrite)
+	assert.Equal(t, 2, n)
+}
+
+func TestNoSpaceLeft(t *testing.T) {
+	s, err := faultstore.Wrap(newMemStore(t), faultstore.NoSpaceLeft(5))
+	require.NoError(t, err)
+	writer, err := s.Writer()
+	require.NoError(t, err)
+	defer writer.AbortAndClose()
+	_, err = writer.Write([]byte("123"))
+	require.NoError(t, err)
+	// when
+	n, err := writer.Write([]byte("456"))
+	// then
+	assert.ErrorIs(t, err, syscall.ENOSPC)
+	assert.Equal(t, 2, n)
+}
+
+func TestCorruptByte(t *testing.T) {
+
+	t.Run("should corrupt data and return error", func(t *testing.T) {
+		s, err := faultstore.Wrap(newMemStore(t), faultstore.CorruptByte(1))
+		require.NoError(t, err)
+		writeData(t, s, "data")
+		// when
+		data, err := readData(s)
+		// then
+		assert.ErrorIs(t, err, faultstore.ErrInjected)
+		assert.NotEqual(t, "data", data)
+		assert.Equal(t, "d", data[:1])
+	})
+
+	t.Run("should not corrupt version shorter than offset", func(t *testing.T) {
+		s, err := faultstore.Wrap(newMemStore(t), faultstore.CorruptByte(10))
+		require.NoError(t, err)
+		writeData(t, s, "data")
+		// when
+		data, err := readData(s)
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "data", data)
+	})
+}
+
+func TestSlowSync(t *testing.T) {
+	s, err := faultstore.Wrap(newMemStore(t), faultstore.SlowSync(50*time.Millisecond))
+	require.NoError(t, err)
+	start := time.Now()
+	// when
+	writeData(t, s, "data")
+	// then
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestCrashBeforeChecksum(t *testing.T) {
+	underlying := newMemStore(t)
+	writeData(t, underlying, "v1")
+	s, err := faultstore.Wrap(underlying, faultstore.CrashBeforeChecksum)
+	require.NoError(t, err)
+	writer, err := s.Writer()
+	require.NoError(t, err)
+	_, err = writer.Write([]byte("v2"))
+	require.NoError(t, err)
+	// when
+	err = writer.Close()
+	// then
+	assert.ErrorIs(t, err, faultstore.ErrCrashed)
+	_, err = s.Versions()
+	assert.ErrorIs(t, err, faultstore.ErrCrashed)
+	// and
+	decoder := &tests.FakeDecoder{}
+	_, err = codec.ReadLatest(underlying, decoder.Decode)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v1"), decoder.DataRead())
+}
+
+func newMemStore(t *testing.T) *memstore.Store {
+	s, err := memstore.New()
+	require.NoError(t, err)
+	return s
+}
+
+func writeData(t *testing.T, s faultstore.Store, data string) {
+	err := codec.Write(s, func(writer io.Writer) error {
+		_, err := writer.Write([]byte(data))
+		return err
+	})
+	require.NoError(t, err)
+}
+
+func readData(s faultstore.Store, options ...store.ReaderOption) (string, error) {
+	reader, err := s.Reader(options...)
+	if err != nil {
+		return "", err
+	}
+	data, err := io.ReadAll(reader)
+	closeErr := reader.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return string(data), err
+}
@@ -0,0 +1,129 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package faultstore
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+
+	"github.com/jacekolszak/deebee/store"
+)
+
+// FS returns a filesystem injecting faults into fsys. It should be passed to store.Open using store.FileSystem
+// option. Writes are counted and limited for all files, including checksum and lock files.
+func FS(fsys store.FS, options ...Option) (store.FS, error) {
+	if fsys == nil {
+		return nil, errors.New("nil filesystem")
+	}
+	injector, err := newInjector(options)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyFS{fs: fsys, injector: injector}, nil
+}
+
+type faultyFS struct {
+	fs       store.FS
+	injector *injector
+}
+
+func (f *faultyFS) OpenFile(name string, flag int, perm fs.FileMode) (store.File, error) {
+	if err := f.injector.checkCrashed(); err != nil {
+		return nil, err
+	}
+	file, err := f.fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyFile{File: file, injector: f.injector}, nil
+}
+
+func (f *faultyFS) ReadDir(dir string) ([]fs.FileInfo, error) {
+	if err := f.injector.checkCrashed(); err != nil {
+		return nil, err
+	}
+	return f.fs.ReadDir(dir)
+}
+
+func (f *faultyFS) Stat(name string) (fs.FileInfo, error) {
+	if err := f.injector.checkCrashed(); err != nil {
+		return nil, err
+	}
+	return f.fs.Stat(name)
+}
+
+func (f *faultyFS) Lstat(name string) (fs.FileInfo, error) {
+	if err := f.injector.checkCrashed(); err != nil {
+		return nil, err
+	}
+	return f.fs.Lstat(name)
+}
+
+func (f *faultyFS) MkdirAll(dir string, perm fs.FileMode) error {
+	if err := f.injector.checkCrashed(); err != nil {
+		return err
+	}
+	return f.fs.MkdirAll(dir, perm)
+}
+
+func (f *faultyFS) Remove(name string) error {
+	if err := f.injector.checkCrashed(); err != nil {
+		return err
+	}
+	return f.fs.Remove(name)
+}
+
+// Rename crashes when checksum file is about to be made visible and CrashBeforeChecksum option was used
+func (f *faultyFS) Rename(oldName, newName string) error {
+	if err := f.injector.checkCrashed(); err != nil {
+		return err
+	}
+	if f.injector.opts.crashBeforeChecksum && strings.HasSuffix(newName, ".sum") {
+		return f.injector.crash()
+	}
+	return f.fs.Rename(oldName, newName)
+}
+
+func (f *faultyFS) SyncDir(dir string) error {
+	return f.injector.sync(func() error {
+		return f.fs.SyncDir(dir)
+	})
+}
+
+type faultyFile struct {
+	store.File
+	injector *injector
+	offset   int64
+}
+
+// Read corrupts data files only, so the offset given to CorruptByte refers to stored version data
+func (f *faultyFile) Read(p []byte) (int, error) {
+	if err := f.injector.checkCrashed(); err != nil {
+		return 0, err
+	}
+	n, err := f.File.Read(p)
+	if strings.HasSuffix(f.Name(), ".data") {
+		f.injector.corrupt(f.offset, p[:n])
+	}
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *faultyFile) Write(p []byte) (int, error) {
+	return f.injector.write(p, f.File.Write)
+}
+
+func (f *faultyFile) Sync() error {
+	return f.injector.sync(f.File.Sync)
+}
+
+// Close closes the underlying file even after crash, so no resources are leaked
+func (f *faultyFile) Close() error {
+	err := f.File.Close()
+	if crashErr := f.injector.checkCrashed(); crashErr != nil {
+		return crashErr
+	}
+	return err
+}
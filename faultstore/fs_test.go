@@ -0,0 +1,104 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package faultstore_test
+
+import (
+	"errors"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jacekolszak/deebee/codec"
+	"github.com/jacekolszak/deebee/faultstore"
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/memfs"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFS(t *testing.T) {
+
+	t.Run("should return error for nil filesystem", func(t *testing.T) {
+		_, err := faultstore.FS(nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("should fail writing when there is no space left", func(t *testing.T) {
+		s := openFaultyStore(t, memfs.New(), faultstore.NoSpaceLeft(3))
+		// when
+		err := codec.Write(s, func(writer io.Writer) error {
+			_, err := writer.Write([]byte("data"))
+			return err
+		})
+		// then
+		assert.ErrorIs(t, err, syscall.ENOSPC)
+		versions, err := s.Versions()
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+
+	t.Run("should fail writing checksum", func(t *testing.T) {
+		s := openFaultyStore(t, memfs.New(), faultstore.FailNthWrite(2))
+		// when
+		writer, err := s.Writer()
+		require.NoError(t, err)
+		_, err = writer.Write([]byte("data"))
+		require.NoError(t, err)
+		err = writer.Close()
+		// then
+		assert.ErrorIs(t, err, faultstore.ErrInjected)
+	})
+
+	t.Run("should make store report corrupted data", func(t *testing.T) {
+		s := openFaultyStore(t, memfs.New(), faultstore.CorruptByte(0))
+		writeData(t, s, "data")
+		// when
+		_, err := readData(s)
+		// then
+		assert.Error(t, err)
+		assert.False(t, errors.Is(err, faultstore.ErrInjected), "error should be reported by store")
+	})
+
+	t.Run("should slow down syncs", func(t *testing.T) {
+		s := openFaultyStore(t, memfs.New(), faultstore.SlowSync(20*time.Millisecond))
+		start := time.Now()
+		// when
+		writeData(t, s, "data")
+		// then
+		assert.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond, "data, checksum and directory should be synced")
+	})
+
+	t.Run("should leave data file without checksum after crash", func(t *testing.T) {
+		fsys := memfs.New()
+		s := openFaultyStore(t, fsys, faultstore.CrashBeforeChecksum)
+		writer, err := s.Writer()
+		require.NoError(t, err)
+		_, err = writer.Write([]byte("data"))
+		require.NoError(t, err)
+		// when
+		err = writer.Close()
+		// then
+		assert.ErrorIs(t, err, faultstore.ErrCrashed)
+		// and after restart
+		restarted, err := store.Open("dir", store.FileSystem(fsys))
+		require.NoError(t, err)
+		versions, err := restarted.Versions()
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+		report, err := restarted.Cleanup(store.GracePeriod(0))
+		require.NoError(t, err)
+		assert.Len(t, report.Removed, 2) // data file and temporary checksum file
+		tests.WriteData(t, restarted, []byte("new"))
+	})
+}
+
+func openFaultyStore(t *testing.T, fsys store.FS, options ...faultstore.Option) *store.Store {
+	faultyFS, err := faultstore.FS(fsys, options...)
+	require.NoError(t, err)
+	s, err := store.Open("dir", store.FileSystem(faultyFS))
+	require.NoError(t, err)
+	return s
+}
@@ -0,0 +1,378 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package httpstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacekolszak/deebee/store"
+)
+
+// NewClient returns a client of the store served by NewHandler. baseURL is the URL under which the handler
+// is mounted, for example http://sidecar:8080/store.
+func NewClient(baseURL string, options ...ClientOption) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %s: %w", baseURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid URL %s: scheme must be http or https", baseURL)
+	}
+
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: http.DefaultClient,
+	}
+	for _, apply := range options {
+		if apply == nil {
+			continue
+		}
+		if err = apply(c); err != nil {
+			return nil, fmt.Errorf("error applying option: %w", err)
+		}
+	}
+	return c, nil
+}
+
+type ClientOption func(*Client) error
+
+// HTTPClient sets the client used for sending requests. It can be used to configure timeouts, TLS or
+// authentication. Please note that Client.Timeout limits the time of reading or writing the whole version.
+func HTTPClient(c *http.Client) ClientOption {
+	return func(client *Client) error {
+		if c == nil {
+			return errors.New("nil HTTP client")
+		}
+		client.httpClient = c
+		return nil
+	}
+}
+
+// Client implements the same interfaces as store.Store, so it can be used with codec, compacter and replicator
+// packages. It is safe for concurrent use by multiple goroutines.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+
+	mutex           sync.Mutex
+	lastVersionTime time.Time
+}
+
+// Versions return slice sorted by time, oldest first
+func (c *Client) Versions() ([]store.Version, error) {
+	resp, err := c.httpClient.Get(c.baseURL + versionsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, readError(resp)
+	}
+
+	var response []versionJSON
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error decoding versions: %w", err)
+	}
+	versions := make([]store.Version, 0, len(response))
+	for _, v := range response {
+		t, err := time.Parse(timeFormat, v.Time)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version time %s: %w", v.Time, err)
+		}
+		versions = append(versions, store.Version{Time: t, Size: v.Size})
+	}
+	return versions, nil
+}
+
+func (c *Client) Reader(options ...store.ReaderOption) (store.Reader, error) {
+	opts, err := store.ApplyReaderOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := c.Versions()
+	if err != nil {
+		return nil, err
+	}
+	version, err := opts.ChooseVersion(versions)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Get(c.versionURL(version.Time))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, readError(resp)
+	}
+	return &reader{
+		resp:     resp,
+		version:  version,
+		checksum: sha256.New(),
+	}, nil
+}
+
+func (c *Client) Writer(options ...store.WriterOption) (store.Writer, error) {
+	opts, err := store.ApplyWriterOptions(options, c.nextVersionTime())
+	if err != nil {
+		return nil, err
+	}
+	versionURL := c.versionURL(opts.Time())
+
+	resp, err := c.httpClient.Head(versionURL)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil, store.NewVersionAlreadyExistsError(fmt.Sprintf("version %s already exists", opts.Time()))
+	case http.StatusNotFound:
+	default:
+		return nil, fmt.Errorf("checking version %s failed with status %d", opts.Time(), resp.StatusCode)
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	w := &writer{
+		time:     opts.Time(),
+		body:     pipeWriter,
+		checksum: sha256.New(),
+		done:     make(chan struct{}),
+	}
+	trailer := http.Header{checksumTrailer: nil}
+	body := &trailerReader{
+		pipe: pipeReader,
+		setTrailer: func() {
+			trailer.Set(checksumTrailer, w.sum)
+		},
+	}
+	req, err := http.NewRequest(http.MethodPut, versionURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Trailer = trailer
+
+	go func() {
+		defer close(w.done)
+		w.err = c.upload(req)
+		// server might respond before reading the whole body, closing the pipe unblocks writer
+		_ = body.Close()
+	}()
+	return w, nil
+}
+
+func (c *Client) upload(req *http.Request) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return readError(resp)
+	}
+	return nil
+}
+
+func (c *Client) DeleteVersion(t time.Time) error {
+	req, err := http.NewRequest(http.MethodDelete, c.versionURL(t), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return readError(resp)
+	}
+	return nil
+}
+
+func (c *Client) versionURL(t time.Time) string {
+	return c.baseURL + versionsPath + "/" + url.PathEscape(formatTime(t))
+}
+
+func (c *Client) nextVersionTime() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	t := time.Now()
+	if !t.After(c.lastVersionTime) {
+		t = c.lastVersionTime.Add(time.Nanosecond)
+	}
+	c.lastVersionTime = t
+	return t
+}
+
+// readError converts error response to error. Status codes are mapped to store errors, so store.IsVersionNotFound
+// and store.IsVersionAlreadyExists can be used.
+func readError(resp *http.Response) error {
+	content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	response := errorJSON{}
+	msg := strings.TrimSpace(string(content))
+	if err := json.Unmarshal(content, &response); err == nil && response.Error != "" {
+		msg = response.Error
+	}
+	msg = fmt.Sprintf("request failed with status %d: %s", resp.StatusCode, msg)
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return store.NewVersionNotFoundError(msg)
+	case http.StatusConflict:
+		return store.NewVersionAlreadyExistsError(msg)
+	default:
+		return errors.New(msg)
+	}
+}
+
+type reader struct {
+	resp     *http.Response
+	version  store.Version
+	checksum hash.Hash
+	verified bool
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.resp.Body.Read(p)
+	r.checksum.Write(p[:n])
+	if err == io.EOF {
+		if err2 := r.verify(); err2 != nil {
+			return n, err2
+		}
+	}
+	return n, err
+}
+
+// verify checks trailers, which are available only after the whole body was read
+func (r *reader) verify() error {
+	if msg := r.resp.Trailer.Get(errorTrailer); msg != "" {
+		return fmt.Errorf("error reading version %s on the server: %s", r.version.Time, msg)
+	}
+	expected := r.resp.Trailer.Get(checksumTrailer)
+	if expected == "" {
+		return fmt.Errorf("no checksum received for version %s", r.version.Time)
+	}
+	if expected != checksumPrefix+hex.EncodeToString(r.checksum.Sum(nil)) {
+		return fmt.Errorf("invalid checksum when reading version %s", r.version.Time)
+	}
+	r.verified = true
+	return nil
+}
+
+// Close returns error when data was not read completely or did not match the checksum
+func (r *reader) Close() error {
+	if err := r.resp.Body.Close(); err != nil {
+		return err
+	}
+	if !r.verified {
+		return fmt.Errorf("version %s was not read completely or is corrupted", r.version.Time)
+	}
+	return nil
+}
+
+func (r *reader) Version() store.Version {
+	return r.version
+}
+
+// writer streams data to the server while it is written. Checksum is sent in the trailer by Close.
+type writer struct {
+	time     time.Time
+	body     *io.PipeWriter
+	checksum hash.Hash
+	sum      string // sent in the trailer, set before body is closed
+	size     int64
+	closed   bool
+	done     chan struct{} // closed when the upload is finished
+	err      error         // result of the upload, can be read after done is closed
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("writer for version %s already closed", w.time)
+	}
+	select {
+	case <-w.done:
+		return 0, w.uploadError(errors.New("upload finished before all data was written"))
+	default:
+	}
+	n, err := w.body.Write(p)
+	w.checksum.Write(p[:n])
+	w.size += int64(n)
+	if err != nil {
+		// pipe is closed only when the upload is finished or finishing
+		<-w.done
+		return n, w.uploadError(err)
+	}
+	return n, nil
+}
+
+// uploadError returns the error of finished upload, or pipeErr when upload succeeded
+func (w *writer) uploadError(pipeErr error) error {
+	if w.err != nil {
+		return fmt.Errorf("error sending data: %w", w.err)
+	}
+	return fmt.Errorf("error sending data: %w", pipeErr)
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return fmt.Errorf("writer for version %s already closed", w.time)
+	}
+	w.closed = true
+
+	w.sum = checksumPrefix + hex.EncodeToString(w.checksum.Sum(nil))
+	_ = w.body.Close()
+	<-w.done
+	return w.err
+}
+
+func (w *writer) Version() store.Version {
+	return store.Version{
+		Time: w.time,
+		Size: w.size,
+	}
+}
+
+// AbortAndClose interrupts the upload, so the server does not commit the version
+func (w *writer) AbortAndClose() {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	_ = w.body.CloseWithError(errors.New("writer aborted"))
+	<-w.done
+}
+
+// trailerReader sets the trailer when the body reaches EOF. HTTP client reads the trailer after that.
+type trailerReader struct {
+	pipe       *io.PipeReader
+	setTrailer func()
+}
+
+func (r *trailerReader) Read(p []byte) (int, error) {
+	n, err := r.pipe.Read(p)
+	if err == io.EOF {
+		r.setTrailer()
+	}
+	return n, err
+}
+
+// Close is called by HTTP client once the request is finished, even when the body was not read completely.
+// Pending and subsequent writes to the pipe fail.
+func (r *trailerReader) Close() error {
+	return r.pipe.CloseWithError(errors.New("request body closed"))
+}
@@ -0,0 +1,336 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package httpstore_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacekolszak/deebee/codec"
+	"github.com/jacekolszak/deebee/compacter"
+	"github.com/jacekolszak/deebee/httpstore"
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/replicator"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewClient(t *testing.T) {
+	_, err := httpstore.NewClient("localhost:8080")
+	assert.Error(t, err)
+	_, err = httpstore.NewClient("http://localhost:8080", httpstore.HTTPClient(nil))
+	assert.Error(t, err)
+}
+
+func TestClient_Versions(t *testing.T) {
+
+	t.Run("should return no versions for empty store", func(t *testing.T) {
+		client, _ := startServer(t)
+		versions, err := client.Versions()
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+
+	t.Run("should return versions", func(t *testing.T) {
+		client, s := startServer(t)
+		v1 := tests.WriteData(t, s, []byte("v1"))
+		v2 := tests.WriteData(t, s, []byte("v22"))
+		// when
+		versions, err := client.Versions()
+		// then
+		require.NoError(t, err)
+		assertVersionsEqual(t, []store.Version{v1, v2}, versions)
+	})
+
+	t.Run("should return error when server is not a store", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		defer server.Close()
+		client, err := httpstore.NewClient(server.URL)
+		require.NoError(t, err)
+		// when
+		_, err = client.Versions()
+		// then
+		assert.Error(t, err)
+	})
+}
+
+func TestClient_Reader(t *testing.T) {
+
+	t.Run("should read latest version", func(t *testing.T) {
+		client, s := startServer(t)
+		tests.WriteData(t, s, []byte("v1"))
+		v2 := tests.WriteData(t, s, []byte("v2"))
+		// when
+		reader, err := client.Reader()
+		// then
+		require.NoError(t, err)
+		assert.True(t, v2.Time.Equal(reader.Version().Time))
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.NoError(t, reader.Close())
+		assert.Equal(t, []byte("v2"), data)
+	})
+
+	t.Run("should read version with given time", func(t *testing.T) {
+		client, s := startServer(t)
+		v1 := tests.WriteData(t, s, []byte("v1"))
+		tests.WriteData(t, s, []byte("v2"))
+		// when
+		data := readData(t, client, store.Time(v1.Time))
+		// then
+		assert.Equal(t, []byte("v1"), data)
+	})
+
+	t.Run("should return error when there are no versions", func(t *testing.T) {
+		client, _ := startServer(t)
+		_, err := client.Reader()
+		assert.True(t, store.IsVersionNotFound(err))
+	})
+
+	t.Run("should return error when version is corrupted on the server", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s, err := store.Open(dir)
+		require.NoError(t, err)
+		client := startServerFor(t, s)
+		v := tests.WriteData(t, s, []byte("data"))
+		tests.CorruptFile(t, path.Join(dir, v.Time.UTC().Format("2006-01-02T15_04_05.999999999Z")+".data"))
+		reader, err := client.Reader()
+		require.NoError(t, err)
+		// when
+		_, err = io.ReadAll(reader)
+		// then
+		assert.Error(t, err)
+		assert.Error(t, reader.Close())
+	})
+
+	t.Run("should return error on Close when data was not read completely", func(t *testing.T) {
+		client, s := startServer(t)
+		tests.WriteData(t, s, []byte("data"))
+		reader, err := client.Reader()
+		require.NoError(t, err)
+		// when
+		err = reader.Close()
+		// then
+		assert.Error(t, err)
+	})
+}
+
+func TestClient_Writer(t *testing.T) {
+
+	t.Run("should write version", func(t *testing.T) {
+		client, s := startServer(t)
+		data := bytes.Repeat([]byte("data"), 100000)
+		// when
+		v := writeData(t, client, data)
+		// then
+		assert.Equal(t, int64(len(data)), v.Size)
+		assert.Equal(t, data, tests.ReadData(t, s))
+		versions, err := s.Versions()
+		require.NoError(t, err)
+		assertVersionsEqual(t, []store.Version{v}, versions)
+	})
+
+	t.Run("should write version with given time", func(t *testing.T) {
+		client, s := startServer(t)
+		tm := time.Date(2021, 1, 2, 3, 4, 5, 6, time.UTC)
+		// when
+		writeData(t, client, []byte("data"), store.WriteTime(tm))
+		// then
+		versions, err := s.Versions()
+		require.NoError(t, err)
+		require.Len(t, versions, 1)
+		assert.True(t, tm.Equal(versions[0].Time))
+	})
+
+	t.Run("should not write aborted version", func(t *testing.T) {
+		client, s := startServer(t)
+		writer, err := client.Writer()
+		require.NoError(t, err)
+		_, err = writer.Write([]byte("data"))
+		require.NoError(t, err)
+		// when
+		writer.AbortAndClose()
+		// then
+		versions, err := s.Versions()
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+
+	t.Run("should return error when version already exists", func(t *testing.T) {
+		client, s := startServer(t)
+		v := tests.WriteData(t, s, []byte("data"))
+		// when
+		_, err := client.Writer(store.WriteTime(v.Time))
+		// then
+		assert.True(t, store.IsVersionAlreadyExists(err))
+	})
+
+	t.Run("should return error when store is read-only", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s, err := store.OpenReadOnly(dir)
+		require.NoError(t, err)
+		client := startServerFor(t, s)
+		writer, err := client.Writer()
+		require.NoError(t, err)
+		// when
+		err = writer.Close()
+		// then
+		assert.Error(t, err)
+	})
+
+	t.Run("should return error from Write when store is read-only", func(t *testing.T) {
+		s, err := store.OpenReadOnly(tests.TempDir(t))
+		require.NoError(t, err)
+		client := startServerFor(t, s)
+		writer, err := client.Writer()
+		require.NoError(t, err)
+		chunk := make([]byte, 64*1024)
+		var writeErr, closeErr error
+		// when
+		async := tests.RunAsync(func() {
+			// server responds without reading the body, so Write must not block forever
+			for i := 0; i < 1024 && writeErr == nil; i++ {
+				_, writeErr = writer.Write(chunk)
+			}
+			closeErr = writer.Close()
+		})
+		// then
+		async.WaitOrFailAfter(t, 5*time.Second)
+		require.Error(t, writeErr)
+		assert.Contains(t, writeErr.Error(), "read-only")
+		assert.Error(t, closeErr)
+	})
+}
+
+func TestClient_DeleteVersion(t *testing.T) {
+
+	t.Run("should delete version", func(t *testing.T) {
+		client, s := startServer(t)
+		v := tests.WriteData(t, s, []byte("data"))
+		// when
+		err := client.DeleteVersion(v.Time)
+		// then
+		require.NoError(t, err)
+		versions, err := s.Versions()
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+
+	t.Run("should return error when version does not exist", func(t *testing.T) {
+		client, _ := startServer(t)
+		err := client.DeleteVersion(time.Now())
+		assert.True(t, store.IsVersionNotFound(err))
+	})
+
+	t.Run("should be usable with compacter", func(t *testing.T) {
+		client, s := startServer(t)
+		for i := 0; i < 3; i++ {
+			tests.WriteData(t, s, []byte("data"))
+		}
+		// when
+		err := compacter.RunOnce(client, compacter.KeepLast(1))
+		// then
+		require.NoError(t, err)
+		versions, err := s.Versions()
+		require.NoError(t, err)
+		assert.Len(t, versions, 1)
+	})
+}
+
+func TestHandler(t *testing.T) {
+
+	t.Run("should reject upload with invalid checksum", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		server := httptest.NewServer(httpstore.NewHandler(s))
+		defer server.Close()
+		req, err := http.NewRequest(http.MethodPut, server.URL+"/versions/2021-01-01T00:00:00Z", strings.NewReader("data"))
+		require.NoError(t, err)
+		req.Trailer = http.Header{"Deebee-Checksum": {"sha256:invalid"}}
+		// when
+		resp, err := http.DefaultClient.Do(req)
+		// then
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		versions, err := s.Versions()
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+
+	t.Run("should return error for invalid version time", func(t *testing.T) {
+		server := httptest.NewServer(httpstore.NewHandler(tests.OpenStore(t)))
+		defer server.Close()
+		resp, err := http.Get(server.URL + "/versions/invalid")
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestReplicationOverHTTP(t *testing.T) {
+	local := tests.OpenStore(t)
+	tests.WriteData(t, local, []byte("data"))
+	client, remote := startServer(t)
+	// when
+	err := replicator.CopyFromTo(local, client)
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), tests.ReadData(t, remote))
+	// and
+	decoder := &tests.FakeDecoder{}
+	_, err = replicator.ReadLatest(decoder.Decode, client)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), decoder.DataRead())
+	// and replicating again is skipped
+	err = replicator.CopyFromTo(local, client)
+	assert.True(t, store.IsVersionAlreadyExists(err))
+}
+
+func startServer(t *testing.T) (*httpstore.Client, *store.Store) {
+	s := tests.OpenStore(t)
+	return startServerFor(t, s), s
+}
+
+func startServerFor(t *testing.T, s codec.ReadOnlyStore) *httpstore.Client {
+	mux := http.NewServeMux()
+	mux.Handle("/store/", http.StripPrefix("/store", httpstore.NewHandler(s)))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	client, err := httpstore.NewClient(server.URL + "/store")
+	require.NoError(t, err)
+	return client
+}
+
+func writeData(t *testing.T, client *httpstore.Client, data []byte, options ...store.WriterOption) store.Version {
+	writer, err := client.Writer(options...)
+	require.NoError(t, err)
+	_, err = writer.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	return writer.Version()
+}
+
+func readData(t *testing.T, client *httpstore.Client, options ...store.ReaderOption) []byte {
+	reader, err := client.Reader(options...)
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	return data
+}
+
+func assertVersionsEqual(t *testing.T, expected, actual []store.Version) {
+	require.Len(t, actual, len(expected))
+	for i, v := range expected {
+		assert.True(t, v.Time.Equal(actual[i].Time), "times not equal")
+		assert.Equal(t, v.Size, actual[i].Size)
+	}
+}
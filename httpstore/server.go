@@ -0,0 +1,230 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+// Package httpstore exposes a store over HTTP and provides a client for it. It can be used to replicate versions
+// to another machine, for example to a sidecar:
+//
+//	// on the remote machine
+//	http.Handle("/store/", http.StripPrefix("/store", httpstore.NewHandler(s)))
+//
+//	// on the local machine
+//	remote, _ := httpstore.NewClient("http://sidecar:8080/store")
+//	go replicator.StartFromTo(ctx, local, remote)
+//
+// API:
+//
+//	GET    /versions         list versions as JSON array of {"time": RFC 3339 time, "size": number}
+//	GET    /versions/<time>  stream version data
+//	HEAD   /versions/<time>  check if version exists
+//	PUT    /versions/<time>  upload version data
+//	DELETE /versions/<time>  delete version
+//
+// Data is sent together with SHA-256 checksum in Deebee-Checksum HTTP trailer, so it is verified end-to-end,
+// even though the size and checksum are not known in advance. Uploaded version is committed only when the
+// checksum matches. Errors which happened after the response was started are sent in Deebee-Error trailer.
+package httpstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jacekolszak/deebee/codec"
+	"github.com/jacekolszak/deebee/store"
+)
+
+const (
+	versionsPath    = "/versions"
+	checksumTrailer = "Deebee-Checksum"
+	errorTrailer    = "Deebee-Error"
+	checksumPrefix  = "sha256:"
+	timeFormat      = time.RFC3339Nano
+)
+
+// NewHandler returns a handler serving s. Versions can be uploaded only when s implements Writer method,
+// and deleted only when s implements DeleteVersion method - otherwise 405 Method Not Allowed is returned.
+// Therefore store.ReadOnlyStore can be exposed safely.
+func NewHandler(s codec.ReadOnlyStore) http.Handler {
+	return &handler{store: s}
+}
+
+type handler struct {
+	store codec.ReadOnlyStore
+}
+
+type deleter interface {
+	DeleteVersion(time.Time) error
+}
+
+type versionJSON struct {
+	Time string `json:"time"`
+	Size int64  `json:"size"`
+}
+
+type errorJSON struct {
+	Error string `json:"error"`
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == versionsPath {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		h.listVersions(w)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, versionsPath+"/") {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	t, err := time.Parse(timeFormat, strings.TrimPrefix(r.URL.Path, versionsPath+"/"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid version time: %w", err))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.readVersion(w, t)
+	case http.MethodHead:
+		h.checkVersion(w, t)
+	case http.MethodPut:
+		h.writeVersion(w, r, t)
+	case http.MethodDelete:
+		h.deleteVersion(w, t)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+func (h *handler) listVersions(w http.ResponseWriter) {
+	versions, err := h.store.Versions()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	response := make([]versionJSON, 0, len(versions))
+	for _, v := range versions {
+		response = append(response, versionJSON{Time: formatTime(v.Time), Size: v.Size})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func (h *handler) readVersion(w http.ResponseWriter, t time.Time) {
+	reader, err := h.store.Reader(store.Time(t))
+	if err != nil {
+		writeError(w, statusCode(err), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Trailer", checksumTrailer+", "+errorTrailer)
+	w.WriteHeader(http.StatusOK)
+
+	checksum := sha256.New()
+	_, err = io.Copy(io.MultiWriter(w, checksum), reader)
+	if closeErr := reader.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		w.Header().Set(errorTrailer, trailerValue(err))
+		return
+	}
+	w.Header().Set(checksumTrailer, checksumPrefix+hex.EncodeToString(checksum.Sum(nil)))
+}
+
+func (h *handler) checkVersion(w http.ResponseWriter, t time.Time) {
+	versions, err := h.store.Versions()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	for _, v := range versions {
+		if v.Time.Equal(t) {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// writeVersion commits the version only when whole body was received and its checksum matches the trailer
+func (h *handler) writeVersion(w http.ResponseWriter, r *http.Request, t time.Time) {
+	s, ok := h.store.(codec.WriteOnlyStore)
+	if !ok {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("store is read-only"))
+		return
+	}
+	writer, err := s.Writer(store.WriteTime(t))
+	if err != nil {
+		writeError(w, statusCode(err), err)
+		return
+	}
+
+	checksum := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(writer, checksum), r.Body); err != nil {
+		writer.AbortAndClose()
+		writeError(w, http.StatusBadRequest, fmt.Errorf("error receiving data: %w", err))
+		return
+	}
+	expected := r.Trailer.Get(checksumTrailer)
+	actual := checksumPrefix + hex.EncodeToString(checksum.Sum(nil))
+	if expected != actual {
+		writer.AbortAndClose()
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid checksum: expected %q, got %q", expected, actual))
+		return
+	}
+	if err = writer.Close(); err != nil {
+		writeError(w, statusCode(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *handler) deleteVersion(w http.ResponseWriter, t time.Time) {
+	s, ok := h.store.(deleter)
+	if !ok {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("store does not support deleting versions"))
+		return
+	}
+	if err := s.DeleteVersion(t); err != nil {
+		writeError(w, statusCode(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func statusCode(err error) int {
+	switch {
+	case store.IsVersionNotFound(err):
+		return http.StatusNotFound
+	case store.IsVersionAlreadyExists(err):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(errorJSON{Error: err.Error()})
+}
+
+// trailerValue makes sure that error message can be sent as a header value
+func trailerValue(err error) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(err.Error())
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
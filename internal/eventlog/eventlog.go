@@ -0,0 +1,55 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+// Package eventlog logs events emitted by background jobs - compacter, replicator and scrubber. Each package
+// describes its events as log entries, and this package writes them to a structured logger.
+package eventlog
+
+// Logger is a structured logger. Arguments are key-value pairs. *slog.Logger from the standard library
+// implements this interface.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelError
+)
+
+// Entry describes an event. Args are key-value pairs.
+type Entry struct {
+	Level Level
+	Msg   string
+	Args  []interface{}
+}
+
+func Debug(msg string, args ...interface{}) Entry {
+	return Entry{Level: LevelDebug, Msg: msg, Args: args}
+}
+
+func Info(msg string, args ...interface{}) Entry {
+	return Entry{Level: LevelInfo, Msg: msg, Args: args}
+}
+
+func Error(msg string, args ...interface{}) Entry {
+	return Entry{Level: LevelError, Msg: msg, Args: args}
+}
+
+// Write writes entries to logger, in order
+func Write(logger Logger, entries ...Entry) {
+	for _, e := range entries {
+		switch e.Level {
+		case LevelDebug:
+			logger.Debug(e.Msg, e.Args...)
+		case LevelInfo:
+			logger.Info(e.Msg, e.Args...)
+		default:
+			logger.Error(e.Msg, e.Args...)
+		}
+	}
+}
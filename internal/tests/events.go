@@ -0,0 +1,55 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package tests
+
+import "sync"
+
+// EventRecorder records events emitted by background goroutines
+type EventRecorder struct {
+	mutex  sync.Mutex
+	events []interface{}
+}
+
+func (r *EventRecorder) Record(event interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *EventRecorder) Events() []interface{} {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]interface{}{}, r.events...)
+}
+
+type LogEntry struct {
+	Level string
+	Msg   string
+	Args  []interface{}
+}
+
+// LoggerMock records log entries. It implements structured Logger interfaces used by background loops.
+type LoggerMock struct {
+	EventRecorder
+}
+
+func (l *LoggerMock) Debug(msg string, args ...interface{}) {
+	l.Record(LogEntry{Level: "DEBUG", Msg: msg, Args: args})
+}
+
+func (l *LoggerMock) Info(msg string, args ...interface{}) {
+	l.Record(LogEntry{Level: "INFO", Msg: msg, Args: args})
+}
+
+func (l *LoggerMock) Error(msg string, args ...interface{}) {
+	l.Record(LogEntry{Level: "ERROR", Msg: msg, Args: args})
+}
+
+func (l *LoggerMock) Entries() []LogEntry {
+	var entries []LogEntry
+	for _, e := range l.Events() {
+		entries = append(entries, e.(LogEntry))
+	}
+	return entries
+}
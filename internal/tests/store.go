@@ -16,6 +16,7 @@ type StoreMock struct {
 	ReturnVersions      []store.Version
 	ReturnVersionsError error
 	ReturnWriter        store.Writer
+	ReturnWriterError   error
 }
 
 func (s *StoreMock) Reader(...store.ReaderOption) (store.Reader, error) {
@@ -27,7 +28,7 @@ func (s *StoreMock) Versions() ([]store.Version, error) {
 }
 
 func (s *StoreMock) Writer(...store.WriterOption) (store.Writer, error) {
-	return s.ReturnWriter, nil
+	return s.ReturnWriter, s.ReturnWriterError
 }
 
 type ReaderMock struct{}
@@ -0,0 +1,360 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+// Package memfs provides an in-memory filesystem which can be used by store instead of the OS one:
+//
+//	s, err := store.Open("dir", store.FileSystem(memfs.New()))
+//
+// It is useful in tests, because nothing is written to disk and each test can use its own isolated filesystem.
+// Data is lost when the FileSystem is garbage collected.
+package memfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacekolszak/deebee/store"
+)
+
+// FileSystem implements store.FS. It is safe for concurrent use by multiple goroutines. Names are slash-separated
+// paths, relative names are resolved against the root directory.
+type FileSystem struct {
+	mutex sync.Mutex
+	nodes map[string]*node // keys are cleaned absolute paths
+}
+
+type node struct {
+	dir     bool
+	perm    fs.FileMode
+	data    []byte
+	modTime time.Time
+}
+
+// New returns an empty filesystem containing only the root directory
+func New() *FileSystem {
+	return &FileSystem{
+		nodes: map[string]*node{
+			"/": {dir: true, perm: 0775, modTime: time.Now()},
+		},
+	}
+}
+
+func clean(name string) string {
+	return path.Join("/", name)
+}
+
+func (f *FileSystem) OpenFile(name string, flag int, perm fs.FileMode) (store.File, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	key := clean(name)
+	n, exists := f.nodes[key]
+	switch {
+	case exists && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, pathError("open", name, fs.ErrExist)
+	case exists && n.dir:
+		return nil, pathError("open", name, errors.New("is a directory"))
+	case !exists && flag&os.O_CREATE == 0:
+		return nil, pathError("open", name, fs.ErrNotExist)
+	case !exists:
+		if err := f.checkParentDir(key); err != nil {
+			return nil, pathError("open", name, err)
+		}
+		n = &node{perm: perm, modTime: time.Now()}
+		f.nodes[key] = n
+	case flag&os.O_TRUNC != 0:
+		n.data = nil
+		n.modTime = time.Now()
+	}
+
+	file := &file{
+		fs:       f,
+		name:     name,
+		node:     n,
+		readable: flag&os.O_WRONLY == 0,
+		writable: flag&(os.O_WRONLY|os.O_RDWR) != 0,
+	}
+	if flag&os.O_APPEND != 0 {
+		file.offset = len(n.data)
+	}
+	return file, nil
+}
+
+// checkParentDir must be called with mutex locked
+func (f *FileSystem) checkParentDir(key string) error {
+	parent, exists := f.nodes[path.Dir(key)]
+	if !exists {
+		return fs.ErrNotExist
+	}
+	if !parent.dir {
+		return errors.New("not a directory")
+	}
+	return nil
+}
+
+// ReadDir returns entries sorted by name
+func (f *FileSystem) ReadDir(dir string) ([]fs.FileInfo, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	key := clean(dir)
+	n, exists := f.nodes[key]
+	if !exists {
+		return nil, pathError("readdir", dir, fs.ErrNotExist)
+	}
+	if !n.dir {
+		return nil, pathError("readdir", dir, errors.New("not a directory"))
+	}
+
+	var infos []fs.FileInfo
+	for childKey, child := range f.nodes {
+		if childKey != key && path.Dir(childKey) == key {
+			infos = append(infos, newFileInfo(childKey, child))
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+	return infos, nil
+}
+
+// Stat works exactly like Lstat, because FileSystem does not support symbolic links
+func (f *FileSystem) Stat(name string) (fs.FileInfo, error) {
+	return f.stat("stat", name)
+}
+
+func (f *FileSystem) Lstat(name string) (fs.FileInfo, error) {
+	return f.stat("lstat", name)
+}
+
+func (f *FileSystem) stat(op, name string) (fs.FileInfo, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	key := clean(name)
+	n, exists := f.nodes[key]
+	if !exists {
+		return nil, pathError(op, name, fs.ErrNotExist)
+	}
+	return newFileInfo(key, n), nil
+}
+
+func (f *FileSystem) MkdirAll(dir string, perm fs.FileMode) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	key := clean(dir)
+	var missing []string
+	for ; key != "/"; key = path.Dir(key) {
+		n, exists := f.nodes[key]
+		if exists && !n.dir {
+			return pathError("mkdir", dir, errors.New("not a directory"))
+		}
+		if exists {
+			break
+		}
+		missing = append(missing, key)
+	}
+	for _, key := range missing {
+		f.nodes[key] = &node{dir: true, perm: perm, modTime: time.Now()}
+	}
+	return nil
+}
+
+// Remove removes a file or an empty directory. Files which are still open can be used until closed.
+func (f *FileSystem) Remove(name string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	key := clean(name)
+	n, exists := f.nodes[key]
+	if !exists {
+		return pathError("remove", name, fs.ErrNotExist)
+	}
+	if n.dir && f.hasChildren(key) {
+		return pathError("remove", name, errors.New("directory not empty"))
+	}
+	delete(f.nodes, key)
+	return nil
+}
+
+func (f *FileSystem) hasChildren(key string) bool {
+	for childKey := range f.nodes {
+		if childKey != key && path.Dir(childKey) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Rename moves a file or a directory. Existing file is replaced atomically.
+func (f *FileSystem) Rename(oldName, newName string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	oldKey, newKey := clean(oldName), clean(newName)
+	n, exists := f.nodes[oldKey]
+	if !exists {
+		return linkError(oldName, newName, fs.ErrNotExist)
+	}
+	if err := f.checkParentDir(newKey); err != nil {
+		return linkError(oldName, newName, err)
+	}
+	if target, targetExists := f.nodes[newKey]; targetExists && target.dir {
+		return linkError(oldName, newName, fs.ErrExist)
+	}
+	if oldKey == newKey {
+		return nil
+	}
+
+	if n.dir {
+		if strings.HasPrefix(newKey, oldKey+"/") {
+			return linkError(oldName, newName, errors.New("cannot move directory into itself"))
+		}
+		for childKey, child := range f.nodes {
+			if strings.HasPrefix(childKey, oldKey+"/") {
+				delete(f.nodes, childKey)
+				f.nodes[newKey+strings.TrimPrefix(childKey, oldKey)] = child
+			}
+		}
+	}
+	delete(f.nodes, oldKey)
+	f.nodes[newKey] = n
+	return nil
+}
+
+// SyncDir does nothing, because FileSystem is not durable anyway
+func (f *FileSystem) SyncDir(dir string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	n, exists := f.nodes[clean(dir)]
+	if !exists {
+		return pathError("sync", dir, fs.ErrNotExist)
+	}
+	if !n.dir {
+		return pathError("sync", dir, errors.New("not a directory"))
+	}
+	return nil
+}
+
+func pathError(op, name string, err error) error {
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+func linkError(oldName, newName string, err error) error {
+	return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+}
+
+type file struct {
+	fs       *FileSystem
+	name     string
+	node     *node
+	offset   int
+	readable bool
+	writable bool
+	closed   bool
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+
+	if err := f.check("read", f.readable); err != nil {
+		return 0, err
+	}
+	if f.offset >= len(f.node.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[f.offset:])
+	f.offset += n
+	return n, nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+
+	if err := f.check("write", f.writable); err != nil {
+		return 0, err
+	}
+	end := f.offset + len(p)
+	if end > len(f.node.data) {
+		data := make([]byte, end)
+		copy(data, f.node.data)
+		f.node.data = data
+	}
+	copy(f.node.data[f.offset:], p)
+	f.offset = end
+	f.node.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *file) check(op string, allowed bool) error {
+	if f.closed {
+		return pathError(op, f.name, fs.ErrClosed)
+	}
+	if !allowed {
+		return pathError(op, f.name, fs.ErrPermission)
+	}
+	return nil
+}
+
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) Sync() error {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+
+	if f.closed {
+		return pathError("sync", f.name, fs.ErrClosed)
+	}
+	return nil
+}
+
+func (f *file) Close() error {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+
+	if f.closed {
+		return pathError("close", f.name, fs.ErrClosed)
+	}
+	f.closed = true
+	return nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func newFileInfo(key string, n *node) fileInfo {
+	info := fileInfo{
+		name:    path.Base(key),
+		size:    int64(len(n.data)),
+		mode:    n.perm,
+		modTime: n.modTime,
+	}
+	if n.dir {
+		info.mode |= fs.ModeDir
+	}
+	return info
+}
+
+func (i fileInfo) Name() string       { return i.name }
+func (i fileInfo) Size() int64        { return i.size }
+func (i fileInfo) Mode() fs.FileMode  { return i.mode }
+func (i fileInfo) ModTime() time.Time { return i.modTime }
+func (i fileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i fileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,183 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package memfs_test
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/jacekolszak/deebee/memfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSystem_OpenFile(t *testing.T) {
+
+	t.Run("should write and read file", func(t *testing.T) {
+		f := memfs.New()
+		writeFile(t, f, "file", "data")
+		// when
+		content := readFile(t, f, "file")
+		// then
+		assert.Equal(t, "data", content)
+	})
+
+	t.Run("should return error when file does not exist", func(t *testing.T) {
+		f := memfs.New()
+		_, err := f.OpenFile("missing", os.O_RDONLY, 0)
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("should return error when parent directory does not exist", func(t *testing.T) {
+		f := memfs.New()
+		_, err := f.OpenFile("dir/file", os.O_CREATE|os.O_WRONLY, 0664)
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("should return error when file exists and O_EXCL is used", func(t *testing.T) {
+		f := memfs.New()
+		writeFile(t, f, "file", "data")
+		// when
+		_, err := f.OpenFile("file", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0664)
+		// then
+		assert.ErrorIs(t, err, fs.ErrExist)
+	})
+
+	t.Run("should truncate file", func(t *testing.T) {
+		f := memfs.New()
+		writeFile(t, f, "file", "long data")
+		// when
+		writeFile(t, f, "file", "new")
+		// then
+		assert.Equal(t, "new", readFile(t, f, "file"))
+	})
+
+	t.Run("should not read from file opened for writing", func(t *testing.T) {
+		f := memfs.New()
+		file, err := f.OpenFile("file", os.O_CREATE|os.O_WRONLY, 0664)
+		require.NoError(t, err)
+		// when
+		_, err = file.Read(make([]byte, 1))
+		// then
+		assert.ErrorIs(t, err, fs.ErrPermission)
+	})
+
+	t.Run("should return error when file is used after Close", func(t *testing.T) {
+		f := memfs.New()
+		file, err := f.OpenFile("file", os.O_CREATE|os.O_WRONLY, 0664)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+		// when
+		_, err = file.Write([]byte("data"))
+		// then
+		assert.ErrorIs(t, err, fs.ErrClosed)
+		assert.ErrorIs(t, file.Close(), fs.ErrClosed)
+	})
+}
+
+func TestFileSystem_ReadDir(t *testing.T) {
+
+	t.Run("should return direct children sorted by name", func(t *testing.T) {
+		f := memfs.New()
+		require.NoError(t, f.MkdirAll("dir/sub", 0775))
+		writeFile(t, f, "dir/b", "bb")
+		writeFile(t, f, "dir/a", "a")
+		writeFile(t, f, "dir/sub/c", "c")
+		// when
+		infos, err := f.ReadDir("dir")
+		// then
+		require.NoError(t, err)
+		require.Len(t, infos, 3)
+		assert.Equal(t, "a", infos[0].Name())
+		assert.Equal(t, int64(1), infos[0].Size())
+		assert.Equal(t, "b", infos[1].Name())
+		assert.Equal(t, "sub", infos[2].Name())
+		assert.True(t, infos[2].IsDir())
+	})
+
+	t.Run("should return error when directory does not exist", func(t *testing.T) {
+		_, err := memfs.New().ReadDir("missing")
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+}
+
+func TestFileSystem_Remove(t *testing.T) {
+
+	t.Run("should remove file", func(t *testing.T) {
+		f := memfs.New()
+		writeFile(t, f, "file", "data")
+		// when
+		err := f.Remove("file")
+		// then
+		require.NoError(t, err)
+		_, err = f.Lstat("file")
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("should not remove directory which is not empty", func(t *testing.T) {
+		f := memfs.New()
+		require.NoError(t, f.MkdirAll("dir", 0775))
+		writeFile(t, f, "dir/file", "data")
+		// when
+		err := f.Remove("dir")
+		// then
+		assert.Error(t, err)
+	})
+
+	t.Run("should return error when file does not exist", func(t *testing.T) {
+		err := memfs.New().Remove("missing")
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+}
+
+func TestFileSystem_Rename(t *testing.T) {
+
+	t.Run("should replace existing file", func(t *testing.T) {
+		f := memfs.New()
+		writeFile(t, f, "old", "new data")
+		writeFile(t, f, "new", "old data")
+		// when
+		err := f.Rename("old", "new")
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "new data", readFile(t, f, "new"))
+		_, err = f.Stat("old")
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("should move directory with its files", func(t *testing.T) {
+		f := memfs.New()
+		require.NoError(t, f.MkdirAll("dir", 0775))
+		writeFile(t, f, "dir/file", "data")
+		// when
+		err := f.Rename("dir", "moved")
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "data", readFile(t, f, "moved/file"))
+	})
+
+	t.Run("should return error when file does not exist", func(t *testing.T) {
+		err := memfs.New().Rename("missing", "new")
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+}
+
+func writeFile(t *testing.T, f *memfs.FileSystem, name, content string) {
+	file, err := f.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0664)
+	require.NoError(t, err)
+	_, err = file.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+}
+
+func readFile(t *testing.T, f *memfs.FileSystem, name string) string {
+	file, err := f.OpenFile(name, os.O_RDONLY, 0)
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(file)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	return string(content)
+}
@@ -0,0 +1,85 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+// Package memstore provides a store keeping all versions in memory. It is intended for unit tests of code
+// persisting its state with DeeBee: it is fast, does not leave any files behind and each instance is isolated.
+//
+// Store behaves exactly like store.Store, because it is a store.Store using in-memory filesystem (see memfs
+// package). Checksums are written and validated as usual, so corrupted data can be simulated with Corrupt.
+package memstore
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/jacekolszak/deebee/memfs"
+	"github.com/jacekolszak/deebee/store"
+)
+
+const dir = "/deebee" // store directory inside in-memory filesystem
+
+// Store is a store.Store which data is kept in memory. It can be used everywhere store.Store is used, for example
+// with codec, compacter or replicator packages.
+type Store struct {
+	*store.Store
+	fs *memfs.FileSystem
+}
+
+// New returns an empty in-memory store. All store options can be used, except FileSystem which is ignored.
+func New(options ...store.Option) (*Store, error) {
+	fsys := memfs.New()
+	s, err := store.Open(dir, append(options, store.FileSystem(fsys))...)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{Store: s, fs: fsys}, nil
+}
+
+// Corrupt changes the first byte of version data, so reading the version will fail with checksum error
+func (s *Store) Corrupt(t time.Time) error {
+	versions, err := s.Versions()
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if version.Time.Equal(t) {
+			return s.corrupt(version)
+		}
+	}
+	return store.NewVersionNotFoundError(fmt.Sprintf("version %s not found", t))
+}
+
+func (s *Store) corrupt(version store.Version) error {
+	if version.Size == 0 {
+		return fmt.Errorf("version %s is empty and cannot be corrupted", version.Time)
+	}
+	name := path.Join(dir, store.DataFileName(version.Time))
+	file, err := s.fs.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	first := make([]byte, 1)
+	if _, err = file.Read(first); err != nil {
+		_ = file.Close()
+		return err
+	}
+	_ = file.Close()
+
+	file, err = s.fs.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write([]byte{first[0] + 1}); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// FileSystem returns the filesystem where store files are kept. It can be used to inspect or modify files directly.
+// Store directory is /deebee.
+func (s *Store) FileSystem() *memfs.FileSystem {
+	return s.fs
+}
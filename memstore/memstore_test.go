@@ -0,0 +1,143 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package memstore_test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jacekolszak/deebee/codec"
+	"github.com/jacekolszak/deebee/compacter"
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/memstore"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+
+	t.Run("should create empty store", func(t *testing.T) {
+		s, err := memstore.New()
+		require.NoError(t, err)
+		// when
+		versions, err := s.Versions()
+		// then
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+
+	t.Run("should write and read data", func(t *testing.T) {
+		s := newStore(t)
+		tests.WriteData(t, s.Store, []byte("v1"))
+		tests.WriteData(t, s.Store, []byte("v2"))
+		// when
+		data := tests.ReadData(t, s.Store)
+		// then
+		assert.Equal(t, []byte("v2"), data)
+	})
+
+	t.Run("should isolate stores", func(t *testing.T) {
+		s1 := newStore(t)
+		tests.WriteData(t, s1.Store, []byte("data"))
+		s2 := newStore(t)
+		// when
+		versions, err := s2.Versions()
+		// then
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+
+	t.Run("should use store options", func(t *testing.T) {
+		s, err := memstore.New(store.Checksum(store.SHA256), store.Compression(store.Gzip))
+		require.NoError(t, err)
+		// when
+		tests.WriteData(t, s.Store, []byte("data"))
+		// then
+		assert.Equal(t, []byte("data"), tests.ReadData(t, s.Store))
+	})
+
+	t.Run("should delete version", func(t *testing.T) {
+		s := newStore(t)
+		v := tests.WriteData(t, s.Store, []byte("data"))
+		// when
+		err := s.DeleteVersion(v.Time)
+		// then
+		require.NoError(t, err)
+		versions, err := s.Versions()
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+
+	t.Run("should be usable with codec and compacter", func(t *testing.T) {
+		s := newStore(t)
+		for i := 0; i < 3; i++ {
+			err := codec.Write(s, func(writer io.Writer) error {
+				_, err := writer.Write([]byte("data"))
+				return err
+			})
+			require.NoError(t, err)
+		}
+		// when
+		err := compacter.RunOnce(s, compacter.KeepLast(1))
+		// then
+		require.NoError(t, err)
+		versions, err := s.Versions()
+		require.NoError(t, err)
+		assert.Len(t, versions, 1)
+	})
+}
+
+func TestStore_Corrupt(t *testing.T) {
+
+	t.Run("should make reading fail", func(t *testing.T) {
+		s := newStore(t)
+		v := tests.WriteData(t, s.Store, []byte("data"))
+		// when
+		err := s.Corrupt(v.Time)
+		// then
+		require.NoError(t, err)
+		reader, err := s.Reader()
+		require.NoError(t, err)
+		_, err = io.ReadAll(reader)
+		assert.Error(t, err)
+	})
+
+	t.Run("should make ReadLatest fall back to previous version", func(t *testing.T) {
+		s := newStore(t)
+		tests.WriteData(t, s.Store, []byte("v1"))
+		v2 := tests.WriteData(t, s.Store, []byte("v2"))
+		require.NoError(t, s.Corrupt(v2.Time))
+		decoder := &tests.FakeDecoder{}
+		// when
+		_, err := codec.ReadLatest(s, decoder.Decode)
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v1"), decoder.DataRead())
+	})
+
+	t.Run("should return error when version does not exist", func(t *testing.T) {
+		s := newStore(t)
+		// when
+		err := s.Corrupt(time.Now())
+		// then
+		assert.True(t, store.IsVersionNotFound(err))
+	})
+
+	t.Run("should return error when version is empty", func(t *testing.T) {
+		s := newStore(t)
+		v := tests.WriteData(t, s.Store, []byte{})
+		// when
+		err := s.Corrupt(v.Time)
+		// then
+		assert.Error(t, err)
+	})
+}
+
+func newStore(t *testing.T) *memstore.Store {
+	s, err := memstore.New()
+	require.NoError(t, err)
+	return s
+}
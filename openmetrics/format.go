@@ -0,0 +1,155 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package openmetrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	counterType   = "counter"
+	gaugeType     = "gauge"
+	histogramType = "histogram"
+)
+
+type family struct {
+	name    string
+	typ     string
+	unit    string
+	help    string
+	samples []sample
+}
+
+type sample struct {
+	suffix string
+	labels []label
+	value  float64
+}
+
+type label struct {
+	name  string
+	value string
+}
+
+func (f *family) add(suffix string, value float64, labels ...label) {
+	f.samples = append(f.samples, sample{suffix: suffix, labels: labels, value: value})
+}
+
+func (f *family) addHistogram(h *histogram, labels ...label) {
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		f.add("_bucket", float64(cumulative), withLabel(labels, "le", formatFloat(bound))...)
+	}
+	f.add("_bucket", float64(h.count), withLabel(labels, "le", "+Inf")...)
+	f.add("_sum", h.sum, labels...)
+	f.add("_count", float64(h.count), labels...)
+}
+
+// withLabel returns a copy of labels with additional label appended
+func withLabel(labels []label, name, value string) []label {
+	result := make([]label, len(labels), len(labels)+1)
+	copy(result, labels)
+	return append(result, label{name: name, value: value})
+}
+
+// writeFamilies writes metric families in OpenMetrics text format
+func writeFamilies(w io.Writer, families []*family) (int64, error) {
+	counter := &countingWriter{writer: w}
+	b := bufio.NewWriter(counter)
+	for _, f := range families {
+		if len(f.samples) == 0 {
+			continue
+		}
+		b.WriteString("# TYPE " + f.name + " " + f.typ + "\n")
+		if f.unit != "" {
+			b.WriteString("# UNIT " + f.name + " " + f.unit + "\n")
+		}
+		b.WriteString("# HELP " + f.name + " " + escape(f.help) + "\n")
+		for _, s := range f.samples {
+			b.WriteString(f.name + s.suffix)
+			writeLabels(b, s.labels)
+			b.WriteString(" " + formatFloat(s.value) + "\n")
+		}
+	}
+	b.WriteString("# EOF\n")
+	err := b.Flush()
+	return counter.n, err
+}
+
+func writeLabels(b *bufio.Writer, labels []label) {
+	if len(labels) == 0 {
+		return
+	}
+	b.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l.name + `="` + escape(l.value) + `"`)
+	}
+	b.WriteByte('}')
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+type countingWriter struct {
+	writer io.Writer
+	n      int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.writer.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// durationBuckets are upper bounds of histogram buckets in seconds
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+type histogram struct {
+	bounds []float64
+	counts []uint64 // not cumulative
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
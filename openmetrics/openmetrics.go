@@ -0,0 +1,359 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+// Package openmetrics exposes DeeBee metrics in OpenMetrics text format, which can be scraped by Prometheus and
+// other compatible monitoring systems. No third-party client library is needed.
+package openmetrics
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jacekolszak/deebee/compacter"
+	"github.com/jacekolszak/deebee/replicator"
+	"github.com/jacekolszak/deebee/store"
+)
+
+const contentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// Store is a store which metrics can be collected. *store.Store implements this interface.
+type Store interface {
+	Metrics() store.Metrics
+	Versions() ([]store.Version, error)
+}
+
+// NewCollector returns an empty Collector. Stores must be registered with RegisterStore. Compacter and replicator
+// metrics are collected from events - use CompacterEvents and ReplicatorEvents options.
+func NewCollector() *Collector {
+	return &Collector{}
+}
+
+// Collector collects metrics from stores, compacters and replicators. It implements http.Handler serving metrics
+// in OpenMetrics text format. Collector is safe for concurrent use.
+type Collector struct {
+	mutex       sync.Mutex
+	stores      []namedStore // in registration order
+	compacters  []*compacterMetrics
+	replicators []*replicatorMetrics
+}
+
+type namedStore struct {
+	name  string
+	store Store
+}
+
+type compacterMetrics struct {
+	storeName       string
+	deletedVersions uint64
+	succeeded       uint64
+	failed          uint64
+	duration        *histogram
+}
+
+type replicatorMetrics struct {
+	name            string
+	succeeded       uint64
+	skipped         uint64
+	failed          uint64
+	duration        *histogram
+	lastVersionTime time.Time // time of the latest version which is known to be in the target store
+	tracker         *replicator.Tracker
+}
+
+// RegisterStore registers the store under a given name. Name is used as "store" label value.
+func (c *Collector) RegisterStore(name string, s Store) error {
+	if s == nil {
+		return errors.New("nil store")
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, registered := range c.stores {
+		if registered.name == name {
+			return fmt.Errorf("store %s already registered", name)
+		}
+	}
+	c.stores = append(c.stores, namedStore{name: name, store: s})
+	return nil
+}
+
+// CompacterEvents returns compacter option collecting metrics of compacter for a store with a given name:
+//
+//	compacter.Start(ctx, s, collector.CompacterEvents("local"))
+func (c *Collector) CompacterEvents(storeName string) compacter.Option {
+	m := c.compacterMetrics(storeName)
+
+	return compacter.OnEvent(func(e compacter.Event) {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		switch e := e.(type) {
+		case compacter.VersionDeleted:
+			m.deletedVersions++
+		case compacter.CompactionSucceeded:
+			if e.DryRun {
+				return
+			}
+			m.succeeded++
+			m.duration.observe(e.Duration.Seconds())
+		case compacter.CompactionFailed:
+			m.failed++
+			m.duration.observe(e.Duration.Seconds())
+		}
+	})
+}
+
+// ReplicatorEvents returns replicator option collecting metrics of replication with a given name:
+//
+//	replicator.StartFromTo(ctx, local, shared, collector.ReplicatorEvents("local-to-shared"))
+func (c *Collector) ReplicatorEvents(replicationName string) replicator.Option {
+	m := c.replicatorMetrics(replicationName)
+
+	track := replicator.Track(m.tracker)
+	onEvent := replicator.OnEvent(func(e replicator.Event) {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		switch e := e.(type) {
+		case replicator.ReplicationSucceeded:
+			m.succeeded++
+			m.duration.observe(e.Duration.Seconds())
+			m.lastVersionTime = e.Version.Time
+		case replicator.ReplicationSkipped:
+			m.skipped++
+			m.lastVersionTime = e.Version.Time
+		case replicator.ReplicationFailed:
+			m.failed++
+			m.duration.observe(e.Duration.Seconds())
+		}
+	})
+	return func(o *replicator.Options) error {
+		if err := track(o); err != nil {
+			return err
+		}
+		return onEvent(o)
+	}
+}
+
+func (c *Collector) compacterMetrics(storeName string) *compacterMetrics {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, m := range c.compacters {
+		if m.storeName == storeName {
+			return m
+		}
+	}
+	m := &compacterMetrics{storeName: storeName, duration: newHistogram(durationBuckets)}
+	c.compacters = append(c.compacters, m)
+	return m
+}
+
+func (c *Collector) replicatorMetrics(name string) *replicatorMetrics {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, m := range c.replicators {
+		if m.name == name {
+			return m
+		}
+	}
+	m := &replicatorMetrics{name: name, duration: newHistogram(durationBuckets), tracker: replicator.NewTracker()}
+	c.replicators = append(c.replicators, m)
+	return m
+}
+
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = c.WriteTo(w)
+}
+
+// WriteTo writes all metrics in OpenMetrics text format
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	return writeFamilies(w, c.collect())
+}
+
+func (c *Collector) collect() []*family {
+	c.mutex.Lock()
+	stores := append([]namedStore{}, c.stores...)
+	compacters := c.collectCompacters()
+	replicators := c.collectReplicators()
+	c.mutex.Unlock()
+
+	// listing versions does I/O, therefore it is done without holding the mutex, which would block event listeners
+	// of compacters and replicators
+	var families []*family
+	families = append(families, collectStores(stores)...)
+	families = append(families, compacters...)
+	families = append(families, replicators...)
+	return families
+}
+
+func collectStores(stores []namedStore) []*family {
+	var (
+		readerCalls   = &family{name: "deebee_reader_calls", typ: counterType, help: "Number of Store.Reader calls"}
+		readBytes     = &family{name: "deebee_read_bytes", typ: counterType, unit: "bytes", help: "Number of bytes read"}
+		readTime      = &family{name: "deebee_read_time_seconds", typ: counterType, unit: "seconds", help: "Total time spent reading"}
+		writerCalls   = &family{name: "deebee_writer_calls", typ: counterType, help: "Number of Store.Writer calls"}
+		writes        = &family{name: "deebee_writes", typ: counterType, help: "Number of finished writes"}
+		writtenBytes  = &family{name: "deebee_written_bytes", typ: counterType, unit: "bytes", help: "Number of bytes written"}
+		writeTime     = &family{name: "deebee_write_time_seconds", typ: counterType, unit: "seconds", help: "Total time spent writing"}
+		readChecksum  = &family{name: "deebee_read_checksum_failures", typ: counterType, help: "Number of versions which data did not match the checksum"}
+		readErrors    = &family{name: "deebee_read_errors", typ: counterType, help: "Number of read errors by kind"}
+		readLatency   = &family{name: "deebee_read_latency_seconds", typ: histogramType, unit: "seconds", help: "Time of reading a version"}
+		writeErrors   = &family{name: "deebee_write_errors", typ: counterType, help: "Number of write errors by kind"}
+		writeLatency  = &family{name: "deebee_write_latency_seconds", typ: histogramType, unit: "seconds", help: "Time of writing a version"}
+		syncTime      = &family{name: "deebee_sync_time_seconds", typ: counterType, unit: "seconds", help: "Total time spent syncing files"}
+		syncLatency   = &family{name: "deebee_sync_latency_seconds", typ: histogramType, unit: "seconds", help: "Time of syncing a version"}
+		versions      = &family{name: "deebee_versions", typ: gaugeType, help: "Number of versions in the store"}
+		diskUsage     = &family{name: "deebee_disk_usage_bytes", typ: gaugeType, unit: "bytes", help: "Total size of all versions"}
+		latestVersion = &family{name: "deebee_latest_version_timestamp_seconds", typ: gaugeType, unit: "seconds", help: "Time of the latest version"}
+	)
+
+	for _, s := range stores {
+		storeLabel := label{name: "store", value: s.name}
+
+		m := s.store.Metrics()
+		readerCalls.add("_total", float64(m.Read.ReaderCalls), storeLabel)
+		readBytes.add("_total", float64(m.Read.TotalBytesRead), storeLabel)
+		readTime.add("_total", m.Read.TotalTime.Seconds(), storeLabel)
+		writerCalls.add("_total", float64(m.Write.WriterCalls), storeLabel)
+		writes.add("_total", float64(m.Write.Successful), storeLabel, label{name: "result", value: "successful"})
+		writes.add("_total", float64(m.Write.Aborted), storeLabel, label{name: "result", value: "aborted"})
+		writtenBytes.add("_total", float64(m.Write.TotalBytesWritten), storeLabel)
+		writeTime.add("_total", m.Write.TotalTime.Seconds(), storeLabel)
+		readChecksum.add("_total", float64(m.Read.ChecksumFailures), storeLabel)
+		addErrors(readErrors, storeLabel, map[string]int{
+			"version_not_found": m.Read.Errors.VersionNotFound,
+			"open":              m.Read.Errors.Open,
+			"read":              m.Read.Errors.Read,
+			"close":             m.Read.Errors.Close,
+		})
+		readLatency.addHistogram(storeHistogram(m.Read.Latency), storeLabel)
+		addErrors(writeErrors, storeLabel, map[string]int{
+			"version_already_exists": m.Write.Errors.VersionAlreadyExists,
+			"open":                   m.Write.Errors.Open,
+			"write":                  m.Write.Errors.Write,
+			"sync":                   m.Write.Errors.Sync,
+			"commit":                 m.Write.Errors.Commit,
+		})
+		writeLatency.addHistogram(storeHistogram(m.Write.Latency), storeLabel)
+		syncTime.add("_total", m.Write.TotalSyncTime.Seconds(), storeLabel)
+		syncLatency.addHistogram(storeHistogram(m.Write.SyncLatency), storeLabel)
+
+		storeVersions, err := s.store.Versions()
+		if err != nil {
+			continue // gauges are not reported when versions cannot be listed
+		}
+		versions.add("", float64(len(storeVersions)), storeLabel)
+		var size int64
+		for _, v := range storeVersions {
+			size += v.Size
+		}
+		diskUsage.add("", float64(size), storeLabel)
+		if len(storeVersions) > 0 {
+			latestVersion.add("", unixSeconds(storeVersions[len(storeVersions)-1].Time), storeLabel)
+		}
+	}
+
+	return []*family{
+		readerCalls, readBytes, readTime,
+		writerCalls, writes, writtenBytes, writeTime,
+		readChecksum, readErrors, readLatency,
+		writeErrors, writeLatency, syncTime, syncLatency,
+		versions, diskUsage, latestVersion,
+	}
+}
+
+func (c *Collector) collectCompacters() []*family {
+	var (
+		deleted     = &family{name: "deebee_compacter_deleted_versions", typ: counterType, help: "Number of versions deleted by compacter"}
+		compactions = &family{name: "deebee_compactions", typ: counterType, help: "Number of compactions"}
+		duration    = &family{name: "deebee_compaction_duration_seconds", typ: histogramType, unit: "seconds", help: "Duration of compaction"}
+	)
+
+	for _, m := range c.compacters {
+		storeLabel := label{name: "store", value: m.storeName}
+		deleted.add("_total", float64(m.deletedVersions), storeLabel)
+		compactions.add("_total", float64(m.succeeded), storeLabel, label{name: "result", value: "succeeded"})
+		compactions.add("_total", float64(m.failed), storeLabel, label{name: "result", value: "failed"})
+		duration.addHistogram(m.duration, storeLabel)
+	}
+
+	return []*family{deleted, compactions, duration}
+}
+
+func (c *Collector) collectReplicators() []*family {
+	var (
+		replications = &family{name: "deebee_replications", typ: counterType, help: "Number of replication attempts"}
+		duration     = &family{name: "deebee_replication_duration_seconds", typ: histogramType, unit: "seconds", help: "Duration of replication"}
+		lastVersion  = &family{name: "deebee_replication_last_version_timestamp_seconds", typ: gaugeType, unit: "seconds", help: "Time of the latest version replicated to the target store"}
+		lag          = &family{name: "deebee_replication_lag_seconds", typ: gaugeType, unit: "seconds", help: "Age of the oldest version not replicated to the target store yet, 0 when up to date. Maximum of all targets."}
+	)
+
+	for _, m := range c.replicators {
+		replicationLabel := label{name: "replication", value: m.name}
+		replications.add("_total", float64(m.succeeded), replicationLabel, label{name: "result", value: "succeeded"})
+		replications.add("_total", float64(m.skipped), replicationLabel, label{name: "result", value: "skipped"})
+		replications.add("_total", float64(m.failed), replicationLabel, label{name: "result", value: "failed"})
+		duration.addHistogram(m.duration, replicationLabel)
+		if !m.lastVersionTime.IsZero() {
+			lastVersion.add("", unixSeconds(m.lastVersionTime), replicationLabel)
+		}
+		if statuses := m.tracker.Status(); len(statuses) > 0 {
+			var maxLag time.Duration
+			for _, s := range statuses {
+				if s.Lag() > maxLag {
+					maxLag = s.Lag()
+				}
+			}
+			lag.add("", maxLag.Seconds(), replicationLabel)
+		}
+	}
+
+	return []*family{replications, duration, lastVersion, lag}
+}
+
+func addErrors(f *family, storeLabel label, errorsByKind map[string]int) {
+	kinds := make([]string, 0, len(errorsByKind))
+	for kind := range errorsByKind {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		f.add("_total", float64(errorsByKind[kind]), storeLabel, label{name: "kind", value: kind})
+	}
+}
+
+// storeHistogram converts store.Histogram with cumulative buckets to histogram
+func storeHistogram(h store.Histogram) *histogram {
+	buckets := h.Buckets()
+	bounds := make([]float64, len(buckets))
+	converted := newHistogram(bounds)
+	previous := 0
+	for i, bucket := range buckets {
+		bounds[i] = bucket.UpperBound.Seconds()
+		converted.counts[i] = uint64(bucket.Count - previous)
+		previous = bucket.Count
+	}
+	converted.count = uint64(h.Count)
+	converted.sum = h.Sum.Seconds()
+	return converted
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
@@ -0,0 +1,291 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package openmetrics_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacekolszak/deebee/compacter"
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/openmetrics"
+	"github.com/jacekolszak/deebee/replicator"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollector_RegisterStore(t *testing.T) {
+
+	t.Run("should return error for nil store", func(t *testing.T) {
+		collector := openmetrics.NewCollector()
+		err := collector.RegisterStore("local", nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("should return error when store with the same name is already registered", func(t *testing.T) {
+		collector := openmetrics.NewCollector()
+		require.NoError(t, collector.RegisterStore("local", tests.OpenStore(t)))
+		// when
+		err := collector.RegisterStore("local", tests.OpenStore(t))
+		// then
+		assert.Error(t, err)
+	})
+}
+
+func TestCollector_WriteTo(t *testing.T) {
+
+	t.Run("should write only EOF when nothing was registered", func(t *testing.T) {
+		collector := openmetrics.NewCollector()
+		var out bytes.Buffer
+		// when
+		n, err := collector.WriteTo(&out)
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "# EOF\n", out.String())
+		assert.Equal(t, int64(out.Len()), n)
+	})
+
+	t.Run("should write store metrics", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		tests.WriteData(t, s, []byte("data"))
+		version := tests.WriteData(t, s, []byte("more"))
+		tests.ReadData(t, s)
+		abortWrite(t, s)
+		collector := openmetrics.NewCollector()
+		require.NoError(t, collector.RegisterStore("local", s))
+		// when
+		out := writeMetrics(t, collector)
+		// then
+		assert.Contains(t, out, "# TYPE deebee_writes counter\n")
+		assert.Contains(t, out, `deebee_writer_calls_total{store="local"} 3`+"\n")
+		assert.Contains(t, out, `deebee_writes_total{store="local",result="successful"} 2`+"\n")
+		assert.Contains(t, out, `deebee_writes_total{store="local",result="aborted"} 1`+"\n")
+		assert.Contains(t, out, `deebee_written_bytes_total{store="local"} 8`+"\n")
+		assert.Contains(t, out, "# UNIT deebee_written_bytes bytes\n")
+		assert.Contains(t, out, `deebee_reader_calls_total{store="local"} 1`+"\n")
+		assert.Contains(t, out, `deebee_read_bytes_total{store="local"} 4`+"\n")
+		assert.Contains(t, out, `deebee_versions{store="local"} 2`+"\n")
+		assert.Contains(t, out, `deebee_disk_usage_bytes{store="local"} 8`+"\n")
+		assert.Contains(t, out, `deebee_latest_version_timestamp_seconds{store="local"} `+formatSeconds(version.Time)+"\n")
+		assert.Contains(t, out, `deebee_read_checksum_failures_total{store="local"} 0`+"\n")
+		assert.Contains(t, out, `deebee_write_errors_total{store="local",kind="version_already_exists"} 0`+"\n")
+		assert.Contains(t, out, `deebee_read_latency_seconds_count{store="local"} 1`+"\n")
+		assert.Contains(t, out, `deebee_write_latency_seconds_bucket{store="local",le="+Inf"} 2`+"\n")
+		assert.Contains(t, out, `deebee_sync_latency_seconds_count{store="local"} 2`+"\n")
+		assert.True(t, strings.HasSuffix(out, "# EOF\n"))
+	})
+
+	t.Run("should skip gauges when versions cannot be listed", func(t *testing.T) {
+		collector := openmetrics.NewCollector()
+		require.NoError(t, collector.RegisterStore("failing", failingStore{}))
+		// when
+		out := writeMetrics(t, collector)
+		// then
+		assert.Contains(t, out, `deebee_writer_calls_total{store="failing"} 0`)
+		assert.NotContains(t, out, "deebee_versions")
+	})
+
+	t.Run("should escape label values", func(t *testing.T) {
+		collector := openmetrics.NewCollector()
+		require.NoError(t, collector.RegisterStore("a\"b\\c\nd", tests.OpenStore(t)))
+		// when
+		out := writeMetrics(t, collector)
+		// then
+		assert.Contains(t, out, `deebee_writer_calls_total{store="a\"b\\c\nd"} 0`)
+	})
+
+	t.Run("should write compacter metrics", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		tests.WriteData(t, s, []byte("v1"))
+		tests.WriteData(t, s, []byte("v2"))
+		tests.WriteData(t, s, []byte("v3"))
+		collector := openmetrics.NewCollector()
+		// when
+		err := compacter.RunOnce(s, collector.CompacterEvents("local"))
+		// then
+		require.NoError(t, err)
+		out := writeMetrics(t, collector)
+		assert.Contains(t, out, `deebee_compacter_deleted_versions_total{store="local"} 2`+"\n")
+		assert.Contains(t, out, `deebee_compactions_total{store="local",result="succeeded"} 1`+"\n")
+		assert.Contains(t, out, `deebee_compactions_total{store="local",result="failed"} 0`+"\n")
+		assert.Contains(t, out, "# TYPE deebee_compaction_duration_seconds histogram\n")
+		assert.Contains(t, out, `deebee_compaction_duration_seconds_bucket{store="local",le="+Inf"} 1`+"\n")
+		assert.Contains(t, out, `deebee_compaction_duration_seconds_count{store="local"} 1`+"\n")
+	})
+
+	t.Run("should not count dry runs as compactions", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		tests.WriteData(t, s, []byte("v1"))
+		tests.WriteData(t, s, []byte("v2"))
+		collector := openmetrics.NewCollector()
+		// when
+		_, err := compacter.DryRun(s, collector.CompacterEvents("local"))
+		// then
+		require.NoError(t, err)
+		out := writeMetrics(t, collector)
+		assert.Contains(t, out, `deebee_compactions_total{store="local",result="succeeded"} 0`+"\n")
+		assert.Contains(t, out, `deebee_compacter_deleted_versions_total{store="local"} 0`+"\n")
+	})
+
+	t.Run("should write replication metrics", func(t *testing.T) {
+		from := tests.OpenStore(t)
+		version := tests.WriteData(t, from, []byte("data"))
+		to := tests.OpenStore(t)
+		collector := openmetrics.NewCollector()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		// when
+		go func() {
+			_ = replicator.StartFromTo(ctx, from, to,
+				collector.ReplicatorEvents("local-to-shared"),
+				replicator.Interval(time.Millisecond),
+			)
+		}()
+		// then
+		assert.Eventually(t, func() bool {
+			return strings.Contains(writeMetrics(t, collector), `deebee_replications_total{replication="local-to-shared",result="succeeded"} 1`)
+		}, time.Second, time.Millisecond)
+		out := writeMetrics(t, collector)
+		assert.Contains(t, out, `deebee_replication_last_version_timestamp_seconds{replication="local-to-shared"} `+formatSeconds(version.Time))
+		assert.Contains(t, out, `deebee_replication_lag_seconds{replication="local-to-shared"} 0`+"\n")
+		assert.Contains(t, out, `deebee_replication_duration_seconds_count{replication="local-to-shared"} 1`)
+	})
+
+	t.Run("should write lag of failing replication", func(t *testing.T) {
+		from := tests.OpenStore(t)
+		tests.WriteData(t, from, []byte("data"), store.WriteTime(time.Now().Add(-time.Hour)))
+		failing := &tests.StoreMock{ReturnWriterError: errors.New("failed")}
+		collector := openmetrics.NewCollector()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		// when
+		go func() {
+			_ = replicator.StartFromTo(ctx, from, failing,
+				collector.ReplicatorEvents("local-to-shared"),
+				replicator.Interval(time.Millisecond),
+			)
+		}()
+		// then
+		assert.Eventually(t, func() bool {
+			out := writeMetrics(t, collector)
+			return strings.Contains(out, `deebee_replications_total{replication="local-to-shared",result="failed"}`) &&
+				!strings.Contains(out, `deebee_replications_total{replication="local-to-shared",result="failed"} 0`+"\n")
+		}, time.Second, time.Millisecond)
+		lag := metricValue(t, writeMetrics(t, collector), `deebee_replication_lag_seconds{replication="local-to-shared"}`)
+		assert.GreaterOrEqual(t, lag, time.Hour.Seconds())
+	})
+
+	t.Run("should not block event listeners while listing versions", func(t *testing.T) {
+		collector := openmetrics.NewCollector()
+		s := &blockingStore{called: make(chan struct{}, 1), unblock: make(chan struct{})}
+		require.NoError(t, collector.RegisterStore("slow", s))
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _ = collector.WriteTo(io.Discard)
+		}()
+		defer func() {
+			close(s.unblock)
+			<-done
+		}()
+		<-s.called
+		// when
+		async := tests.RunAsync(func() {
+			_ = compacter.RunOnce(tests.OpenStore(t), collector.CompacterEvents("local"))
+		})
+		// then
+		async.WaitOrFailAfter(t, time.Second)
+	})
+}
+
+func TestCollector_ServeHTTP(t *testing.T) {
+
+	t.Run("should serve metrics", func(t *testing.T) {
+		collector := openmetrics.NewCollector()
+		require.NoError(t, collector.RegisterStore("local", tests.OpenStore(t)))
+		request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		response := httptest.NewRecorder()
+		// when
+		collector.ServeHTTP(response, request)
+		// then
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "application/openmetrics-text; version=1.0.0; charset=utf-8", response.Header().Get("Content-Type"))
+		assert.Contains(t, response.Body.String(), `deebee_writer_calls_total{store="local"} 0`)
+	})
+
+	t.Run("should reject POST", func(t *testing.T) {
+		collector := openmetrics.NewCollector()
+		request := httptest.NewRequest(http.MethodPost, "/metrics", nil)
+		response := httptest.NewRecorder()
+		// when
+		collector.ServeHTTP(response, request)
+		// then
+		assert.Equal(t, http.StatusMethodNotAllowed, response.Code)
+	})
+}
+
+func writeMetrics(t *testing.T, collector *openmetrics.Collector) string {
+	var out bytes.Buffer
+	_, err := collector.WriteTo(&out)
+	require.NoError(t, err)
+	return out.String()
+}
+
+func abortWrite(t *testing.T, s *store.Store) {
+	writer, err := s.Writer()
+	require.NoError(t, err)
+	writer.AbortAndClose()
+}
+
+func formatSeconds(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/float64(time.Second), 'g', -1, 64)
+}
+
+// metricValue returns value of metric line starting with a given prefix
+func metricValue(t *testing.T, out, prefix string) float64 {
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, prefix+" ") {
+			value, err := strconv.ParseFloat(strings.TrimPrefix(line, prefix+" "), 64)
+			require.NoError(t, err)
+			return value
+		}
+	}
+	require.Fail(t, "metric not found", prefix)
+	return 0
+}
+
+// blockingStore blocks Versions until unblock is closed
+type blockingStore struct {
+	called  chan struct{}
+	unblock chan struct{}
+}
+
+func (blockingStore) Metrics() store.Metrics {
+	return store.Metrics{}
+}
+
+func (s *blockingStore) Versions() ([]store.Version, error) {
+	s.called <- struct{}{}
+	<-s.unblock
+	return nil, nil
+}
+
+type failingStore struct{}
+
+func (failingStore) Metrics() store.Metrics {
+	return store.Metrics{}
+}
+
+func (failingStore) Versions() ([]store.Version, error) {
+	return nil, errors.New("failed")
+}
@@ -0,0 +1,96 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package replicator
+
+import (
+	"time"
+
+	"github.com/jacekolszak/deebee/internal/eventlog"
+	"github.com/jacekolszak/deebee/store"
+)
+
+// Event is emitted by StartFromTo and StartFromToAll. It is one of ReplicationSucceeded, ReplicationSkipped or
+// ReplicationFailed.
+type Event interface {
+	event()
+}
+
+// ReplicationSucceeded is emitted after version was copied to the target store
+type ReplicationSucceeded struct {
+	Version  store.Version
+	Duration time.Duration
+	Target   int   // index of the target store given to StartFromToAll, always 0 for StartFromTo
+	Bytes    int64 // number of bytes copied, after decompression
+	Repaired bool  // true when corrupted copy in the target store was replaced, see RepairCorrupted
+}
+
+// ReplicationSkipped is emitted when target store already has the version. Version is not read from the source
+// store when the target store implements Versions method.
+type ReplicationSkipped struct {
+	Version store.Version
+	Target  int // index of the target store given to StartFromToAll, always 0 for StartFromTo
+}
+
+// ReplicationFailed is emitted when replication failed. Version is empty when failure happened before
+// the latest version was found in the source store.
+type ReplicationFailed struct {
+	Version  store.Version
+	Err      error
+	Duration time.Duration
+	Target   int // index of the target store given to StartFromToAll, always 0 for StartFromTo
+}
+
+func (ReplicationSucceeded) event() {}
+func (ReplicationSkipped) event()   {}
+func (ReplicationFailed) event()    {}
+
+// OnEvent registers a listener receiving events. Listeners are never called concurrently, even when many targets
+// are replicated at the same time, but slow listener delays replication to all targets.
+func OnEvent(listener func(Event)) Option {
+	return func(o *Options) error {
+		o.listeners = append(o.listeners, listener)
+		return nil
+	}
+}
+
+// Logger is a structured logger used by Log option, such as *slog.Logger
+type Logger = eventlog.Logger
+
+// Log logs all events using logger. By default, StartFromTo logs failures using the standard log package, unless
+// a Logger or event listener was given.
+func Log(logger Logger) Option {
+	return OnEvent(func(e Event) {
+		eventlog.Write(logger, logEntries(e)...)
+	})
+}
+
+func logEntries(e Event) []eventlog.Entry {
+	switch e := e.(type) {
+	case ReplicationSucceeded:
+		return []eventlog.Entry{eventlog.Info("replication succeeded", "version", e.Version.Time, "duration", e.Duration,
+			"target", e.Target, "bytes", e.Bytes, "repaired", e.Repaired)}
+	case ReplicationSkipped:
+		return []eventlog.Entry{eventlog.Debug("replication skipped, version already exists", "version", e.Version.Time,
+			"target", e.Target)}
+	case ReplicationFailed:
+		return []eventlog.Entry{eventlog.Error("replication failed", "version", e.Version.Time, "error", e.Err,
+			"duration", e.Duration, "target", e.Target)}
+	}
+	return nil
+}
+
+func (o *Options) emit(e Event) {
+	for _, listener := range o.listeners {
+		listener(e)
+	}
+	for _, tracker := range o.trackers {
+		tracker.record(e)
+	}
+}
+
+func (o *Options) track(f func(tracker *Tracker)) {
+	for _, tracker := range o.trackers {
+		f(tracker)
+	}
+}
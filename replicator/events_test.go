@@ -0,0 +1,109 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package replicator_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jacekolszak/deebee/codec"
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/replicator"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnEvent(t *testing.T) {
+
+	t.Run("should emit ReplicationSucceeded and then ReplicationSkipped", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		version := tests.WriteData(t, from, []byte("data"))
+		recorder := &tests.EventRecorder{}
+		// when
+		stop := startReplication(t, from, to, replicator.OnEvent(recordEvent(recorder)))
+		defer stop()
+		// then
+		assert.Eventually(t, func() bool {
+			return len(recorder.Events()) >= 2
+		}, time.Second, time.Millisecond)
+		events := recorder.Events()
+		succeeded, ok := events[0].(replicator.ReplicationSucceeded)
+		require.True(t, ok, "ReplicationSucceeded expected")
+		assert.True(t, version.Time.Equal(succeeded.Version.Time))
+		skipped, ok := events[1].(replicator.ReplicationSkipped)
+		require.True(t, ok, "ReplicationSkipped expected")
+		assert.True(t, version.Time.Equal(skipped.Version.Time))
+	})
+
+	t.Run("should emit ReplicationFailed", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		recorder := &tests.EventRecorder{}
+		// when
+		stop := startReplication(t, from, to, replicator.OnEvent(recordEvent(recorder)))
+		defer stop()
+		// then
+		assert.Eventually(t, func() bool {
+			return len(recorder.Events()) >= 1
+		}, time.Second, time.Millisecond)
+		failed, ok := recorder.Events()[0].(replicator.ReplicationFailed)
+		require.True(t, ok, "ReplicationFailed expected")
+		assert.Error(t, failed.Err)
+	})
+}
+
+func TestLog(t *testing.T) {
+
+	t.Run("should log events", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		tests.WriteData(t, from, []byte("data"))
+		logger := &tests.LoggerMock{}
+		// when
+		stop := startReplication(t, from, to, replicator.Log(logger))
+		defer stop()
+		// then
+		assert.Eventually(t, func() bool {
+			return len(logger.Entries()) >= 2
+		}, time.Second, time.Millisecond)
+		entries := logger.Entries()
+		assert.Equal(t, "INFO", entries[0].Level)
+		assert.Equal(t, "DEBUG", entries[1].Level)
+	})
+
+	t.Run("should log failure", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		logger := &tests.LoggerMock{}
+		// when
+		stop := startReplication(t, from, to, replicator.Log(logger))
+		defer stop()
+		// then
+		assert.Eventually(t, func() bool {
+			return len(logger.Entries()) >= 1
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, "ERROR", logger.Entries()[0].Level)
+	})
+}
+
+func startReplication(t *testing.T, from, to *store.Store, options ...replicator.Option) (stop func()) {
+	return startReplicationFrom(t, from, to, options...)
+}
+
+func startReplicationFrom(t *testing.T, from codec.ReadOnlyStore, to *store.Store, options ...replicator.Option) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	options = append(options, replicator.Interval(time.Millisecond))
+	async := tests.RunAsync(func() {
+		_ = replicator.StartFromTo(ctx, from, to, options...)
+	})
+	return func() {
+		cancel()
+		async.WaitOrFailAfter(t, time.Second)
+	}
+}
+
+func recordEvent(recorder *tests.EventRecorder) func(replicator.Event) {
+	return func(e replicator.Event) {
+		recorder.Record(e)
+	}
+}
@@ -0,0 +1,228 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package replicator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jacekolszak/deebee/codec"
+	"github.com/jacekolszak/deebee/store"
+)
+
+// WriteQuorum writes a new version to all stores synchronously, like codec.Write does for a single store. Encoder
+// is run only once and the data is written to all stores at the same time. Version has the same time in all
+// stores, so it can be read using ReadLatest.
+//
+// WriteQuorum succeeds only when at least quorum stores have durably committed the version. Writing to stores
+// which failed is aborted, but the version is not removed from stores which committed it even when
+// the quorum was not reached.
+//
+// Each store is written by a separate goroutine, therefore a slow or hung store does not block writing as long as
+// a quorum of other stores keeps up. WriteQuorum returns as soon as the quorum of stores committed the version
+// or the quorum can no longer be reached - remaining stores finish writing in the background. Store which falls
+// behind the others by more than 64 writes is aborted, which limits the memory used for buffering the data.
+func WriteQuorum(encoder codec.Encoder, quorum int, stores ...codec.WriteOnlyStore) (store.Version, error) {
+	if encoder == nil {
+		return store.Version{}, errors.New("nil encoder")
+	}
+	if len(stores) == 0 {
+		return store.Version{}, errors.New("no stores given")
+	}
+	for _, s := range stores {
+		if s == nil {
+			return store.Version{}, errors.New("nil store")
+		}
+	}
+	if quorum < 1 || quorum > len(stores) {
+		return store.Version{}, fmt.Errorf("quorum must be between 1 and %d", len(stores))
+	}
+
+	w := &quorumWriter{
+		quorum:  quorum,
+		targets: make([]*quorumTarget, len(stores)),
+		results: make(chan quorumResult, len(stores)*(maxQuorumLag+2)),
+	}
+	t := time.Now()
+	for i, s := range stores {
+		w.targets[i] = &quorumTarget{
+			chunks:  make(chan []byte, maxQuorumLag),
+			pending: 1, // opening the writer
+		}
+		go w.run(i, s, t)
+	}
+	if err := w.await(acknowledged); err != nil {
+		w.abort()
+		return store.Version{}, err
+	}
+
+	if err := encoder(w); err != nil {
+		w.abort()
+		return store.Version{}, err
+	}
+
+	if err := w.commit(); err != nil {
+		return store.Version{}, err
+	}
+	return store.Version{Time: t, Size: w.size}, nil
+}
+
+// maxQuorumLag is the number of writes store can fall behind the quorum before it is aborted
+const maxQuorumLag = 64
+
+// quorumWriter sends data to goroutines writing to each store and waits until the quorum of them acknowledged it
+type quorumWriter struct {
+	quorum  int
+	targets []*quorumTarget
+	results chan quorumResult // results of all goroutines, buffered so goroutines never block on sending
+	size    int64
+}
+
+// quorumTarget is a store written by its own goroutine. Fields other than chunks and commit are accessed only by
+// the goroutine calling WriteQuorum.
+type quorumTarget struct {
+	chunks    chan []byte // data to write, closed when there is no more data
+	commit    bool        // set before chunks is closed, false when version should be aborted
+	stopped   bool        // chunks was closed
+	pending   int         // number of operations sent to the goroutine, but not acknowledged yet
+	committed bool
+	err       error // store is not used after it failed
+}
+
+type quorumResult struct {
+	target int
+	err    error
+	final  bool // result of committing or aborting the version, otherwise result of opening writer or writing chunk
+}
+
+// run writes to the store until chunks is closed. Result of each operation is sent to results.
+func (w *quorumWriter) run(i int, s codec.WriteOnlyStore, t time.Time) {
+	target := w.targets[i]
+	writer, err := s.Writer(store.WriteTime(t))
+	w.results <- quorumResult{target: i, err: err}
+	for chunk := range target.chunks {
+		if err == nil {
+			if _, err = writer.Write(chunk); err != nil {
+				writer.AbortAndClose()
+			}
+		}
+		w.results <- quorumResult{target: i, err: err}
+	}
+	if err == nil {
+		if target.commit {
+			err = writer.Close()
+		} else {
+			writer.AbortAndClose()
+			err = errors.New("aborted")
+		}
+	}
+	w.results <- quorumResult{target: i, err: err, final: true}
+}
+
+func (w *quorumWriter) Write(p []byte) (int, error) {
+	// stores falling behind write the chunk after Write returned, so p cannot be used
+	chunk := append([]byte(nil), p...)
+	for _, t := range w.targets {
+		if t.err != nil {
+			continue
+		}
+		if t.pending >= maxQuorumLag {
+			t.stop(false)
+			t.err = errors.New("store falls behind the quorum")
+			continue
+		}
+		t.pending++
+		t.chunks <- chunk
+	}
+	if err := w.await(acknowledged); err != nil {
+		return 0, err
+	}
+	w.size += int64(len(p))
+	return len(p), nil
+}
+
+// commit commits versions concurrently, because Close waits until data is synced to disk
+func (w *quorumWriter) commit() error {
+	for _, t := range w.targets {
+		if t.err == nil {
+			t.stop(true)
+		}
+	}
+	return w.await(func(t *quorumTarget) bool {
+		return t.committed
+	})
+}
+
+// abort aborts writing to all stores without waiting for them
+func (w *quorumWriter) abort() {
+	for _, t := range w.targets {
+		if t.err == nil {
+			t.stop(false)
+			t.err = errors.New("aborted")
+		}
+	}
+}
+
+// await receives results until quorum of stores is ready, or until too many of them failed
+func (w *quorumWriter) await(ready func(*quorumTarget) bool) error {
+	for {
+		readyTargets, healthyTargets := 0, 0
+		for _, t := range w.targets {
+			if t.err == nil {
+				healthyTargets++
+				if ready(t) {
+					readyTargets++
+				}
+			}
+		}
+		if readyTargets >= w.quorum {
+			return nil
+		}
+		if healthyTargets < w.quorum {
+			return w.quorumError()
+		}
+		w.receive(<-w.results)
+	}
+}
+
+func (w *quorumWriter) receive(r quorumResult) {
+	t := w.targets[r.target]
+	if r.final {
+		t.committed = r.err == nil
+	} else {
+		t.pending--
+	}
+	if r.err != nil && t.err == nil {
+		t.err = r.err
+	}
+}
+
+func acknowledged(t *quorumTarget) bool {
+	return t.pending == 0
+}
+
+func (t *quorumTarget) stop(commit bool) {
+	if t.stopped {
+		return
+	}
+	t.stopped = true
+	t.commit = commit
+	close(t.chunks)
+}
+
+func (w *quorumWriter) quorumError() error {
+	succeeded := 0
+	var failures []string
+	for i, t := range w.targets {
+		if t.err != nil {
+			failures = append(failures, fmt.Sprintf("store %d: %s", i, t.err))
+		} else {
+			succeeded++
+		}
+	}
+	return fmt.Errorf("quorum not reached, %d of %d required stores succeeded: %s",
+		succeeded, w.quorum, strings.Join(failures, ", "))
+}
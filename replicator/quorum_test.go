@@ -0,0 +1,182 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package replicator_test
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jacekolszak/deebee/codec"
+	"github.com/jacekolszak/deebee/faultstore"
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/replicator"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteQuorum(t *testing.T) {
+
+	t.Run("should return error for invalid arguments", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		_, err := replicator.WriteQuorum(nil, 1, s)
+		assert.Error(t, err)
+		_, err = replicator.WriteQuorum(encoder("data"), 1)
+		assert.Error(t, err)
+		_, err = replicator.WriteQuorum(encoder("data"), 1, s, nil)
+		assert.Error(t, err)
+		_, err = replicator.WriteQuorum(encoder("data"), 0, s)
+		assert.Error(t, err)
+		_, err = replicator.WriteQuorum(encoder("data"), 2, s)
+		assert.Error(t, err)
+	})
+
+	t.Run("should write version with the same time to all stores", func(t *testing.T) {
+		s1, s2, s3 := tests.OpenStore(t), tests.OpenStore(t), tests.OpenStore(t)
+		// when
+		version, err := replicator.WriteQuorum(encoder("data"), 3, s1, s2, s3)
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), version.Size)
+		for _, s := range []*store.Store{s1, s2, s3} {
+			assert.Equal(t, []byte("data"), tests.ReadData(t, s, store.Time(version.Time)))
+		}
+	})
+
+	t.Run("should succeed when quorum of stores committed the version", func(t *testing.T) {
+		s1, s2 := tests.OpenStore(t), tests.OpenStore(t)
+		failing, err := faultstore.Wrap(tests.OpenStore(t), faultstore.FailNthWrite(1))
+		require.NoError(t, err)
+		// when
+		version, err := replicator.WriteQuorum(encoder("data"), 2, failing, s1, s2)
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, []byte("data"), tests.ReadData(t, s1, store.Time(version.Time)))
+		versions, err := failing.Versions()
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+
+	t.Run("should fail when quorum was not reached", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		failing, err := faultstore.Wrap(tests.OpenStore(t), faultstore.NoSpaceLeft(2))
+		require.NoError(t, err)
+		// when
+		_, err = replicator.WriteQuorum(encoder("data"), 2, s, failing)
+		// then
+		assert.Error(t, err)
+	})
+
+	t.Run("should fail when quorum was not reached on commit", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		crashing, err := faultstore.Wrap(tests.OpenStore(t), faultstore.CrashBeforeChecksum)
+		require.NoError(t, err)
+		// when
+		_, err = replicator.WriteQuorum(encoder("data"), 2, s, crashing)
+		// then
+		assert.Error(t, err)
+	})
+
+	t.Run("should abort all writers when encoder failed", func(t *testing.T) {
+		s1, s2 := tests.OpenStore(t), tests.OpenStore(t)
+		failingEncoder := func(io.Writer) error {
+			return errors.New("encoder failed")
+		}
+		// when
+		_, err := replicator.WriteQuorum(failingEncoder, 1, s1, s2)
+		// then
+		assert.Error(t, err)
+		assert.True(t, numberOfVersions(s1, 0)())
+		assert.True(t, numberOfVersions(s2, 0)())
+	})
+
+	t.Run("should fail when writer cannot be opened in quorum of stores", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		readOnly := &tests.StoreMock{ReturnWriterError: errors.New("read-only")}
+		// when
+		_, err := replicator.WriteQuorum(encoder("data"), 2, s, readOnly)
+		// then
+		assert.Error(t, err)
+		assert.True(t, numberOfVersions(s, 0)())
+	})
+
+	t.Run("should not wait for hung store when quorum was reached", func(t *testing.T) {
+		s1, s2 := tests.OpenStore(t), tests.OpenStore(t)
+		hung := &hungStore{Store: tests.OpenStore(t), release: make(chan struct{})}
+		defer close(hung.release)
+		var (
+			version store.Version
+			err     error
+		)
+		// when
+		async := tests.RunAsync(func() {
+			version, err = replicator.WriteQuorum(encoder("data"), 2, hung, s1, s2)
+		})
+		// then
+		async.WaitOrFailAfter(t, time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("data"), tests.ReadData(t, s1, store.Time(version.Time)))
+		assert.Equal(t, []byte("data"), tests.ReadData(t, s2, store.Time(version.Time)))
+	})
+
+	t.Run("should write many chunks while store is hung", func(t *testing.T) {
+		s1, s2 := tests.OpenStore(t), tests.OpenStore(t)
+		hung := &hungStore{Store: tests.OpenStore(t), release: make(chan struct{})}
+		defer close(hung.release)
+		chunks := func(writer io.Writer) error {
+			for i := 0; i < 1000; i++ {
+				if _, err := writer.Write([]byte("chunk")); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		var (
+			version store.Version
+			err     error
+		)
+		// when
+		async := tests.RunAsync(func() {
+			version, err = replicator.WriteQuorum(chunks, 2, s1, hung, s2)
+		})
+		// then
+		async.WaitOrFailAfter(t, time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, int64(5000), version.Size)
+		assert.Len(t, tests.ReadData(t, s2, store.Time(version.Time)), 5000)
+	})
+}
+
+// hungStore blocks writing until release is closed
+type hungStore struct {
+	*store.Store
+	release chan struct{}
+}
+
+func (s *hungStore) Writer(options ...store.WriterOption) (store.Writer, error) {
+	writer, err := s.Store.Writer(options...)
+	if err != nil {
+		return nil, err
+	}
+	return &hungWriter{Writer: writer, release: s.release}, nil
+}
+
+type hungWriter struct {
+	store.Writer
+	release chan struct{}
+}
+
+func (w *hungWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return w.Writer.Write(p)
+}
+
+func encoder(data string) codec.Encoder {
+	return func(writer io.Writer) error {
+		_, err := writer.Write([]byte(data))
+		return err
+	}
+}
@@ -11,11 +11,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/jacekolszak/deebee/codec"
 	"github.com/jacekolszak/deebee/store"
+	"github.com/jacekolszak/deebee/throttle"
 )
 
 func CopyFromTo(from codec.ReadOnlyStore, to codec.WriteOnlyStore) error {
@@ -25,16 +28,32 @@ func CopyFromTo(from codec.ReadOnlyStore, to codec.WriteOnlyStore) error {
 	if to == nil {
 		return errors.New("nil <to> store")
 	}
-	return copyLatest(from, to)
+	_, err := copyLatest(from, to)
+	return err
 }
 
-// StartFromTo replicates state asynchronously in one minute intervals
+// StartFromTo replicates state asynchronously in one minute intervals. Only the latest version is copied, unless
+// OnWrite option is used.
 func StartFromTo(ctx context.Context, from codec.ReadOnlyStore, to codec.WriteOnlyStore, options ...Option) error {
+	if to == nil {
+		return errors.New("nil <to> store")
+	}
+	return StartFromToAll(ctx, from, []codec.WriteOnlyStore{to}, options...)
+}
+
+// StartFromToAll replicates state asynchronously to all targets. Targets are replicated concurrently and
+// independently - a slow or failing target does not delay others. Emitted events contain index of the target.
+func StartFromToAll(ctx context.Context, from codec.ReadOnlyStore, targets []codec.WriteOnlyStore, options ...Option) error {
 	if from == nil {
 		return errors.New("nil <from> store")
 	}
-	if to == nil {
-		return errors.New("nil <to> store")
+	if len(targets) == 0 {
+		return errors.New("no <to> stores given")
+	}
+	for _, to := range targets {
+		if to == nil {
+			return errors.New("nil <to> store")
+		}
 	}
 
 	opts := &Options{
@@ -49,14 +68,94 @@ func StartFromTo(ctx context.Context, from codec.ReadOnlyStore, to codec.WriteOn
 		}
 	}
 
+	if opts.repair {
+		for _, to := range targets {
+			_, readable := to.(codec.ReadOnlyStore)
+			_, deletable := to.(deleter)
+			if !readable || !deletable {
+				return errors.New("<to> store must implement Reader, Versions and DeleteVersion methods required by RepairCorrupted option")
+			}
+		}
+	}
+
+	replicas := make([]*replica, len(targets))
+	for i, to := range targets {
+		replicas[i] = &replica{index: i, store: to}
+	}
+
+	var written <-chan store.Version // nil when OnWrite was not used
+	if opts.onWrite {
+		w, ok := from.(Watcher)
+		if !ok {
+			return errors.New("<from> store does not implement Watcher, which is required by OnWrite option")
+		}
+		written = w.Watch(ctx)
+	}
+
+	// each target is replicated in its own loop, so a slow or hung target does not delay others
+	notifications := make([]chan struct{}, len(replicas))
+	var wg sync.WaitGroup
+	for i, r := range replicas {
+		notifications[i] = make(chan struct{}, 1)
+		wg.Add(1)
+		go func(r *replica, notified <-chan struct{}) {
+			defer wg.Done()
+			opts.run(ctx, from, r, notified)
+		}(r, notifications[i])
+	}
+	if written != nil {
+		go notifyAll(ctx, written, notifications)
+	}
+	wg.Wait()
+	return nil
+}
+
+// notifyAll notifies each replica loop about written versions. Notification is dropped when replica was already
+// notified, because it replicates all versions written since the last run anyway.
+func notifyAll(ctx context.Context, written <-chan store.Version, notifications []chan struct{}) {
+	for {
+		select {
+		case _, ok := <-written:
+			if !ok {
+				return
+			}
+			for _, n := range notifications {
+				select {
+				case n <- struct{}{}:
+				default:
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// run replicates to a single replica in intervals and, in OnWrite mode, after versions were written
+func (o *Options) run(ctx context.Context, from codec.ReadOnlyStore, to *replica, notified <-chan struct{}) {
+	if o.onWrite {
+		// versions written before Watch was called would not be replicated until the next interval
+		o.replicateToWithPriority(ctx, from, to)
+	}
+
+	interval := time.NewTimer(o.interval)
+	defer interval.Stop()
+	var debounce <-chan time.Time // not nil when replication was scheduled after write
+
 	for {
 		select {
-		case <-time.After(opts.interval):
-			if err := CopyFromTo(from, to); err != nil && !store.IsVersionAlreadyExists(err) {
-				log.Printf("replicator.CopyFromTo failed: %s", err)
+		case <-interval.C:
+			o.replicateToWithPriority(ctx, from, to)
+			interval.Reset(o.interval)
+		case <-notified:
+			if debounce == nil {
+				debounce = time.After(o.debounce)
 			}
+		case <-debounce:
+			debounce = nil
+			o.replicateToWithPriority(ctx, from, to)
 		case <-ctx.Done():
-			return nil
+			return
 		}
 	}
 }
@@ -64,7 +163,16 @@ func StartFromTo(ctx context.Context, from codec.ReadOnlyStore, to codec.WriteOn
 type Option func(*Options) error
 
 type Options struct {
-	interval time.Duration
+	interval  time.Duration
+	listeners []func(Event)
+	trackers  []*Tracker
+	onWrite   bool
+	debounce  time.Duration
+	repair    bool
+	limiter   *throttle.Limiter
+	lowIO     bool
+
+	mutex sync.Mutex // makes sure that listeners are not called concurrently
 }
 
 func Interval(d time.Duration) Option {
@@ -74,25 +182,295 @@ func Interval(d time.Duration) Option {
 	}
 }
 
-func copyLatest(from codec.ReadOnlyStore, to codec.WriteOnlyStore) error {
-	reader, err := from.Reader()
+// Watcher is a store notifying about written versions, such as store.Store
+type Watcher interface {
+	Watch(ctx context.Context) <-chan store.Version
+}
+
+// OnWrite replicates each new version as soon as it is written to the <from> store, which must implement Watcher.
+// Versions written within debounce duration after the first one are replicated together, which limits the number of
+// replication runs under bursts of writes. Contrary to the default mode, all versions written since the last
+// replication are copied, oldest first, not only the latest one. The latest version is replicated also right
+// after StartFromTo was called.
+//
+// Replication is still run in intervals, so versions which failed to replicate are eventually retried. Version which
+// cannot be replicated, for example because it is corrupted in the <from> store, does not block replication of newer
+// versions.
+func OnWrite(debounce time.Duration) Option {
+	return func(o *Options) error {
+		if debounce < 0 {
+			return errors.New("negative debounce duration")
+		}
+		o.onWrite = true
+		o.debounce = debounce
+		return nil
+	}
+}
+
+// RepairCorrupted verifies copies of versions which already exist in the target store, by reading them and
+// comparing their checksums with the source. Each copy is read only once after replication was started, later
+// only checksums are compared. Corrupted copies are deleted and replicated again. Target stores must implement
+// Reader, Versions and DeleteVersion methods, like store.Store does.
+//
+// Without this option, the replicator only compares checksums, when both stores implement Checksum method,
+// and emits ReplicationFailed when they are different.
+var RepairCorrupted Option = func(o *Options) error {
+	o.repair = true
+	return nil
+}
+
+// Throttle limits the rate of reading data from the <from> store and reading copies verified by RepairCorrupted
+// option. The same limiter can be shared with other jobs to limit their total throughput.
+func Throttle(limiter *throttle.Limiter) Option {
+	return func(o *Options) error {
+		if limiter == nil {
+			return errors.New("nil limiter")
+		}
+		o.limiter = limiter
+		return nil
+	}
+}
+
+// LowIOPriority runs replication with idle I/O scheduling class. See throttle.LowIOPriority for details.
+var LowIOPriority Option = func(o *Options) error {
+	o.lowIO = true
+	return nil
+}
+
+// replica is a target store together with its replication state
+type replica struct {
+	index          int
+	store          codec.WriteOnlyStore
+	lastReplicated time.Time      // time of the last version replicated in OnWrite mode
+	verified       map[int64]bool // unix nano times of copies read by RepairCorrupted, which were not corrupted
+}
+
+func (o *Options) replicateToWithPriority(ctx context.Context, from codec.ReadOnlyStore, to *replica) {
+	if !o.lowIO {
+		o.replicateTo(ctx, from, to)
+		return
+	}
+	throttle.LowIOPriority(func() {
+		o.replicateTo(ctx, from, to)
+	})
+}
+
+// replicateTo copies the latest version, or in OnWrite mode all versions newer than the last replicated one.
+// When nothing was replicated yet, only the latest version is copied. Version which failed to replicate does not stop
+// replication of newer ones - for example corrupted source version would block the replication forever. Failed
+// version is retried in next runs only until a newer version is replicated.
+func (o *Options) replicateTo(ctx context.Context, from codec.ReadOnlyStore, to *replica) {
+	start := time.Now()
+	o.track(func(t *Tracker) { t.started(to.index, start) })
+	defer o.track(func(t *Tracker) { t.finished(to.index) })
+
+	versions, err := from.Versions()
+	if err != nil {
+		o.emitResult(to, store.Version{}, err, start, 0)
+		return
+	}
+	if len(versions) == 0 {
+		o.emitResult(to, store.Version{}, store.NewVersionNotFoundError("no versions found"), start, 0)
+		return
+	}
+	if !o.onWrite || to.lastReplicated.IsZero() {
+		versions = versions[len(versions)-1:]
+	}
+	existing := targetVersionTimes(to.store)
+	to.forgetDeletedVersions(existing)
+
+	latest := versions[len(versions)-1]
+	for _, version := range versions {
+		if o.onWrite && !version.Time.After(to.lastReplicated) {
+			continue
+		}
+		start = time.Now()
+		if existing[version.Time.UnixNano()] {
+			err = o.checkTargetCopy(ctx, from, to, version, start)
+		} else {
+			o.track(func(t *Tracker) { t.attempting(to.index, latest, version) })
+			var n int64
+			n, err = copyVersion(ctx, from, to.store, version.Time, o.limiter)
+			o.emitResult(to, version, err, start, n)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil && !store.IsVersionAlreadyExists(err) {
+			continue
+		}
+		to.lastReplicated = version.Time
+	}
+}
+
+// checkTargetCopy checks version which already exists in the target store without reading the source data,
+// if possible. Corrupted copy is replaced when RepairCorrupted option was used.
+func (o *Options) checkTargetCopy(ctx context.Context, from codec.ReadOnlyStore, to *replica, version store.Version, start time.Time) error {
+	err := o.verifyTargetCopy(ctx, from, to, version.Time)
+	if err == nil {
+		o.emitResult(to, version, store.NewVersionAlreadyExistsError("version already exists"), start, 0)
+		return nil
+	}
+	if !o.repair {
+		o.emitResult(to, version, err, start, 0)
+		return err
+	}
+
+	if err = to.store.(deleter).DeleteVersion(version.Time); err != nil && !store.IsVersionNotFound(err) {
+		err = fmt.Errorf("error deleting corrupted copy of version %s: %w", version.Time, err)
+		o.emitResult(to, version, err, start, 0)
+		return err
+	}
+	n, err := copyVersion(ctx, from, to.store, version.Time, o.limiter)
 	if err != nil {
+		o.emitResult(to, version, err, start, n)
 		return err
 	}
-	writer, err := to.Writer(store.WriteTime(reader.Version().Time))
+	o.emitSynchronized(ReplicationSucceeded{
+		Version:  version,
+		Duration: time.Since(start),
+		Target:   to.index,
+		Bytes:    n,
+		Repaired: true,
+	})
+	return nil
+}
+
+// verifyTargetCopy returns error when copy of the version in the target store has different checksum than
+// the source version. With RepairCorrupted option the copy is also read to make sure that the data matches
+// its checksum. Each copy is read only once, not in each replication run.
+func (o *Options) verifyTargetCopy(ctx context.Context, from codec.ReadOnlyStore, to *replica, t time.Time) error {
+	fromChecksums, ok1 := from.(checksummer)
+	toChecksums, ok2 := to.store.(checksummer)
+	if ok1 && ok2 {
+		expected, err1 := fromChecksums.Checksum(t)
+		actual, err2 := toChecksums.Checksum(t)
+		if err1 == nil && err2 == nil && expected.Comparable(actual) && !expected.Equal(actual) {
+			return fmt.Errorf("copy of version %s in target store has different checksum %s than the source %s",
+				t, actual, expected)
+		}
+	}
+
+	if !o.repair || to.verified[t.UnixNano()] {
+		return nil
+	}
+	reader, err := to.store.(codec.ReadOnlyStore).Reader(store.Time(t))
+	if err != nil {
+		return fmt.Errorf("error opening copy of version %s in target store: %w", t, err)
+	}
+	_, err = io.Copy(ioutil.Discard, limitReader(ctx, reader, o.limiter))
+	if closeErr := reader.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("copy of version %s in target store is corrupted: %w", t, err)
+	}
+	if to.verified == nil {
+		to.verified = map[int64]bool{}
+	}
+	to.verified[t.UnixNano()] = true
+	return nil
+}
+
+// forgetDeletedVersions removes versions which no longer exist in the target store from verified ones. Nothing is
+// removed when versions of the target store are not known.
+func (r *replica) forgetDeletedVersions(existing map[int64]bool) {
+	if existing == nil {
+		return
+	}
+	for t := range r.verified {
+		if !existing[t] {
+			delete(r.verified, t)
+		}
+	}
+}
+
+// targetVersionTimes returns times of versions existing in the target store. Empty map is returned when
+// versions cannot be listed - then existing versions are detected when Writer returns error.
+func targetVersionTimes(to codec.WriteOnlyStore) map[int64]bool {
+	l, ok := to.(codec.ReadOnlyStore)
+	if !ok {
+		return nil
+	}
+	versions, err := l.Versions()
+	if err != nil {
+		return nil
+	}
+	return versionTimes(versions)
+}
+
+type checksummer interface {
+	Checksum(time.Time) (store.VersionChecksum, error)
+}
+
+// emitResult emits event for a given result of replication. copied is the number of bytes copied.
+func (o *Options) emitResult(to *replica, version store.Version, err error, start time.Time, copied int64) {
+	switch {
+	case store.IsVersionAlreadyExists(err):
+		o.emitSynchronized(ReplicationSkipped{Version: version, Target: to.index})
+	case err != nil:
+		o.emitSynchronized(ReplicationFailed{Version: version, Err: err, Duration: time.Since(start), Target: to.index})
+		if len(o.listeners) == 0 {
+			log.Printf("replicator.CopyFromTo failed: %s", err)
+		}
+	default:
+		o.emitSynchronized(ReplicationSucceeded{Version: version, Duration: time.Since(start), Target: to.index, Bytes: copied})
+	}
+}
+
+// emitSynchronized emits event making sure that listeners are not called concurrently by replicas
+func (o *Options) emitSynchronized(e Event) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.emit(e)
+}
+
+// copyLatest returns copied version. Version is returned also on error, if the latest version was found.
+func copyLatest(from codec.ReadOnlyStore, to codec.WriteOnlyStore) (store.Version, error) {
+	reader, err := from.Reader()
+	if err != nil {
+		return store.Version{}, err
+	}
+	version := reader.Version()
+	_, err = copyReader(context.Background(), reader, to, nil)
+	return version, err
+}
+
+// copyVersion returns number of bytes copied. Reading is throttled when limiter is not nil.
+func copyVersion(ctx context.Context, from codec.ReadOnlyStore, to codec.WriteOnlyStore, t time.Time, limiter *throttle.Limiter) (int64, error) {
+	reader, err := from.Reader(store.Time(t))
+	if err != nil {
+		return 0, err
+	}
+	return copyReader(ctx, reader, to, limiter)
+}
+
+// copyReader copies data from reader to a new version with the same time. Reader is always closed. Number of
+// bytes copied is returned.
+func copyReader(ctx context.Context, reader store.Reader, to codec.WriteOnlyStore, limiter *throttle.Limiter) (int64, error) {
+	version := reader.Version()
+	writer, err := to.Writer(store.WriteTime(version.Time))
 	if err != nil {
 		_ = reader.Close()
-		return err
+		return 0, err
 	}
-	_, err = io.Copy(writer, reader)
+	n, err := io.Copy(writer, limitReader(ctx, reader, limiter))
 	if err != nil {
 		writer.AbortAndClose()
 		_ = reader.Close()
-		return err
+		return n, err
 	}
 	if err := reader.Close(); err != nil {
 		writer.AbortAndClose()
-		return err
+		return n, err
+	}
+	return n, writer.Close()
+}
+
+func limitReader(ctx context.Context, r io.Reader, limiter *throttle.Limiter) io.Reader {
+	if limiter == nil {
+		return r
 	}
-	return writer.Close()
+	return throttle.NewReader(ctx, r, limiter)
 }
@@ -7,12 +7,15 @@ import (
 	"context"
 	"errors"
 	"io"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/jacekolszak/deebee/codec"
 	"github.com/jacekolszak/deebee/internal/tests"
 	"github.com/jacekolszak/deebee/replicator"
 	"github.com/jacekolszak/deebee/store"
+	"github.com/jacekolszak/deebee/throttle"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -141,6 +144,343 @@ func TestStartFromTo(t *testing.T) {
 	})
 }
 
+func TestStartFromToAll(t *testing.T) {
+
+	t.Run("should return error for invalid arguments", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		ctx := context.Background()
+		assert.Error(t, replicator.StartFromToAll(ctx, nil, []codec.WriteOnlyStore{s}))
+		assert.Error(t, replicator.StartFromToAll(ctx, s, nil))
+		assert.Error(t, replicator.StartFromToAll(ctx, s, []codec.WriteOnlyStore{s, nil}))
+	})
+
+	t.Run("should replicate to all targets", func(t *testing.T) {
+		from, to1, to2 := tests.OpenStore(t), tests.OpenStore(t), tests.OpenStore(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		async := tests.RunAsync(func() {
+			_ = replicator.StartFromToAll(ctx, from, []codec.WriteOnlyStore{to1, to2},
+				replicator.Interval(time.Millisecond))
+		})
+		// when
+		tests.WriteData(t, from, []byte("data"))
+		// then
+		assert.Eventually(t, numberOfVersions(to1, 1), time.Second, time.Millisecond)
+		assert.Eventually(t, numberOfVersions(to2, 1), time.Second, time.Millisecond)
+		// cleanup
+		cancel()
+		async.WaitOrFailAfter(t, time.Second)
+	})
+
+	t.Run("should replicate to other targets when one target is hung", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		hung := &hungStore{Store: tests.OpenStore(t), release: make(chan struct{})}
+		ctx, cancel := context.WithCancel(context.Background())
+		async := tests.RunAsync(func() {
+			_ = replicator.StartFromToAll(ctx, from, []codec.WriteOnlyStore{hung, to},
+				replicator.Interval(time.Millisecond))
+		})
+		tests.WriteData(t, from, []byte("data"))
+		require.Eventually(t, numberOfVersions(to, 1), time.Second, time.Millisecond)
+		// when
+		tests.WriteData(t, from, []byte("new"))
+		// then
+		assert.Eventually(t, numberOfVersions(to, 2), time.Second, time.Millisecond)
+		// cleanup
+		cancel()
+		close(hung.release)
+		async.WaitOrFailAfter(t, time.Second)
+	})
+
+	t.Run("should report status of each target", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		tests.WriteData(t, from, []byte("data"))
+		failing := &tests.StoreMock{ReturnWriterError: errors.New("failed")}
+		recorder := &tests.EventRecorder{}
+		ctx, cancel := context.WithCancel(context.Background())
+		// when
+		async := tests.RunAsync(func() {
+			_ = replicator.StartFromToAll(ctx, from, []codec.WriteOnlyStore{failing, to},
+				replicator.Interval(time.Millisecond),
+				replicator.OnEvent(func(e replicator.Event) {
+					recorder.Record(e)
+				}))
+		})
+		// then
+		assert.Eventually(t, func() bool {
+			return len(recorder.Events()) >= 2
+		}, time.Second, time.Millisecond)
+		cancel()
+		async.WaitOrFailAfter(t, time.Second)
+		for _, e := range recorder.Events() {
+			switch e := e.(type) {
+			case replicator.ReplicationFailed:
+				assert.Equal(t, 0, e.Target)
+			case replicator.ReplicationSucceeded:
+				assert.Equal(t, 1, e.Target)
+			case replicator.ReplicationSkipped:
+				assert.Equal(t, 1, e.Target)
+			}
+		}
+	})
+}
+
+func TestOnWrite(t *testing.T) {
+
+	t.Run("should return error for negative debounce", func(t *testing.T) {
+		err := replicator.StartFromTo(context.Background(), tests.OpenStore(t), tests.OpenStore(t),
+			replicator.OnWrite(-1))
+		assert.Error(t, err)
+	})
+
+	t.Run("should return error when from store is not a Watcher", func(t *testing.T) {
+		from := &tests.StoreMock{}
+		err := replicator.StartFromTo(context.Background(), from, tests.OpenStore(t), replicator.OnWrite(0))
+		assert.Error(t, err)
+	})
+
+	t.Run("should replicate latest version on start", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		tests.WriteData(t, from, []byte("v1"))
+		v2 := tests.WriteData(t, from, []byte("v2"))
+		ctx, cancel := context.WithCancel(context.Background())
+		// when
+		async := tests.RunAsync(func() {
+			_ = replicator.StartFromTo(ctx, from, to, replicator.OnWrite(0), replicator.Interval(time.Hour))
+		})
+		// then
+		assert.Eventually(t, numberOfVersions(to, 1), time.Second, time.Millisecond)
+		assert.Equal(t, []byte("v2"), tests.ReadData(t, to, store.Time(v2.Time)))
+		// cleanup
+		cancel()
+		async.WaitOrFailAfter(t, time.Second)
+	})
+
+	t.Run("should replicate version immediately after write", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		tests.WriteData(t, from, []byte("v1"))
+		ctx, cancel := context.WithCancel(context.Background())
+		async := tests.RunAsync(func() {
+			_ = replicator.StartFromTo(ctx, from, to, replicator.OnWrite(0), replicator.Interval(time.Hour))
+		})
+		assert.Eventually(t, numberOfVersions(to, 1), time.Second, time.Millisecond)
+		// when
+		tests.WriteData(t, from, []byte("v2"))
+		// then
+		assert.Eventually(t, numberOfVersions(to, 2), time.Second, time.Millisecond)
+		// cleanup
+		cancel()
+		async.WaitOrFailAfter(t, time.Second)
+	})
+
+	t.Run("should replicate all versions written in a burst", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		tests.WriteData(t, from, []byte("v1"))
+		ctx, cancel := context.WithCancel(context.Background())
+		recorder := &tests.EventRecorder{}
+		async := tests.RunAsync(func() {
+			_ = replicator.StartFromTo(ctx, from, to,
+				replicator.OnWrite(50*time.Millisecond),
+				replicator.Interval(time.Hour),
+				replicator.OnEvent(func(e replicator.Event) {
+					recorder.Record(e)
+				}))
+		})
+		assert.Eventually(t, numberOfVersions(to, 1), time.Second, time.Millisecond)
+		// when
+		for i := 0; i < 3; i++ {
+			tests.WriteData(t, from, []byte("burst"))
+		}
+		// then
+		assert.Eventually(t, numberOfVersions(to, 4), time.Second, time.Millisecond)
+		fromVersions, err := from.Versions()
+		require.NoError(t, err)
+		toVersions, err := to.Versions()
+		require.NoError(t, err)
+		assert.Equal(t, fromVersions, toVersions)
+		assert.Eventually(t, func() bool {
+			return len(recorder.Events()) == 4
+		}, time.Second, time.Millisecond)
+		// cleanup
+		cancel()
+		async.WaitOrFailAfter(t, time.Second)
+	})
+
+	t.Run("should replicate versions newer than corrupted one", func(t *testing.T) {
+		from := &corruptedVersionStore{Store: tests.OpenStore(t)}
+		to := tests.OpenStore(t)
+		v1 := tests.WriteData(t, from.Store, []byte("v1"))
+		ctx, cancel := context.WithCancel(context.Background())
+		recorder := &tests.EventRecorder{}
+		async := tests.RunAsync(func() {
+			_ = replicator.StartFromTo(ctx, from, to,
+				replicator.OnWrite(50*time.Millisecond),
+				replicator.Interval(time.Hour),
+				replicator.OnEvent(recordEvent(recorder)))
+		})
+		assert.Eventually(t, numberOfVersions(to, 1), time.Second, time.Millisecond)
+		// when
+		v2 := tests.WriteData(t, from.Store, []byte("v2"))
+		from.corrupt(v2.Time)
+		v3 := tests.WriteData(t, from.Store, []byte("v3"))
+		// then
+		assert.Eventually(t, numberOfVersions(to, 2), time.Second, time.Millisecond)
+		toVersions, err := to.Versions()
+		require.NoError(t, err)
+		require.Len(t, toVersions, 2)
+		assert.True(t, v1.Time.Equal(toVersions[0].Time))
+		assert.True(t, v3.Time.Equal(toVersions[1].Time))
+		// and
+		cancel()
+		async.WaitOrFailAfter(t, time.Second)
+		var failed []replicator.ReplicationFailed
+		for _, e := range recorder.Events() {
+			if f, ok := e.(replicator.ReplicationFailed); ok {
+				failed = append(failed, f)
+			}
+		}
+		require.Len(t, failed, 1)
+		assert.True(t, v2.Time.Equal(failed[0].Version.Time))
+	})
+}
+
+func TestSkippingExistingVersions(t *testing.T) {
+
+	t.Run("should not read source version when target already has it", func(t *testing.T) {
+		from := &countingStore{Store: tests.OpenStore(t)}
+		to := tests.OpenStore(t)
+		tests.WriteData(t, from.Store, []byte("data"))
+		recorder := &tests.EventRecorder{}
+		// when
+		stop := startReplicationFrom(t, from, to, replicator.OnEvent(recordEvent(recorder)))
+		assert.Eventually(t, func() bool {
+			return len(recorder.Events()) >= 3
+		}, time.Second, time.Millisecond)
+		stop()
+		// then
+		assert.Equal(t, 1, from.ReaderCalls())
+		assert.IsType(t, replicator.ReplicationSkipped{}, recorder.Events()[2])
+	})
+
+	t.Run("should fail when target copy has different checksum", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		v := tests.WriteData(t, from, []byte("data"))
+		tests.WriteData(t, to, []byte("other"), store.WriteTime(v.Time))
+		recorder := &tests.EventRecorder{}
+		// when
+		stop := startReplication(t, from, to, replicator.OnEvent(recordEvent(recorder)))
+		assert.Eventually(t, func() bool {
+			return len(recorder.Events()) >= 1
+		}, time.Second, time.Millisecond)
+		stop()
+		// then
+		failed, ok := recorder.Events()[0].(replicator.ReplicationFailed)
+		require.True(t, ok, "ReplicationFailed expected")
+		assert.True(t, v.Time.Equal(failed.Version.Time))
+		assert.Equal(t, []byte("other"), tests.ReadData(t, to))
+	})
+}
+
+func TestRepairCorrupted(t *testing.T) {
+
+	t.Run("should return error when target store does not support deleting versions", func(t *testing.T) {
+		err := replicator.StartFromTo(context.Background(), tests.OpenStore(t), &tests.StoreMock{},
+			replicator.RepairCorrupted)
+		assert.Error(t, err)
+	})
+
+	t.Run("should replace corrupted copy", func(t *testing.T) {
+		from := tests.OpenStore(t)
+		toDir := tests.TempDir(t)
+		to, err := store.Open(toDir)
+		require.NoError(t, err)
+		v := tests.WriteData(t, from, []byte("data"))
+		tests.WriteData(t, to, []byte("data"), store.WriteTime(v.Time))
+		tests.CorruptFiles(t, toDir)
+		recorder := &tests.EventRecorder{}
+		// when
+		stop := startReplication(t, from, to, replicator.RepairCorrupted, replicator.OnEvent(recordEvent(recorder)))
+		assert.Eventually(t, func() bool {
+			return len(recorder.Events()) >= 2
+		}, time.Second, time.Millisecond)
+		stop()
+		// then
+		succeeded, ok := recorder.Events()[0].(replicator.ReplicationSucceeded)
+		require.True(t, ok, "ReplicationSucceeded expected")
+		assert.True(t, succeeded.Repaired)
+		assert.IsType(t, replicator.ReplicationSkipped{}, recorder.Events()[1])
+		assert.Equal(t, []byte("data"), tests.ReadData(t, to))
+	})
+
+	t.Run("should replace copy with different checksum", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		v := tests.WriteData(t, from, []byte("data"))
+		tests.WriteData(t, to, []byte("other"), store.WriteTime(v.Time))
+		recorder := &tests.EventRecorder{}
+		// when
+		stop := startReplication(t, from, to, replicator.RepairCorrupted, replicator.OnEvent(recordEvent(recorder)))
+		assert.Eventually(t, func() bool {
+			return len(recorder.Events()) >= 1
+		}, time.Second, time.Millisecond)
+		stop()
+		// then
+		succeeded, ok := recorder.Events()[0].(replicator.ReplicationSucceeded)
+		require.True(t, ok, "ReplicationSucceeded expected")
+		assert.True(t, succeeded.Repaired)
+		assert.Equal(t, []byte("data"), tests.ReadData(t, to))
+	})
+
+	t.Run("should read copy only once", func(t *testing.T) {
+		from := tests.OpenStore(t)
+		to := &countingStore{Store: tests.OpenStore(t)}
+		v := tests.WriteData(t, from, []byte("data"))
+		tests.WriteData(t, to.Store, []byte("data"), store.WriteTime(v.Time))
+		recorder := &tests.EventRecorder{}
+		ctx, cancel := context.WithCancel(context.Background())
+		async := tests.RunAsync(func() {
+			_ = replicator.StartFromTo(ctx, from, to,
+				replicator.RepairCorrupted,
+				replicator.Interval(time.Millisecond),
+				replicator.OnEvent(recordEvent(recorder)))
+		})
+		// when
+		assert.Eventually(t, func() bool {
+			return len(recorder.Events()) >= 3
+		}, time.Second, time.Millisecond)
+		cancel()
+		async.WaitOrFailAfter(t, time.Second)
+		// then
+		for _, e := range recorder.Events() {
+			assert.IsType(t, replicator.ReplicationSkipped{}, e)
+		}
+		assert.Equal(t, 1, to.ReaderCalls())
+	})
+}
+
+func TestThrottle(t *testing.T) {
+
+	t.Run("should return error for nil limiter", func(t *testing.T) {
+		err := replicator.StartFromTo(context.Background(), tests.OpenStore(t), tests.OpenStore(t),
+			replicator.Throttle(nil))
+		assert.Error(t, err)
+	})
+
+	t.Run("should replicate with limited throughput", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		data := make([]byte, 600)
+		tests.WriteData(t, from, data)
+		limiter, err := throttle.NewLimiter(10000, 100)
+		require.NoError(t, err)
+		start := time.Now()
+		// when
+		stop := startReplication(t, from, to, replicator.Throttle(limiter), replicator.LowIOPriority)
+		assert.Eventually(t, numberOfVersions(to, 1), time.Second, time.Millisecond)
+		stop()
+		// then
+		assert.GreaterOrEqual(t, int64(time.Since(start)), int64(40*time.Millisecond))
+		assert.Equal(t, data, tests.ReadData(t, to))
+	})
+}
+
 func TestReadLatest(t *testing.T) {
 	t.Run("should return error", func(t *testing.T) {
 		t.Run("when no store is given", func(t *testing.T) {
@@ -287,3 +627,66 @@ func numberOfVersions(s *store.Store, l int) func() bool {
 		return len(versions) == l
 	}
 }
+
+// countingStore counts Reader calls
+type countingStore struct {
+	*store.Store
+	mutex       sync.Mutex
+	readerCalls int
+}
+
+func (s *countingStore) Reader(options ...store.ReaderOption) (store.Reader, error) {
+	s.mutex.Lock()
+	s.readerCalls++
+	s.mutex.Unlock()
+	return s.Store.Reader(options...)
+}
+
+func (s *countingStore) ReaderCalls() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.readerCalls
+}
+
+// corruptedVersionStore fails reading of version passed to corrupt
+type corruptedVersionStore struct {
+	*store.Store
+	mutex     sync.Mutex
+	corrupted time.Time
+}
+
+func (s *corruptedVersionStore) corrupt(t time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.corrupted = t
+}
+
+func (s *corruptedVersionStore) Reader(options ...store.ReaderOption) (store.Reader, error) {
+	opts, err := store.ApplyReaderOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := s.Versions()
+	if err != nil {
+		return nil, err
+	}
+	version, err := opts.ChooseVersion(versions)
+	if err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if version.Time.Equal(s.corrupted) {
+		return &corruptedReader{version: version}, nil
+	}
+	return s.Store.Reader(options...)
+}
+
+type corruptedReader struct {
+	tests.ReaderFailingOnRead
+	version store.Version
+}
+
+func (r *corruptedReader) Version() store.Version {
+	return r.version
+}
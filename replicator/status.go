@@ -0,0 +1,210 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package replicator
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jacekolszak/deebee/store"
+)
+
+// Status is the replication status of a single target store
+type Status struct {
+	// Target is the index of the target store given to StartFromToAll, always 0 for StartFromTo
+	Target int
+	// LastSuccess is the time when version was copied to the target for the last time
+	LastSuccess time.Time
+	// SourceVersion is the time of the latest version of the source store seen by the replicator. Source store is
+	// checked each time replication is run, therefore new versions are noticed after the Interval at the latest.
+	SourceVersion time.Time
+	// TargetVersion is the time of the latest version which is known to exist in the target store
+	TargetVersion time.Time
+	// PendingVersion is the time of the oldest source version newer than TargetVersion, which replication was
+	// attempted for. It is zero when the target is up to date.
+	PendingVersion time.Time
+	// ConsecutiveFailures is reset to 0 after each successful or skipped replication
+	ConsecutiveFailures int
+	// FailingSince is the time when the first of ConsecutiveFailures started. It is zero when the last replication
+	// did not fail.
+	FailingSince time.Time
+	// RunningSince is the time when the replication, which is still in progress, started. It is zero when
+	// replication is not running.
+	RunningSince time.Time
+	// LastError is the error returned by the last failed replication. It is nil after success.
+	LastError error
+	// BytesTransferred is the total number of bytes copied to the target
+	BytesTransferred int64
+}
+
+// Lag returns how far behind the target store is, measured against the wall clock. It is the age of PendingVersion,
+// but not less than the duration of the replication in progress or the time since replication started failing.
+// Therefore, lag grows while replication is stalled, even when the target is hung or the source versions cannot
+// be listed. Zero is returned when the target is up to date.
+func (s Status) Lag() time.Duration {
+	var lag time.Duration
+	for _, since := range []time.Time{s.PendingVersion, s.RunningSince, s.FailingSince} {
+		if since.IsZero() {
+			continue
+		}
+		if d := time.Since(since); d > lag { // negative for version written by a host with clock running ahead
+			lag = d
+		}
+	}
+	return lag
+}
+
+// NewTracker returns a Tracker which must be passed to StartFromTo or StartFromToAll using Track option
+func NewTracker() *Tracker {
+	return &Tracker{}
+}
+
+// Tracker tracks the status of replication, for example for health checks:
+//
+//	tracker := replicator.NewTracker()
+//	go replicator.StartFromTo(ctx, from, to, replicator.Track(tracker))
+//	...
+//	if err := tracker.Check(5 * time.Minute); err != nil {
+//		// replication falls behind
+//	}
+//
+// It is safe for concurrent use by multiple goroutines.
+type Tracker struct {
+	mutex    sync.Mutex
+	statuses []Status
+}
+
+// Track updates tracker with each emitted event. Contrary to OnEvent, Track does not disable logging failures
+// using the standard log package.
+func Track(tracker *Tracker) Option {
+	return func(o *Options) error {
+		if tracker == nil {
+			return errors.New("nil tracker")
+		}
+		o.trackers = append(o.trackers, tracker)
+		return nil
+	}
+}
+
+// Status returns status of each target, ordered by target index. Targets for which replication was not run yet
+// have zero status.
+func (t *Tracker) Status() []Status {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	statuses := make([]Status, len(t.statuses))
+	copy(statuses, t.statuses)
+	return statuses
+}
+
+// Check returns error when any target falls behind the source store by more than maxLag. Targets which were not
+// replicated yet, because the source store is empty or replication was not run yet, are considered healthy.
+func (t *Tracker) Check(maxLag time.Duration) error {
+	for _, s := range t.Status() {
+		if lag := s.Lag(); lag > maxLag {
+			return fmt.Errorf("target %d falls behind by %s, consecutive failures: %d, last error: %v",
+				s.Target, lag, s.ConsecutiveFailures, s.LastError)
+		}
+	}
+	return nil
+}
+
+func (t *Tracker) record(e Event) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	switch e := e.(type) {
+	case ReplicationSucceeded:
+		s := t.status(e.Target)
+		s.observeSource(e.Version.Time)
+		s.observeTarget(e.Version.Time)
+		s.LastSuccess = time.Now()
+		s.ConsecutiveFailures = 0
+		s.FailingSince = time.Time{}
+		s.LastError = nil
+		s.BytesTransferred += e.Bytes
+	case ReplicationSkipped:
+		s := t.status(e.Target)
+		s.observeSource(e.Version.Time)
+		s.observeTarget(e.Version.Time)
+		s.ConsecutiveFailures = 0
+		s.FailingSince = time.Time{}
+		s.LastError = nil
+	case ReplicationFailed:
+		s := t.status(e.Target)
+		s.observeSource(e.Version.Time)
+		s.observePending(e.Version.Time)
+		if s.ConsecutiveFailures == 0 {
+			s.FailingSince = time.Now().Add(-e.Duration)
+		}
+		s.ConsecutiveFailures++
+		s.LastError = e.Err
+	}
+}
+
+// started records the start of replication run, which has not emitted any event yet
+func (t *Tracker) started(target int, start time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.status(target).RunningSince = start
+}
+
+// finished records the end of replication run
+func (t *Tracker) finished(target int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.status(target).RunningSince = time.Time{}
+}
+
+// attempting records the version which replication is about to copy, before the result is known. Thanks to that
+// the version is pending even when copying it never ends.
+func (t *Tracker) attempting(target int, source store.Version, pending store.Version) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	s := t.status(target)
+	s.observeSource(source.Time)
+	s.observePending(pending.Time)
+}
+
+// status returns status of target, which can be updated. Must be called with mutex locked.
+func (t *Tracker) status(target int) *Status {
+	for len(t.statuses) <= target {
+		t.statuses = append(t.statuses, Status{Target: len(t.statuses)})
+	}
+	return &t.statuses[target]
+}
+
+func (s *Status) observeSource(version time.Time) {
+	if version.After(s.SourceVersion) {
+		s.SourceVersion = version
+	}
+}
+
+func (s *Status) observeTarget(version time.Time) {
+	if version.After(s.TargetVersion) {
+		s.TargetVersion = version
+	}
+	if s.PendingVersion.IsZero() || s.PendingVersion.After(s.TargetVersion) {
+		return
+	}
+	// the next pending version is not known, the latest source version is the best approximation
+	s.PendingVersion = time.Time{}
+	s.observePending(s.SourceVersion)
+}
+
+// observePending observes version which was not replicated. Version is zero when failure happened before
+// the source version was found.
+func (s *Status) observePending(version time.Time) {
+	if version.IsZero() || !version.After(s.TargetVersion) {
+		return
+	}
+	if s.PendingVersion.IsZero() || version.Before(s.PendingVersion) {
+		s.PendingVersion = version
+	}
+}
@@ -0,0 +1,197 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package replicator_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jacekolszak/deebee/codec"
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/replicator"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrack(t *testing.T) {
+
+	t.Run("should return error for nil tracker", func(t *testing.T) {
+		err := replicator.StartFromTo(context.Background(), tests.OpenStore(t), tests.OpenStore(t),
+			replicator.Track(nil))
+		assert.Error(t, err)
+	})
+}
+
+func TestTracker_Status(t *testing.T) {
+
+	t.Run("should return empty status when replication was not run", func(t *testing.T) {
+		tracker := replicator.NewTracker()
+		assert.Empty(t, tracker.Status())
+		assert.NoError(t, tracker.Check(0))
+	})
+
+	t.Run("should return status of successful replication", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		v := tests.WriteData(t, from, []byte("data"))
+		tracker := replicator.NewTracker()
+		// when
+		stop := startReplication(t, from, to, replicator.Track(tracker))
+		assert.Eventually(t, numberOfVersions(to, 1), time.Second, time.Millisecond)
+		stop()
+		// then
+		statuses := tracker.Status()
+		require.Len(t, statuses, 1)
+		status := statuses[0]
+		assert.True(t, v.Time.Equal(status.SourceVersion))
+		assert.True(t, v.Time.Equal(status.TargetVersion))
+		assert.False(t, status.LastSuccess.IsZero())
+		assert.Equal(t, 0, status.ConsecutiveFailures)
+		assert.NoError(t, status.LastError)
+		assert.Equal(t, int64(4), status.BytesTransferred)
+		assert.Equal(t, time.Duration(0), status.Lag())
+		assert.NoError(t, tracker.Check(0))
+	})
+
+	t.Run("should report status of each target", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		tests.WriteData(t, from, []byte("old"), store.WriteTime(time.Now().Add(-time.Hour)))
+		require.NoError(t, replicator.CopyFromTo(from, to))
+		latest := tests.WriteData(t, from, []byte("latest"), store.WriteTime(time.Now().Add(-10*time.Minute)))
+		failing := &tests.StoreMock{ReturnWriterError: errors.New("failed")}
+		tracker := replicator.NewTracker()
+		ctx, cancel := context.WithCancel(context.Background())
+		async := tests.RunAsync(func() {
+			_ = replicator.StartFromToAll(ctx, from, []codec.WriteOnlyStore{failing, to},
+				replicator.Interval(time.Millisecond),
+				replicator.Track(tracker))
+		})
+		// when
+		assert.Eventually(t, func() bool {
+			statuses := tracker.Status()
+			return len(statuses) == 2 && statuses[0].ConsecutiveFailures >= 2 && !statuses[1].LastSuccess.IsZero()
+		}, time.Second, time.Millisecond)
+		cancel()
+		async.WaitOrFailAfter(t, time.Second)
+		// then
+		statuses := tracker.Status()
+		failed := statuses[0]
+		assert.Equal(t, 0, failed.Target)
+		assert.True(t, latest.Time.Equal(failed.SourceVersion))
+		assert.True(t, failed.TargetVersion.IsZero())
+		assert.True(t, latest.Time.Equal(failed.PendingVersion))
+		assert.Error(t, failed.LastError)
+		assert.True(t, failed.LastSuccess.IsZero())
+		succeeded := statuses[1]
+		assert.Equal(t, 1, succeeded.Target)
+		assert.True(t, latest.Time.Equal(succeeded.TargetVersion))
+		assert.Equal(t, time.Duration(0), succeeded.Lag())
+		// and
+		assert.Error(t, tracker.Check(time.Minute))
+	})
+}
+
+func TestStatus_Lag(t *testing.T) {
+
+	t.Run("should grow while replication to target is stalled", func(t *testing.T) {
+		from := tests.OpenStore(t)
+		to := &switchableStore{Store: tests.OpenStore(t)}
+		tests.WriteData(t, from, []byte("v1"), store.WriteTime(time.Now().Add(-11*time.Minute)))
+		tracker := replicator.NewTracker()
+		ctx, cancel := context.WithCancel(context.Background())
+		async := tests.RunAsync(func() {
+			_ = replicator.StartFromTo(ctx, from, to, replicator.Interval(time.Millisecond), replicator.Track(tracker))
+		})
+		assert.Eventually(t, func() bool {
+			statuses := tracker.Status()
+			return len(statuses) == 1 && !statuses[0].LastSuccess.IsZero()
+		}, time.Second, time.Millisecond)
+		// when
+		to.fail()
+		v2 := tests.WriteData(t, from, []byte("v2"), store.WriteTime(time.Now().Add(-10*time.Minute)))
+		assert.Eventually(t, func() bool {
+			s := tracker.Status()[0]
+			return s.ConsecutiveFailures > 0 && v2.Time.Equal(s.SourceVersion)
+		}, time.Second, time.Millisecond)
+		cancel()
+		async.WaitOrFailAfter(t, time.Second)
+		// then
+		status := tracker.Status()[0]
+		assert.GreaterOrEqual(t, int64(status.Lag()), int64(10*time.Minute))
+		assert.Error(t, tracker.Check(5*time.Minute))
+	})
+
+	t.Run("should grow while target is hung", func(t *testing.T) {
+		from := tests.OpenStore(t)
+		hung := &hungStore{Store: tests.OpenStore(t), release: make(chan struct{})}
+		v := tests.WriteData(t, from, []byte("data"), store.WriteTime(time.Now().Add(-10*time.Minute)))
+		tracker := replicator.NewTracker()
+		ctx, cancel := context.WithCancel(context.Background())
+		async := tests.RunAsync(func() {
+			_ = replicator.StartFromTo(ctx, from, hung, replicator.Interval(time.Millisecond),
+				replicator.Track(tracker))
+		})
+		// when
+		require.Eventually(t, func() bool {
+			statuses := tracker.Status()
+			return len(statuses) == 1 && !statuses[0].PendingVersion.IsZero()
+		}, time.Second, time.Millisecond)
+		// then
+		status := tracker.Status()[0]
+		assert.True(t, v.Time.Equal(status.PendingVersion))
+		assert.False(t, status.RunningSince.IsZero())
+		assert.GreaterOrEqual(t, int64(status.Lag()), int64(10*time.Minute))
+		assert.Error(t, tracker.Check(5*time.Minute))
+		// cleanup
+		cancel()
+		close(hung.release)
+		async.WaitOrFailAfter(t, time.Second)
+	})
+
+	t.Run("should grow while source versions cannot be listed", func(t *testing.T) {
+		from := &tests.StoreMock{ReturnVersionsError: errors.New("failed")}
+		tracker := replicator.NewTracker()
+		ctx, cancel := context.WithCancel(context.Background())
+		async := tests.RunAsync(func() {
+			_ = replicator.StartFromTo(ctx, from, tests.OpenStore(t), replicator.Interval(time.Millisecond),
+				replicator.Track(tracker))
+		})
+		// when
+		assert.Eventually(t, func() bool {
+			return tracker.Check(50*time.Millisecond) != nil
+		}, time.Second, time.Millisecond)
+		cancel()
+		async.WaitOrFailAfter(t, time.Second)
+		// then
+		status := tracker.Status()[0]
+		assert.True(t, status.PendingVersion.IsZero())
+		assert.False(t, status.FailingSince.IsZero())
+		assert.GreaterOrEqual(t, int64(status.Lag()), int64(50*time.Millisecond))
+	})
+
+	t.Run("should return 0 when target is up to date", func(t *testing.T) {
+		status := replicator.Status{SourceVersion: time.Now(), TargetVersion: time.Now()}
+		assert.Equal(t, time.Duration(0), status.Lag())
+	})
+}
+
+// switchableStore starts failing writes after fail was called
+type switchableStore struct {
+	*store.Store
+	failing int32
+}
+
+func (s *switchableStore) fail() {
+	atomic.StoreInt32(&s.failing, 1)
+}
+
+func (s *switchableStore) Writer(options ...store.WriterOption) (store.Writer, error) {
+	if atomic.LoadInt32(&s.failing) == 1 {
+		return nil, errors.New("failed")
+	}
+	return s.Store.Writer(options...)
+}
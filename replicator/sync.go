@@ -0,0 +1,161 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package replicator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jacekolszak/deebee/codec"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/jacekolszak/deebee/throttle"
+)
+
+// Sync copies all versions missing in the <to> store, oldest first, preserving their time. Contrary to CopyFromTo,
+// the whole history is replicated, so it can be used to catch up a target which was offline for some time.
+//
+// Sync stops on first error and returns the report of versions transferred so far. Sync compares versions
+// of both stores each time it is called, so calling it again resumes the replication.
+func Sync(from codec.ReadOnlyStore, to SyncTarget, options ...SyncOption) (SyncReport, error) {
+	if from == nil {
+		return SyncReport{}, errors.New("nil <from> store")
+	}
+	if to == nil {
+		return SyncReport{}, errors.New("nil <to> store")
+	}
+
+	opts := &SyncOptions{ctx: context.Background()}
+	for _, apply := range options {
+		if apply == nil {
+			continue
+		}
+		if err := apply(opts); err != nil {
+			return SyncReport{}, fmt.Errorf("error applying option: %w", err)
+		}
+	}
+
+	var d deleter
+	if opts.mirrorDeletions {
+		var ok bool
+		if d, ok = to.(deleter); !ok {
+			return SyncReport{}, errors.New("<to> store does not support deleting versions required by MirrorDeletions")
+		}
+	}
+
+	fromVersions, err := from.Versions()
+	if err != nil {
+		return SyncReport{}, fmt.Errorf("error getting versions of <from> store: %w", err)
+	}
+	toVersions, err := to.Versions()
+	if err != nil {
+		return SyncReport{}, fmt.Errorf("error getting versions of <to> store: %w", err)
+	}
+
+	report := SyncReport{}
+	existing := versionTimes(toVersions)
+	for _, v := range fromVersions {
+		if existing[v.Time.UnixNano()] {
+			continue
+		}
+		if err = opts.ctx.Err(); err != nil {
+			return report, err
+		}
+		_, err = copyVersion(opts.ctx, from, to, v.Time, opts.limiter)
+		if store.IsVersionAlreadyExists(err) {
+			continue // written concurrently by someone else
+		}
+		if err != nil {
+			return report, fmt.Errorf("error copying version %s: %w", v.Time, err)
+		}
+		report.Copied = append(report.Copied, v)
+	}
+
+	// empty source most likely means misconfiguration, therefore nothing is deleted
+	if d == nil || len(fromVersions) == 0 {
+		return report, nil
+	}
+	source := versionTimes(fromVersions)
+	for _, v := range toVersions {
+		if source[v.Time.UnixNano()] {
+			continue
+		}
+		err = d.DeleteVersion(v.Time)
+		if store.IsVersionNotFound(err) {
+			continue // deleted concurrently by someone else
+		}
+		if err != nil {
+			return report, fmt.Errorf("error deleting version %s: %w", v.Time, err)
+		}
+		report.Deleted = append(report.Deleted, v)
+	}
+	return report, nil
+}
+
+// SyncTarget is a store to which versions are synchronized. It is implemented by store.Store and other stores.
+type SyncTarget interface {
+	codec.WriteOnlyStore
+	Versions() ([]store.Version, error)
+}
+
+// SyncReport is a result of Sync
+type SyncReport struct {
+	// Copied contains versions copied to the <to> store, oldest first
+	Copied []store.Version
+	// Deleted contains versions deleted from the <to> store, oldest first. Only when MirrorDeletions was used.
+	Deleted []store.Version
+}
+
+type SyncOption func(*SyncOptions) error
+
+type SyncOptions struct {
+	ctx             context.Context
+	mirrorDeletions bool
+	limiter         *throttle.Limiter
+}
+
+// MirrorDeletions deletes versions from the <to> store which do not exist in the <from> store, for example because
+// they were deleted by the compacter. The <to> store must implement DeleteVersion method. Nothing is deleted when
+// the <from> store is empty.
+var MirrorDeletions SyncOption = func(o *SyncOptions) error {
+	o.mirrorDeletions = true
+	return nil
+}
+
+// SyncThrottle limits the rate of reading data from the <from> store. It is not named Throttle, because
+// that name is already used by Option.
+func SyncThrottle(limiter *throttle.Limiter) SyncOption {
+	return func(o *SyncOptions) error {
+		if limiter == nil {
+			return errors.New("nil limiter")
+		}
+		o.limiter = limiter
+		return nil
+	}
+}
+
+// SyncContext stops Sync when ctx is done. Version being copied is interrupted only when SyncThrottle was used,
+// otherwise Sync stops before copying the next version.
+func SyncContext(ctx context.Context) SyncOption {
+	return func(o *SyncOptions) error {
+		if ctx == nil {
+			return errors.New("nil context")
+		}
+		o.ctx = ctx
+		return nil
+	}
+}
+
+type deleter interface {
+	DeleteVersion(time.Time) error
+}
+
+func versionTimes(versions []store.Version) map[int64]bool {
+	times := make(map[int64]bool, len(versions))
+	for _, v := range versions {
+		times[v.Time.UnixNano()] = true
+	}
+	return times
+}
@@ -0,0 +1,227 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package replicator_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jacekolszak/deebee/faultstore"
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/replicator"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/jacekolszak/deebee/throttle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSync(t *testing.T) {
+
+	t.Run("should return error when from is nil", func(t *testing.T) {
+		_, err := replicator.Sync(nil, tests.OpenStore(t))
+		assert.Error(t, err)
+	})
+
+	t.Run("should return error when to is nil", func(t *testing.T) {
+		_, err := replicator.Sync(tests.OpenStore(t), nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("should return error when option returned error", func(t *testing.T) {
+		option := func(*replicator.SyncOptions) error {
+			return errors.New("error")
+		}
+		_, err := replicator.Sync(tests.OpenStore(t), tests.OpenStore(t), option)
+		assert.Error(t, err)
+	})
+
+	t.Run("should do nothing when from store is empty", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		report, err := replicator.Sync(from, to)
+		require.NoError(t, err)
+		assert.Empty(t, report.Copied)
+	})
+
+	t.Run("should copy all versions preserving the time", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		v1 := tests.WriteData(t, from, []byte("v1"))
+		v2 := tests.WriteData(t, from, []byte("v2"))
+		// when
+		report, err := replicator.Sync(from, to)
+		// then
+		require.NoError(t, err)
+		assertVersionsEqual(t, []store.Version{v1, v2}, report.Copied)
+		assert.Equal(t, []byte("v1"), tests.ReadData(t, to, store.Time(v1.Time)))
+		assert.Equal(t, []byte("v2"), tests.ReadData(t, to, store.Time(v2.Time)))
+	})
+
+	t.Run("should copy only missing versions", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		v1 := tests.WriteData(t, from, []byte("v1"))
+		tests.WriteData(t, to, []byte("v1"), store.WriteTime(v1.Time))
+		v2 := tests.WriteData(t, from, []byte("v2"))
+		// when
+		report, err := replicator.Sync(from, to)
+		// then
+		require.NoError(t, err)
+		assertVersionsEqual(t, []store.Version{v2}, report.Copied)
+	})
+
+	t.Run("should not delete versions by default", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		tests.WriteData(t, from, []byte("v1"))
+		tests.WriteData(t, to, []byte("other"))
+		// when
+		report, err := replicator.Sync(from, to)
+		// then
+		require.NoError(t, err)
+		assert.Empty(t, report.Deleted)
+		assert.True(t, numberOfVersions(to, 2)())
+	})
+
+	t.Run("should resume after failure", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		v1 := tests.WriteData(t, from, []byte("v1"))
+		v2 := tests.WriteData(t, from, []byte("v2"))
+		v3 := tests.WriteData(t, from, []byte("v3"))
+		faultyTo, err := faultstore.Wrap(to, faultstore.FailNthWrite(2))
+		require.NoError(t, err)
+		// when
+		report, err := replicator.Sync(from, faultyTo)
+		// then
+		assert.ErrorIs(t, err, faultstore.ErrInjected)
+		assertVersionsEqual(t, []store.Version{v1}, report.Copied)
+		// and when
+		report, err = replicator.Sync(from, faultyTo)
+		// then
+		require.NoError(t, err)
+		assertVersionsEqual(t, []store.Version{v2, v3}, report.Copied)
+	})
+}
+
+func TestMirrorDeletions(t *testing.T) {
+
+	t.Run("should delete versions which do not exist in from store", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		v1 := tests.WriteData(t, from, []byte("v1"))
+		v2 := tests.WriteData(t, from, []byte("v2"))
+		_, err := replicator.Sync(from, to)
+		require.NoError(t, err)
+		require.NoError(t, from.DeleteVersion(v1.Time))
+		// when
+		report, err := replicator.Sync(from, to, replicator.MirrorDeletions)
+		// then
+		require.NoError(t, err)
+		assert.Empty(t, report.Copied)
+		assertVersionsEqual(t, []store.Version{v1}, report.Deleted)
+		versions, err := to.Versions()
+		require.NoError(t, err)
+		assertVersionsEqual(t, []store.Version{v2}, versions)
+	})
+
+	t.Run("should not delete anything when from store is empty", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		tests.WriteData(t, to, []byte("data"))
+		// when
+		report, err := replicator.Sync(from, to, replicator.MirrorDeletions)
+		// then
+		require.NoError(t, err)
+		assert.Empty(t, report.Deleted)
+		assert.True(t, numberOfVersions(to, 1)())
+	})
+
+	t.Run("should return error when to store does not support deleting versions", func(t *testing.T) {
+		from := tests.OpenStore(t)
+		to := &tests.StoreMock{}
+		// when
+		_, err := replicator.Sync(from, to, replicator.MirrorDeletions)
+		// then
+		assert.Error(t, err)
+	})
+
+	t.Run("should delete versions older than copied ones", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		old := tests.WriteData(t, to, []byte("old"), store.WriteTime(time.Unix(1, 0)))
+		v := tests.WriteData(t, from, []byte("new"))
+		// when
+		report, err := replicator.Sync(from, to, replicator.MirrorDeletions)
+		// then
+		require.NoError(t, err)
+		assertVersionsEqual(t, []store.Version{v}, report.Copied)
+		assertVersionsEqual(t, []store.Version{old}, report.Deleted)
+	})
+}
+
+func TestSyncThrottle(t *testing.T) {
+
+	t.Run("should return error for nil limiter", func(t *testing.T) {
+		_, err := replicator.Sync(tests.OpenStore(t), tests.OpenStore(t), replicator.SyncThrottle(nil))
+		assert.Error(t, err)
+	})
+
+	t.Run("should copy versions with limited throughput", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		v1 := tests.WriteData(t, from, make([]byte, 300))
+		v2 := tests.WriteData(t, from, make([]byte, 300))
+		limiter, err := throttle.NewLimiter(10000, 100)
+		require.NoError(t, err)
+		start := time.Now()
+		// when
+		report, err := replicator.Sync(from, to, replicator.SyncThrottle(limiter))
+		// then
+		require.NoError(t, err)
+		assertVersionsEqual(t, []store.Version{v1, v2}, report.Copied)
+		assert.GreaterOrEqual(t, int64(time.Since(start)), int64(40*time.Millisecond))
+	})
+}
+
+func TestSyncContext(t *testing.T) {
+
+	t.Run("should return error for nil context", func(t *testing.T) {
+		_, err := replicator.Sync(tests.OpenStore(t), tests.OpenStore(t), replicator.SyncContext(nil))
+		assert.Error(t, err)
+	})
+
+	t.Run("should not copy versions when context is cancelled", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		tests.WriteData(t, from, []byte("data"))
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		// when
+		report, err := replicator.Sync(from, to, replicator.SyncContext(ctx))
+		// then
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Empty(t, report.Copied)
+		versions, err := to.Versions()
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+
+	t.Run("should interrupt throttled copying once context is cancelled", func(t *testing.T) {
+		from, to := tests.OpenStore(t), tests.OpenStore(t)
+		tests.WriteData(t, from, make([]byte, 1000))
+		limiter, err := throttle.NewLimiter(1, 1)
+		require.NoError(t, err)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		var report replicator.SyncReport
+		async := tests.RunAsync(func() {
+			report, err = replicator.Sync(from, to, replicator.SyncContext(ctx), replicator.SyncThrottle(limiter))
+		})
+		// then
+		async.WaitOrFailAfter(t, time.Second)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Empty(t, report.Copied)
+	})
+}
+
+func assertVersionsEqual(t *testing.T, expected, actual []store.Version) {
+	require.Len(t, actual, len(expected))
+	for i, v := range expected {
+		assert.True(t, v.Time.Equal(actual[i].Time), "times not equal")
+		assert.Equal(t, v.Size, actual[i].Size)
+	}
+}
@@ -0,0 +1,68 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package s3store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jacekolszak/deebee/store"
+)
+
+// Cleanup removes objects left by interrupted writes: data objects without checksum objects and checksum objects
+// without data objects. Such objects are not visible as versions. Only objects older than grace period are removed,
+// because younger ones might belong to a write still in progress. Cleanup can be run periodically by compacter using
+// compacter.Cleanup option.
+//
+// Multipart uploads interrupted by a crash are not objects, therefore they are not removed. Use bucket lifecycle rule
+// with AbortIncompleteMultipartUpload action to remove them.
+func (s *Store) Cleanup(options ...store.CleanupOption) (store.CleanupReport, error) {
+	opts, err := store.ApplyCleanupOptions(options)
+	if err != nil {
+		return store.CleanupReport{}, err
+	}
+
+	objects, err := s.client.listObjects(s.prefix)
+	if err != nil {
+		return store.CleanupReport{}, fmt.Errorf("error listing objects with prefix %s: %w", s.prefix, err)
+	}
+	names := map[string]struct{}{}
+	for _, o := range objects {
+		names[strings.TrimPrefix(o.Key, s.prefix)] = struct{}{}
+	}
+
+	report := store.CleanupReport{}
+	olderThan := time.Now().Add(-opts.GracePeriod())
+	for _, o := range objects {
+		if !o.LastModified.Before(olderThan) || !isIncompleteWrite(strings.TrimPrefix(o.Key, s.prefix), names) {
+			continue
+		}
+		if err = s.client.deleteObject(o.Key); err != nil && !isNotFound(err) {
+			return report, fmt.Errorf("error deleting object %s: %w", o.Key, err)
+		}
+		report.Removed = append(report.Removed, o.Key)
+	}
+
+	opts.Report(report)
+	return report, nil
+}
+
+// isIncompleteWrite returns true for objects which will never become part of a version. Names are keys without
+// the prefix.
+func isIncompleteWrite(name string, names map[string]struct{}) bool {
+	if _, err := store.ParseDataFileName(name); err == nil {
+		_, hasChecksum := names[store.ChecksumFileName(name)]
+		return !hasChecksum
+	}
+	dataName, ok := store.DataFileNameOf(name)
+	if !ok {
+		return false
+	}
+	if _, err := store.ParseDataFileName(dataName); err != nil {
+		return false // object not written by the store
+	}
+	_, hasData := names[dataName]
+	return !hasData
+}
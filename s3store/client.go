@@ -0,0 +1,257 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package s3store
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// client is a minimal client of S3 API, implementing only operations needed by Store. Path-style requests are used
+// (http://endpoint/bucket/key), because they are supported by all S3-compatible servers.
+type client struct {
+	endpoint    *url.URL
+	bucket      string
+	region      string
+	credentials Credentials
+	httpClient  *http.Client
+	now         func() time.Time
+}
+
+// s3Error is an error returned by S3 API
+type s3Error struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("S3 request failed with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("S3 request failed with status %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+func isNotFound(err error) bool {
+	var e *s3Error
+	return errors.As(err, &e) && e.StatusCode == http.StatusNotFound
+}
+
+func (c *client) objectURL(key string, query url.Values) *url.URL {
+	u := *c.endpoint
+	p := strings.TrimSuffix(u.Path, "/") + "/" + c.bucket
+	if key != "" {
+		p += "/" + key
+	}
+	u.Path = p
+	u.RawPath = strings.TrimSuffix(c.endpoint.EscapedPath(), "/") + "/" + uriEncode(c.bucket, true)
+	if key != "" {
+		u.RawPath += "/" + uriEncode(key, false)
+	}
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// do sends signed request. body can be nil. Response body must be closed by the caller when error is nil.
+func (c *client) do(method, key string, query url.Values, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.objectURL(key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	if body == nil {
+		req.Body = http.NoBody
+	}
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		payloadHash = hexSHA256(body)
+	}
+	sign(req, c.credentials, c.region, payloadHash, c.now())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, readError(resp)
+	}
+	return resp, nil
+}
+
+func readError(resp *http.Response) error {
+	e := &s3Error{}
+	content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	_ = xml.Unmarshal(content, e)
+	e.StatusCode = resp.StatusCode
+	return e
+}
+
+// doXML sends request and decodes XML response into out
+func (c *client) doXML(method, key string, query url.Values, body []byte, out interface{}) error {
+	resp, err := c.do(method, key, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	// CompleteMultipartUpload may return error with 200 status code
+	if bytes.Contains(content[:min(len(content), 128)], []byte("<Error>")) {
+		e := &s3Error{StatusCode: resp.StatusCode}
+		_ = xml.Unmarshal(content, e)
+		return e
+	}
+	if out == nil {
+		return nil
+	}
+	return xml.Unmarshal(content, out)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+type object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	Size         int64     `xml:"Size"`
+}
+
+type listBucketResult struct {
+	Contents              []object `xml:"Contents"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken"`
+}
+
+// listObjects returns all objects directly under prefix, following pagination
+func (c *client) listObjects(prefix string) ([]object, error) {
+	var objects []object
+	token := ""
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"prefix":    {prefix},
+			"delimiter": {"/"},
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		result := listBucketResult{}
+		if err := c.doXML(http.MethodGet, "", query, nil, &result); err != nil {
+			return nil, err
+		}
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (c *client) putObject(key string, body []byte) error {
+	resp, err := c.do(http.MethodPut, key, nil, body)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// getObject returns object content. Returned ReadCloser must be closed.
+func (c *client) getObject(key string) (io.ReadCloser, error) {
+	resp, err := c.do(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *client) headObject(key string) error {
+	resp, err := c.do(http.MethodHead, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (c *client) deleteObject(key string) error {
+	resp, err := c.do(http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+func (c *client) createMultipartUpload(key string) (string, error) {
+	result := initiateMultipartUploadResult{}
+	if err := c.doXML(http.MethodPost, key, url.Values{"uploads": {""}}, nil, &result); err != nil {
+		return "", err
+	}
+	if result.UploadID == "" {
+		return "", fmt.Errorf("no upload id returned for object %s", key)
+	}
+	return result.UploadID, nil
+}
+
+// uploadPart returns ETag of uploaded part
+func (c *client) uploadPart(key, uploadID string, partNumber int, body []byte) (string, error) {
+	query := url.Values{
+		"partNumber": {fmt.Sprint(partNumber)},
+		"uploadId":   {uploadID},
+	}
+	resp, err := c.do(http.MethodPut, key, query, body)
+	if err != nil {
+		return "", err
+	}
+	_ = resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return "", fmt.Errorf("no ETag returned for part %d of object %s", partNumber, key)
+	}
+	return etag, nil
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+func (c *client) completeMultipartUpload(key, uploadID string, parts []completedPart) error {
+	body, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		return err
+	}
+	return c.doXML(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, body, nil)
+}
+
+func (c *client) abortMultipartUpload(key, uploadID string) error {
+	resp, err := c.do(http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,130 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package s3store
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/jacekolszak/deebee/store"
+)
+
+func (s *Store) Reader(options ...store.ReaderOption) (store.Reader, error) {
+	opts, err := store.ApplyReaderOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := s.Versions()
+	if err != nil {
+		return nil, err
+	}
+	version, err := opts.ChooseVersion(versions)
+	if err != nil {
+		return nil, err
+	}
+
+	key := s.dataKey(version.Time)
+	checksum, err := s.readChecksum(version.Time)
+	if err != nil {
+		return nil, err
+	}
+	if checksum.Compression != "" {
+		return nil, fmt.Errorf("compressed version %s is not supported", version.Time)
+	}
+	algorithm, ok := s.checksumAlgorithms[checksum.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unknown checksum algorithm %s used for version %s", checksum.Algorithm, version.Time)
+	}
+	body, err := s.client.getObject(key)
+	if isNotFound(err) {
+		return nil, store.NewVersionNotFoundErrorWithCause(fmt.Sprintf("version %s not found", version.Time), err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting object %s: %w", key, err)
+	}
+	return &reader{
+		body:     body,
+		key:      key,
+		version:  version,
+		checksum: algorithm.NewHash(),
+		expected: checksum.Sum,
+	}, nil
+}
+
+// Checksum returns the checksum of version with a given time. Only the checksum object is read, so it is cheap
+// to call and can be used for comparing versions in different stores without reading their data.
+func (s *Store) Checksum(t time.Time) (store.VersionChecksum, error) {
+	checksum, err := s.readChecksum(t)
+	if err != nil {
+		return store.VersionChecksum{}, err
+	}
+	if _, ok := s.checksumAlgorithms[checksum.Algorithm]; !ok {
+		return store.VersionChecksum{}, fmt.Errorf("unknown checksum algorithm %s used for version %s", checksum.Algorithm, t)
+	}
+	return checksum, nil
+}
+
+// readChecksum reads checksum object written by Writer or by store.Store
+func (s *Store) readChecksum(t time.Time) (store.VersionChecksum, error) {
+	key := store.ChecksumFileName(s.dataKey(t))
+	body, err := s.client.getObject(key)
+	if isNotFound(err) {
+		return store.VersionChecksum{}, store.NewVersionNotFoundErrorWithCause(fmt.Sprintf("version %s not found", t), err)
+	}
+	if err != nil {
+		return store.VersionChecksum{}, fmt.Errorf("error getting checksum object %s: %w", key, err)
+	}
+	content, err := ioutil.ReadAll(body)
+	_ = body.Close()
+	if err != nil {
+		return store.VersionChecksum{}, fmt.Errorf("error reading checksum object %s: %w", key, err)
+	}
+	checksum, err := store.ParseChecksum(content)
+	if err != nil {
+		return store.VersionChecksum{}, fmt.Errorf("error parsing checksum object %s: %w", key, err)
+	}
+	return checksum, nil
+}
+
+type reader struct {
+	body     io.ReadCloser
+	key      string
+	version  store.Version
+	checksum hash.Hash
+	expected []byte
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.checksum.Write(p[:n])
+	if err == io.EOF {
+		if err2 := r.validateChecksum(); err2 != nil {
+			return n, err2
+		}
+	}
+	return n, err
+}
+
+func (r *reader) validateChecksum() error {
+	if !bytes.Equal(r.expected, r.checksum.Sum(nil)) {
+		return fmt.Errorf("invalid checksum when reading object %s", r.key)
+	}
+	return nil
+}
+
+// Close returns error when data was not read completely or did not match the checksum
+func (r *reader) Close() error {
+	if err := r.body.Close(); err != nil {
+		return fmt.Errorf("error closing object %s: %w", r.key, err)
+	}
+	return r.validateChecksum()
+}
+
+func (r *reader) Version() store.Version {
+	return r.version
+}
@@ -0,0 +1,392 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+// Package s3fake provides an in-process fake of S3 API, which can be used for testing code using s3store without
+// access to real object storage:
+//
+//	server := s3fake.NewServer("bucket")
+//	defer server.Close()
+//	s, err := s3store.Open(server.URL, "bucket")
+//
+// Only operations used by s3store are implemented: ListObjectsV2, PutObject, GetObject, HeadObject, DeleteObject
+// and multipart upload. Requests must be signed with AWS Signature Version 4, but only the payload hash
+// is verified.
+package s3fake
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MinPartSize is the minimum size of each part of multipart upload except the last one, the same as in S3
+const MinPartSize = 5 * 1024 * 1024
+
+const defaultMaxKeys = 1000
+
+// Server is a fake S3 server listening on a local port
+type Server struct {
+	// URL of the server, in a form of http://127.0.0.1:port
+	URL string
+
+	server *httptest.Server
+
+	mutex        sync.Mutex
+	buckets      map[string]map[string][]byte
+	modified     map[string]time.Time // last modification time of object by bucket + "/" + key
+	uploads      map[string]*upload
+	nextUploadID int
+	maxKeys      int
+}
+
+type upload struct {
+	bucket string
+	key    string
+	parts  map[int][]byte
+}
+
+// NewServer starts a server with given buckets. Server must be closed with Close.
+func NewServer(buckets ...string) *Server {
+	s := &Server{
+		buckets:  map[string]map[string][]byte{},
+		modified: map[string]time.Time{},
+		uploads:  map[string]*upload{},
+		maxKeys:  defaultMaxKeys,
+	}
+	for _, bucket := range buckets {
+		s.buckets[bucket] = map[string][]byte{}
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL
+	return s
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// SetMaxKeys sets the maximum number of keys returned by a single list request. Default is 1000.
+func (s *Server) SetMaxKeys(n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.maxKeys = n
+}
+
+// Keys returns sorted keys of all objects in the bucket
+func (s *Server) Keys(bucket string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return sortedKeys(s.buckets[bucket])
+}
+
+// Object returns content of the object. It returns false when object does not exist.
+func (s *Server) Object(bucket, key string) ([]byte, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	content, ok := s.buckets[bucket][key]
+	return append([]byte{}, content...), ok
+}
+
+// PutObject creates or replaces the object. It can be used to prepare or corrupt data in tests.
+func (s *Server) PutObject(bucket, key string, content []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = map[string][]byte{}
+	}
+	s.put(s.buckets[bucket], bucket, key, append([]byte{}, content...))
+}
+
+// SetLastModified changes the last modification time of the object returned when listing objects. It can be used
+// to prepare old objects in tests.
+func (s *Server) SetLastModified(bucket, key string, t time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.modified[bucket+"/"+key] = t
+}
+
+func (s *Server) put(objects map[string][]byte, bucket, key string, content []byte) {
+	objects[key] = content
+	s.modified[bucket+"/"+key] = time.Now()
+}
+
+// PendingUploads returns the number of multipart uploads which were neither completed nor aborted
+func (s *Server) PendingUploads() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.uploads)
+}
+
+func sortedKeys(objects map[string][]byte) []string {
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type errorResponse struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(errorResponse{Code: code, Message: message})
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=") ||
+		r.Header.Get("x-amz-date") == "" {
+		writeError(w, http.StatusForbidden, "AccessDenied", "request is not signed")
+		return
+	}
+	sum := sha256.Sum256(body)
+	if r.Header.Get("x-amz-content-sha256") != hex.EncodeToString(sum[:]) {
+		writeError(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "payload hash does not match")
+		return
+	}
+
+	bucket, key := splitPath(r.URL.Path)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "bucket "+bucket+" does not exist")
+		return
+	}
+
+	query := r.URL.Query()
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		s.listObjects(w, objects, bucket, query.Get("prefix"), query.Get("delimiter"), query.Get("continuation-token"))
+	case key == "":
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "method not allowed")
+	case r.Method == http.MethodPost && has(query, "uploads"):
+		s.createMultipartUpload(w, bucket, key)
+	case r.Method == http.MethodPost && has(query, "uploadId"):
+		s.completeMultipartUpload(w, objects, bucket, key, query.Get("uploadId"), body)
+	case r.Method == http.MethodPut && has(query, "uploadId"):
+		s.uploadPart(w, bucket, key, query.Get("uploadId"), query.Get("partNumber"), body)
+	case r.Method == http.MethodDelete && has(query, "uploadId"):
+		s.abortMultipartUpload(w, bucket, key, query.Get("uploadId"))
+	case r.Method == http.MethodPut:
+		s.put(objects, bucket, key, body)
+		w.Header().Set("ETag", etag(body))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		content, exists := objects[key]
+		if !exists {
+			writeError(w, http.StatusNotFound, "NoSuchKey", "key "+key+" does not exist")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Header().Set("ETag", etag(content))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(content)
+		}
+	case r.Method == http.MethodDelete:
+		delete(objects, key)
+		delete(s.modified, bucket+"/"+key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "method not allowed")
+	}
+}
+
+func has(query url.Values, name string) bool {
+	_, ok := query[name]
+	return ok
+}
+
+func splitPath(p string) (bucket, key string) {
+	p = strings.TrimPrefix(p, "/")
+	if i := strings.Index(p, "/"); i >= 0 {
+		return p[:i], p[i+1:]
+	}
+	return p, ""
+}
+
+func etag(content []byte) string {
+	sum := md5.Sum(content)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name  `xml:"ListBucketResult"`
+	Contents              []content `xml:"Contents"`
+	CommonPrefixes        []prefix  `xml:"CommonPrefixes"`
+	KeyCount              int       `xml:"KeyCount"`
+	IsTruncated           bool      `xml:"IsTruncated"`
+	NextContinuationToken string    `xml:"NextContinuationToken,omitempty"`
+}
+
+type content struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	Size         int64  `xml:"Size"`
+	ETag         string `xml:"ETag"`
+}
+
+type prefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// listObjects uses the last returned key as continuation token
+func (s *Server) listObjects(w http.ResponseWriter, objects map[string][]byte, bucket, keyPrefix, delimiter, token string) {
+	result := listBucketResult{}
+	seenPrefixes := map[string]struct{}{}
+	for _, key := range sortedKeys(objects) {
+		if !strings.HasPrefix(key, keyPrefix) || key <= token {
+			continue
+		}
+		if result.KeyCount == s.maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		if delimiter != "" {
+			if i := strings.Index(key[len(keyPrefix):], delimiter); i >= 0 {
+				commonPrefix := key[:len(keyPrefix)+i+len(delimiter)]
+				if _, seen := seenPrefixes[commonPrefix]; !seen {
+					seenPrefixes[commonPrefix] = struct{}{}
+					result.CommonPrefixes = append(result.CommonPrefixes, prefix{Prefix: commonPrefix})
+				}
+				continue
+			}
+		}
+		result.Contents = append(result.Contents, content{
+			Key:          key,
+			LastModified: s.modified[bucket+"/"+key].UTC().Format("2006-01-02T15:04:05.000Z"),
+			Size:         int64(len(objects[key])),
+			ETag:         etag(objects[key]),
+		})
+		result.KeyCount++
+		result.NextContinuationToken = key
+	}
+	if !result.IsTruncated {
+		result.NextContinuationToken = ""
+	}
+	writeXML(w, result)
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+func (s *Server) createMultipartUpload(w http.ResponseWriter, bucket, key string) {
+	s.nextUploadID++
+	uploadID := fmt.Sprintf("upload-%d", s.nextUploadID)
+	s.uploads[uploadID] = &upload{bucket: bucket, key: key, parts: map[int][]byte{}}
+	writeXML(w, initiateMultipartUploadResult{Bucket: bucket, Key: key, UploadID: uploadID})
+}
+
+func (s *Server) findUpload(w http.ResponseWriter, bucket, key, uploadID string) (*upload, bool) {
+	u, ok := s.uploads[uploadID]
+	if !ok || u.bucket != bucket || u.key != key {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "upload "+uploadID+" does not exist")
+		return nil, false
+	}
+	return u, true
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, bucket, key, uploadID, partNumber string, body []byte) {
+	u, ok := s.findUpload(w, bucket, key, uploadID)
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(partNumber)
+	if err != nil || n < 1 || n > 10000 {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid part number "+partNumber)
+		return
+	}
+	u.parts[n] = body
+	w.Header().Set("ETag", etag(body))
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+	Bucket  string   `xml:"Bucket"`
+	Key     string   `xml:"Key"`
+}
+
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, objects map[string][]byte, bucket, key, uploadID string, body []byte) {
+	u, ok := s.findUpload(w, bucket, key, uploadID)
+	if !ok {
+		return
+	}
+	request := completeMultipartUpload{}
+	if err := xml.Unmarshal(body, &request); err != nil || len(request.Parts) == 0 {
+		writeError(w, http.StatusBadRequest, "MalformedXML", "invalid list of parts")
+		return
+	}
+	var data []byte
+	for i, part := range request.Parts {
+		partData, exists := u.parts[part.PartNumber]
+		if !exists || etag(partData) != part.ETag {
+			writeError(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d not found", part.PartNumber))
+			return
+		}
+		if i > 0 && part.PartNumber <= request.Parts[i-1].PartNumber {
+			writeError(w, http.StatusBadRequest, "InvalidPartOrder", "parts must be sorted by part number")
+			return
+		}
+		if i < len(request.Parts)-1 && len(partData) < MinPartSize {
+			writeError(w, http.StatusBadRequest, "EntityTooSmall", fmt.Sprintf("part %d is too small", part.PartNumber))
+			return
+		}
+		data = append(data, partData...)
+	}
+	s.put(objects, bucket, key, data)
+	delete(s.uploads, uploadID)
+	writeXML(w, completeMultipartUploadResult{Bucket: bucket, Key: key})
+}
+
+func (s *Server) abortMultipartUpload(w http.ResponseWriter, bucket, key, uploadID string) {
+	if _, ok := s.findUpload(w, bucket, key, uploadID); !ok {
+		return
+	}
+	delete(s.uploads, uploadID)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,222 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+// Package s3store provides a store keeping versions in S3-compatible object storage, such as AWS S3, MinIO
+// or Ceph. Store can be used with codec, compacter and replicator packages, for example as a replication target:
+//
+//	s3, _ := s3store.Open("https://s3.eu-central-1.amazonaws.com", "bucket",
+//		s3store.Region("eu-central-1"), s3store.WithCredentials(credentials))
+//	go replicator.StartFromTo(ctx, local, s3)
+//
+// Each version is stored as two objects, exactly like files in a store directory: data object and checksum object
+// with ".sum" suffix, which has the same format as the checksum file written by store.Store. Data is uploaded
+// using multipart upload, so versions of any size can be written without loading them into memory. Checksum
+// object is uploaded after data object, so version is visible only when it was uploaded completely. S3 cannot upload
+// both objects atomically, and the checksum is known only after all parts were uploaded, so it cannot be stored as
+// metadata of the data object either. Therefore, a crash can leave a data object without the checksum object -
+// use Store.Cleanup (for example with compacter.Cleanup option) to remove such objects.
+//
+// Compression is not supported. S3 does not support atomic "create if not exists", so two writers writing
+// the same version at the same time are not detected.
+package s3store
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacekolszak/deebee/store"
+)
+
+const (
+	minPartSize   = 5 * 1024 * 1024 // minimum size of each part except the last one, required by S3
+	defaultRegion = "us-east-1"
+)
+
+// Open returns store keeping versions in a given bucket. endpoint is an URL of S3 API, such as
+// https://s3.eu-central-1.amazonaws.com or http://localhost:9000. Bucket must already exist.
+func Open(endpoint, bucket string, options ...Option) (*Store, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %s: %w", endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid endpoint %s: scheme must be http or https", endpoint)
+	}
+	if bucket == "" {
+		return nil, errors.New("empty bucket")
+	}
+
+	s := &Store{
+		client: &client{
+			endpoint:   u,
+			bucket:     bucket,
+			region:     defaultRegion,
+			httpClient: http.DefaultClient,
+			now:        time.Now,
+		},
+		partSize:           minPartSize,
+		checksumAlgorithm:  store.CRC32,
+		checksumAlgorithms: map[string]store.ChecksumAlgorithm{},
+	}
+	for _, algorithm := range []store.ChecksumAlgorithm{store.CRC32, store.CRC64, store.SHA256, store.SHA512} {
+		s.checksumAlgorithms[algorithm.Name] = algorithm
+	}
+
+	for _, apply := range options {
+		if apply == nil {
+			continue
+		}
+		if err = apply(s); err != nil {
+			return nil, fmt.Errorf("error applying option: %w", err)
+		}
+	}
+	return s, nil
+}
+
+type Option func(*Store) error
+
+// WithCredentials sets credentials used for signing requests. Without this option requests are signed with empty
+// credentials, which is accepted only by servers allowing anonymous access.
+func WithCredentials(credentials Credentials) Option {
+	return func(s *Store) error {
+		s.client.credentials = credentials
+		return nil
+	}
+}
+
+// Region sets the region used for signing requests. Default is us-east-1.
+func Region(region string) Option {
+	return func(s *Store) error {
+		if region == "" {
+			return errors.New("empty region")
+		}
+		s.client.region = region
+		return nil
+	}
+}
+
+// Prefix sets the prefix of object keys, such as "app/state/". It can be used to keep many stores in one bucket.
+func Prefix(prefix string) Option {
+	return func(s *Store) error {
+		s.prefix = prefix
+		return nil
+	}
+}
+
+// PartSize sets the size of parts used by multipart upload. It is also the amount of memory used by each Writer.
+// Versions smaller than part size are uploaded with a single request. Default and minimum is 5 MiB.
+func PartSize(size int) Option {
+	return func(s *Store) error {
+		if size < minPartSize {
+			return fmt.Errorf("part size must be at least %d bytes", minPartSize)
+		}
+		s.partSize = size
+		return nil
+	}
+}
+
+// HTTPClient sets the client used for sending requests. It can be used to configure timeouts or transport.
+func HTTPClient(c *http.Client) Option {
+	return func(s *Store) error {
+		if c == nil {
+			return errors.New("nil HTTP client")
+		}
+		s.client.httpClient = c
+		return nil
+	}
+}
+
+// Checksum sets the algorithm used for new versions. By default CRC32 is used. See store.Checksum.
+func Checksum(algorithm store.ChecksumAlgorithm) Option {
+	return func(s *Store) error {
+		if err := algorithm.Validate(); err != nil {
+			return err
+		}
+		s.checksumAlgorithm = algorithm
+		s.checksumAlgorithms[algorithm.Name] = algorithm
+		return nil
+	}
+}
+
+// Store is safe for concurrent use by multiple goroutines. Returned Reader and Writer are not though - each instance
+// should be used by a single goroutine at a time.
+type Store struct {
+	client             *client
+	prefix             string
+	partSize           int
+	checksumAlgorithm  store.ChecksumAlgorithm
+	checksumAlgorithms map[string]store.ChecksumAlgorithm
+
+	mutex           sync.Mutex
+	lastVersionTime time.Time
+}
+
+// Versions return slice sorted by time, oldest first
+func (s *Store) Versions() ([]store.Version, error) {
+	objects, err := s.client.listObjects(s.prefix)
+	if err != nil {
+		return nil, fmt.Errorf("error listing objects with prefix %s: %w", s.prefix, err)
+	}
+
+	keys := map[string]struct{}{}
+	for _, o := range objects {
+		keys[o.Key] = struct{}{}
+	}
+
+	var versions []store.Version
+	for _, o := range objects {
+		t, err := store.ParseDataFileName(strings.TrimPrefix(o.Key, s.prefix))
+		if err != nil {
+			continue // checksum object or object not written by the store
+		}
+		if _, hasChecksum := keys[store.ChecksumFileName(o.Key)]; !hasChecksum {
+			continue
+		}
+		versions = append(versions, store.Version{Time: t, Size: o.Size})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Time.Before(versions[j].Time)
+	})
+	return versions, nil
+}
+
+// DeleteVersion deletes checksum object first, so the version disappears even when deleting data object fails
+func (s *Store) DeleteVersion(t time.Time) error {
+	key := s.dataKey(t)
+	checksumKey := store.ChecksumFileName(key)
+	err := s.client.headObject(checksumKey)
+	if isNotFound(err) {
+		return store.NewVersionNotFoundError(fmt.Sprintf("version %s does not exist", t))
+	}
+	if err != nil {
+		return fmt.Errorf("error checking object %s: %w", checksumKey, err)
+	}
+	for _, k := range []string{checksumKey, key} {
+		if err = s.client.deleteObject(k); err != nil {
+			return fmt.Errorf("error deleting object %s: %w", k, err)
+		}
+	}
+	return nil
+}
+
+func (s *Store) dataKey(t time.Time) string {
+	return s.prefix + store.DataFileName(t)
+}
+
+func (s *Store) nextVersionTime() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	t := time.Now()
+	if !t.After(s.lastVersionTime) {
+		t = s.lastVersionTime.Add(time.Nanosecond)
+	}
+	s.lastVersionTime = t
+	return t
+}
@@ -0,0 +1,483 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package s3store_test
+
+import (
+	"bytes"
+	"context"
+	"hash/crc32"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jacekolszak/deebee/codec"
+	"github.com/jacekolszak/deebee/compacter"
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/replicator"
+	"github.com/jacekolszak/deebee/s3store"
+	"github.com/jacekolszak/deebee/s3store/s3fake"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const bucket = "bucket"
+
+func TestOpen(t *testing.T) {
+
+	t.Run("should return error for invalid arguments", func(t *testing.T) {
+		_, err := s3store.Open("localhost:9000", bucket)
+		assert.Error(t, err)
+		_, err = s3store.Open("http://localhost:9000", "")
+		assert.Error(t, err)
+	})
+
+	t.Run("should return error for invalid options", func(t *testing.T) {
+		options := map[string]s3store.Option{
+			"Region":     s3store.Region(""),
+			"PartSize":   s3store.PartSize(1024),
+			"HTTPClient": s3store.HTTPClient(nil),
+			"Checksum":   s3store.Checksum(store.ChecksumAlgorithm{Name: "invalid:name"}),
+		}
+		for name, option := range options {
+			t.Run(name, func(t *testing.T) {
+				_, err := s3store.Open("http://localhost:9000", bucket, option)
+				assert.Error(t, err)
+			})
+		}
+	})
+}
+
+func TestStore_Versions(t *testing.T) {
+
+	t.Run("should return no versions for empty bucket", func(t *testing.T) {
+		s, _ := openStore(t)
+		versions, err := s.Versions()
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+
+	t.Run("should return versions sorted by time", func(t *testing.T) {
+		s, _ := openStore(t)
+		v2 := writeData(t, s, []byte("v2"), store.WriteTime(time.Unix(2, 0)))
+		v1 := writeData(t, s, []byte("v1"), store.WriteTime(time.Unix(1, 0)))
+		// when
+		versions, err := s.Versions()
+		// then
+		require.NoError(t, err)
+		assertVersionsEqual(t, []store.Version{v1, v2}, versions)
+	})
+
+	t.Run("should follow pagination", func(t *testing.T) {
+		s, server := openStore(t)
+		server.SetMaxKeys(3)
+		for i := 0; i < 5; i++ {
+			writeData(t, s, []byte("data"))
+		}
+		// when
+		versions, err := s.Versions()
+		// then
+		require.NoError(t, err)
+		assert.Len(t, versions, 5)
+	})
+
+	t.Run("should skip data object without checksum and unknown objects", func(t *testing.T) {
+		s, server := openStore(t)
+		server.PutObject(bucket, "2021-01-01T00_00_00Z.data", []byte("data"))
+		server.PutObject(bucket, "other", []byte("data"))
+		server.PutObject(bucket, "nested/2021-01-01T00_00_00Z.data", []byte("data"))
+		server.PutObject(bucket, "nested/2021-01-01T00_00_00Z.data.sum", []byte("crc32:00000000\n"))
+		// when
+		versions, err := s.Versions()
+		// then
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+
+	t.Run("should use prefix", func(t *testing.T) {
+		server := s3fake.NewServer(bucket)
+		defer server.Close()
+		s1 := openStoreOnServer(t, server, s3store.Prefix("s1/"))
+		s2 := openStoreOnServer(t, server, s3store.Prefix("s2/"))
+		writeData(t, s1, []byte("data"))
+		// when
+		versions, err := s2.Versions()
+		// then
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+		assert.Len(t, server.Keys(bucket), 2)
+	})
+
+	t.Run("should return error when bucket does not exist", func(t *testing.T) {
+		server := s3fake.NewServer()
+		defer server.Close()
+		s := openStoreOnServer(t, server)
+		// when
+		_, err := s.Versions()
+		// then
+		assert.Error(t, err)
+	})
+}
+
+func TestStore_Writer(t *testing.T) {
+
+	t.Run("should write data and checksum objects in store format", func(t *testing.T) {
+		s, server := openStore(t)
+		// when
+		writeData(t, s, []byte("data"), store.WriteTime(time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)))
+		// then
+		assert.Equal(t, []string{"2021-01-02T03_04_05Z.data", "2021-01-02T03_04_05Z.data.sum"}, server.Keys(bucket))
+		data, _ := server.Object(bucket, "2021-01-02T03_04_05Z.data")
+		assert.Equal(t, []byte("data"), data)
+		sum, _ := server.Object(bucket, "2021-01-02T03_04_05Z.data.sum")
+		assert.Equal(t, "crc32:adf3f363\n", string(sum))
+	})
+
+	t.Run("should use multipart upload for large data", func(t *testing.T) {
+		s, server := openStore(t)
+		data := bytes.Repeat([]byte("0123456789"), s3fake.MinPartSize/10*2+1)
+		// when
+		writeData(t, s, data)
+		// then
+		assert.Equal(t, data, readData(t, s))
+		assert.Equal(t, 0, server.PendingUploads())
+	})
+
+	t.Run("should abort multipart upload", func(t *testing.T) {
+		s, server := openStore(t)
+		writer, err := s.Writer()
+		require.NoError(t, err)
+		_, err = writer.Write(make([]byte, s3fake.MinPartSize+1))
+		require.NoError(t, err)
+		require.Equal(t, 1, server.PendingUploads())
+		// when
+		writer.AbortAndClose()
+		// then
+		assert.Equal(t, 0, server.PendingUploads())
+		assert.Empty(t, server.Keys(bucket))
+	})
+
+	t.Run("should return error when version already exists", func(t *testing.T) {
+		s, _ := openStore(t)
+		v := writeData(t, s, []byte("data"))
+		// when
+		_, err := s.Writer(store.WriteTime(v.Time))
+		// then
+		assert.True(t, store.IsVersionAlreadyExists(err))
+	})
+
+	t.Run("should return error when writer is closed twice", func(t *testing.T) {
+		s, _ := openStore(t)
+		writer, err := s.Writer()
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+		// when
+		err = writer.Close()
+		// then
+		assert.Error(t, err)
+	})
+}
+
+func TestStore_Reader(t *testing.T) {
+
+	t.Run("should return error when there are no versions", func(t *testing.T) {
+		s, _ := openStore(t)
+		_, err := s.Reader()
+		assert.True(t, store.IsVersionNotFound(err))
+	})
+
+	t.Run("should read latest version", func(t *testing.T) {
+		s, _ := openStore(t)
+		writeData(t, s, []byte("v1"))
+		writeData(t, s, []byte("v2"))
+		// when
+		data := readData(t, s)
+		// then
+		assert.Equal(t, []byte("v2"), data)
+	})
+
+	t.Run("should read version with given time", func(t *testing.T) {
+		s, _ := openStore(t)
+		v1 := writeData(t, s, []byte("v1"))
+		writeData(t, s, []byte("v2"))
+		// when
+		data := readData(t, s, store.Time(v1.Time))
+		// then
+		assert.Equal(t, []byte("v1"), data)
+	})
+
+	t.Run("should read version written with other checksum algorithm", func(t *testing.T) {
+		server := s3fake.NewServer(bucket)
+		defer server.Close()
+		writeData(t, openStoreOnServer(t, server, s3store.Checksum(store.SHA512)), []byte("data"))
+		s := openStoreOnServer(t, server)
+		// when
+		data := readData(t, s)
+		// then
+		assert.Equal(t, []byte("data"), data)
+	})
+
+	t.Run("should return error when data is corrupted", func(t *testing.T) {
+		s, server := openStore(t)
+		writeData(t, s, []byte("data"), store.WriteTime(time.Unix(0, 0)))
+		server.PutObject(bucket, "1970-01-01T00_00_00Z.data", []byte("DATA"))
+		reader, err := s.Reader()
+		require.NoError(t, err)
+		// when
+		_, err = io.ReadAll(reader)
+		// then
+		assert.Error(t, err)
+		assert.Error(t, reader.Close())
+	})
+
+	t.Run("should read version with legacy raw CRC32 checksum", func(t *testing.T) {
+		s, server := openStore(t)
+		server.PutObject(bucket, "2021-01-01T00_00_00Z.data", []byte("data"))
+		sum := crc32.NewIEEE()
+		_, _ = sum.Write([]byte("data"))
+		server.PutObject(bucket, "2021-01-01T00_00_00Z.data.sum", sum.Sum(nil))
+		// when
+		data := readData(t, s)
+		// then
+		assert.Equal(t, []byte("data"), data)
+	})
+
+	t.Run("should read version written by store.Store", func(t *testing.T) {
+		dirStore := tests.OpenStore(t)
+		v := tests.WriteData(t, dirStore, []byte("data"))
+		s, _ := openStore(t)
+		// when
+		require.NoError(t, replicator.CopyFromTo(dirStore, s))
+		// then
+		reader, err := s.Reader()
+		require.NoError(t, err)
+		assert.True(t, v.Time.Equal(reader.Version().Time))
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.NoError(t, reader.Close())
+		assert.Equal(t, []byte("data"), data)
+	})
+}
+
+func TestStore_Checksum(t *testing.T) {
+
+	t.Run("should return error when version does not exist", func(t *testing.T) {
+		s, _ := openStore(t)
+		_, err := s.Checksum(time.Now())
+		assert.True(t, store.IsVersionNotFound(err))
+	})
+
+	t.Run("should return the same checksum as store.Store", func(t *testing.T) {
+		dirStore := tests.OpenStore(t)
+		v := tests.WriteData(t, dirStore, []byte("data"))
+		s, _ := openStore(t)
+		require.NoError(t, replicator.CopyFromTo(dirStore, s))
+		expected, err := dirStore.Checksum(v.Time)
+		require.NoError(t, err)
+		// when
+		checksum, err := s.Checksum(v.Time)
+		// then
+		require.NoError(t, err)
+		assert.True(t, expected.Equal(checksum))
+	})
+
+	t.Run("should return error for unknown algorithm", func(t *testing.T) {
+		s, server := openStore(t)
+		server.PutObject(bucket, "2021-01-01T00_00_00Z.data", []byte("data"))
+		server.PutObject(bucket, "2021-01-01T00_00_00Z.data.sum", []byte("md5:00\n"))
+		// when
+		_, err := s.Checksum(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+		// then
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "md5")
+	})
+}
+
+func TestStore_DeleteVersion(t *testing.T) {
+
+	t.Run("should delete version", func(t *testing.T) {
+		s, server := openStore(t)
+		v := writeData(t, s, []byte("data"))
+		// when
+		err := s.DeleteVersion(v.Time)
+		// then
+		require.NoError(t, err)
+		assert.Empty(t, server.Keys(bucket))
+	})
+
+	t.Run("should return error when version does not exist", func(t *testing.T) {
+		s, _ := openStore(t)
+		err := s.DeleteVersion(time.Now())
+		assert.True(t, store.IsVersionNotFound(err))
+	})
+
+	t.Run("should be usable with compacter", func(t *testing.T) {
+		s, _ := openStore(t)
+		for i := 0; i < 3; i++ {
+			writeData(t, s, []byte("data"))
+		}
+		// when
+		err := compacter.RunOnce(s, compacter.KeepLast(1))
+		// then
+		require.NoError(t, err)
+		versions, err := s.Versions()
+		require.NoError(t, err)
+		assert.Len(t, versions, 1)
+	})
+}
+
+func TestStore_Cleanup(t *testing.T) {
+
+	t.Run("should return error for invalid option", func(t *testing.T) {
+		s, _ := openStore(t)
+		_, err := s.Cleanup(store.GracePeriod(-1))
+		assert.Error(t, err)
+	})
+
+	t.Run("should remove old objects left by interrupted writes", func(t *testing.T) {
+		s, server := openStore(t)
+		v := writeData(t, s, []byte("data"))
+		old := time.Now().Add(-2 * time.Hour)
+		orphanedData := store.DataFileName(old)
+		strayChecksum := store.ChecksumFileName(store.DataFileName(old.Add(time.Second)))
+		for _, key := range []string{orphanedData, strayChecksum} {
+			server.PutObject(bucket, key, []byte("x"))
+			server.SetLastModified(bucket, key, old)
+		}
+		// when
+		report, err := s.Cleanup()
+		// then
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{orphanedData, strayChecksum}, report.Removed)
+		dataKey := store.DataFileName(v.Time)
+		assert.Equal(t, []string{dataKey, store.ChecksumFileName(dataKey)}, server.Keys(bucket))
+	})
+
+	t.Run("should not remove objects younger than grace period", func(t *testing.T) {
+		s, server := openStore(t)
+		server.PutObject(bucket, store.DataFileName(time.Now()), []byte("data"))
+		// when
+		report, err := s.Cleanup()
+		// then
+		require.NoError(t, err)
+		assert.Empty(t, report.Removed)
+		assert.Len(t, server.Keys(bucket), 1)
+	})
+
+	t.Run("should not remove objects not written by the store", func(t *testing.T) {
+		s, server := openStore(t)
+		for _, key := range []string{"other", "other.sum"} {
+			server.PutObject(bucket, key, []byte("x"))
+			server.SetLastModified(bucket, key, time.Now().Add(-2*time.Hour))
+		}
+		// when
+		report, err := s.Cleanup()
+		// then
+		require.NoError(t, err)
+		assert.Empty(t, report.Removed)
+	})
+
+	t.Run("should be usable with compacter", func(t *testing.T) {
+		s, server := openStore(t)
+		writeData(t, s, []byte("data"))
+		server.PutObject(bucket, store.DataFileName(time.Now().Add(-time.Minute)), []byte("orphaned"))
+		// when
+		err := compacter.RunOnce(s, compacter.Cleanup(store.GracePeriod(0)))
+		// then
+		require.NoError(t, err)
+		assert.Len(t, server.Keys(bucket), 2)
+	})
+}
+
+func TestReplicationToS3(t *testing.T) {
+	local := tests.OpenStore(t)
+	tests.WriteData(t, local, []byte("data"))
+	s, _ := openStore(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	replicated := make(chan replicator.Event, 1)
+	go func() {
+		_ = replicator.StartFromTo(ctx, local, s,
+			replicator.Interval(time.Millisecond),
+			replicator.OnEvent(func(e replicator.Event) {
+				select {
+				case replicated <- e:
+				default:
+				}
+			}))
+	}()
+	// when
+	e := <-replicated
+	// then
+	assert.IsType(t, replicator.ReplicationSucceeded{}, e)
+	decoder := &tests.FakeDecoder{}
+	_, err := codec.ReadLatest(s, decoder.Decode)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), decoder.DataRead())
+}
+
+func TestReplicationToS3WithDifferentCopy(t *testing.T) {
+	local := tests.OpenStore(t)
+	v := tests.WriteData(t, local, []byte("data"))
+	s, _ := openStore(t)
+	writeData(t, s, []byte("other"), store.WriteTime(v.Time))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	replicated := make(chan replicator.Event, 1)
+	go func() {
+		_ = replicator.StartFromTo(ctx, local, s,
+			replicator.Interval(time.Millisecond),
+			replicator.OnEvent(func(e replicator.Event) {
+				select {
+				case replicated <- e:
+				default:
+				}
+			}))
+	}()
+	// when
+	e := <-replicated
+	// then
+	failed, ok := e.(replicator.ReplicationFailed)
+	require.True(t, ok, "ReplicationFailed expected, because checksums are different")
+	assert.Contains(t, failed.Err.Error(), "different checksum")
+}
+
+func openStore(t *testing.T) (*s3store.Store, *s3fake.Server) {
+	server := s3fake.NewServer(bucket)
+	t.Cleanup(server.Close)
+	return openStoreOnServer(t, server), server
+}
+
+func openStoreOnServer(t *testing.T, server *s3fake.Server, options ...s3store.Option) *s3store.Store {
+	credentials := s3store.Credentials{AccessKeyID: "key", SecretAccessKey: "secret"}
+	s, err := s3store.Open(server.URL, bucket, append(options, s3store.WithCredentials(credentials))...)
+	require.NoError(t, err)
+	return s
+}
+
+func writeData(t *testing.T, s *s3store.Store, data []byte, options ...store.WriterOption) store.Version {
+	writer, err := s.Writer(options...)
+	require.NoError(t, err)
+	_, err = writer.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	return writer.Version()
+}
+
+func readData(t *testing.T, s *s3store.Store, options ...store.ReaderOption) []byte {
+	reader, err := s.Reader(options...)
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	return data
+}
+
+func assertVersionsEqual(t *testing.T, expected, actual []store.Version) {
+	require.Len(t, actual, len(expected))
+	for i, v := range expected {
+		assert.True(t, v.Time.Equal(actual[i].Time), "times not equal")
+		assert.Equal(t, v.Size, actual[i].Size)
+	}
+}
@@ -0,0 +1,162 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package s3store
+
+import (
+	"fmt"
+	"hash"
+	"time"
+
+	"github.com/jacekolszak/deebee/store"
+)
+
+func (s *Store) Writer(options ...store.WriterOption) (store.Writer, error) {
+	opts, err := store.ApplyWriterOptions(options, s.nextVersionTime())
+	if err != nil {
+		return nil, err
+	}
+
+	key := s.dataKey(opts.Time())
+	checksumKey := store.ChecksumFileName(key)
+	err = s.client.headObject(checksumKey)
+	if err == nil {
+		return nil, store.NewVersionAlreadyExistsError(fmt.Sprintf("version %s already exists", opts.Time()))
+	}
+	if !isNotFound(err) {
+		return nil, fmt.Errorf("error checking object %s: %w", checksumKey, err)
+	}
+
+	return &writer{
+		client:            s.client,
+		key:               key,
+		time:              opts.Time(),
+		partSize:          s.partSize,
+		checksum:          s.checksumAlgorithm.NewHash(),
+		checksumAlgorithm: s.checksumAlgorithm.Name,
+	}, nil
+}
+
+// writer buffers data until the part is full. First full part starts multipart upload. Data smaller than one part
+// is uploaded with a single request on Close.
+type writer struct {
+	client   *client
+	key      string
+	time     time.Time
+	partSize int
+	closed   bool
+	size     int64
+	checksum hash.Hash
+
+	checksumAlgorithm string
+
+	buffer   []byte
+	uploadID string // empty when multipart upload was not started
+	parts    []completedPart
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("writer for version %s already closed", w.time)
+	}
+	written := 0
+	for len(p) > 0 {
+		n := w.partSize - len(w.buffer)
+		if n > len(p) {
+			n = len(p)
+		}
+		w.buffer = append(w.buffer, p[:n]...)
+		w.checksum.Write(p[:n])
+		w.size += int64(n)
+		written += n
+		p = p[n:]
+		if len(w.buffer) == w.partSize {
+			if err := w.uploadPart(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *writer) uploadPart() error {
+	if w.uploadID == "" {
+		uploadID, err := w.client.createMultipartUpload(w.key)
+		if err != nil {
+			return fmt.Errorf("error creating multipart upload for object %s: %w", w.key, err)
+		}
+		w.uploadID = uploadID
+	}
+	partNumber := len(w.parts) + 1
+	etag, err := w.client.uploadPart(w.key, w.uploadID, partNumber, w.buffer)
+	if err != nil {
+		return fmt.Errorf("error uploading part %d of object %s: %w", partNumber, w.key, err)
+	}
+	w.parts = append(w.parts, completedPart{PartNumber: partNumber, ETag: etag})
+	w.buffer = w.buffer[:0]
+	return nil
+}
+
+// Close uploads remaining data and then the checksum object, which makes the version visible. Data object is deleted
+// when uploading the checksum object fails. When the process crashes in between, data object is left without
+// the checksum object - it is not visible as a version and is removed by Store.Cleanup.
+func (w *writer) Close() error {
+	if w.closed {
+		return fmt.Errorf("writer for version %s already closed", w.time)
+	}
+	w.closed = true
+
+	if err := w.uploadData(); err != nil {
+		w.abort()
+		return err
+	}
+	checksum := store.FormatChecksum(store.VersionChecksum{Algorithm: w.checksumAlgorithm, Sum: w.checksum.Sum(nil)})
+	checksumKey := store.ChecksumFileName(w.key)
+	if err := w.client.putObject(checksumKey, checksum); err != nil {
+		_ = w.client.deleteObject(w.key)
+		return fmt.Errorf("error uploading checksum object %s: %w", checksumKey, err)
+	}
+	return nil
+}
+
+func (w *writer) uploadData() error {
+	if w.uploadID == "" {
+		if err := w.client.putObject(w.key, w.buffer); err != nil {
+			return fmt.Errorf("error uploading object %s: %w", w.key, err)
+		}
+		return nil
+	}
+	if len(w.buffer) > 0 {
+		if err := w.uploadPart(); err != nil {
+			return err
+		}
+	}
+	if err := w.client.completeMultipartUpload(w.key, w.uploadID, w.parts); err != nil {
+		return fmt.Errorf("error completing multipart upload of object %s: %w", w.key, err)
+	}
+	w.uploadID = ""
+	return nil
+}
+
+func (w *writer) abort() {
+	if w.uploadID != "" {
+		_ = w.client.abortMultipartUpload(w.key, w.uploadID)
+		w.uploadID = ""
+	}
+	w.buffer = nil
+}
+
+func (w *writer) Version() store.Version {
+	return store.Version{
+		Time: w.time,
+		Size: w.size,
+	}
+}
+
+func (w *writer) AbortAndClose() {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	w.abort()
+}
@@ -0,0 +1,90 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package scrubber
+
+import (
+	"time"
+
+	"github.com/jacekolszak/deebee/internal/eventlog"
+	"github.com/jacekolszak/deebee/store"
+)
+
+// Event is emitted by RunOnce and Start. It is either ScrubSucceeded or ScrubFailed.
+type Event interface {
+	event()
+}
+
+// ScrubSucceeded is emitted when all versions were verified. Report contains problems found, if any.
+type ScrubSucceeded struct {
+	Report   store.VerifyReport
+	Duration time.Duration
+}
+
+// ScrubFailed is emitted when verification could not be finished. Report contains results for versions verified
+// before the failure.
+type ScrubFailed struct {
+	Report   store.VerifyReport
+	Err      error
+	Duration time.Duration
+}
+
+func (ScrubSucceeded) event() {}
+func (ScrubFailed) event()    {}
+
+// OnEvent registers a listener receiving events. Listener is called once the whole store was verified, with
+// the report attached to the event.
+func OnEvent(listener func(Event)) Option {
+	return func(options *Options) error {
+		options.listeners = append(options.listeners, listener)
+		return nil
+	}
+}
+
+// Logger is a structured logger used by Log option, such as *slog.Logger
+type Logger = eventlog.Logger
+
+// Log logs all events using logger. By default, Start logs failures and problems found using the standard log
+// package, unless a Logger or event listener was given.
+func Log(logger Logger) Option {
+	return OnEvent(func(e Event) {
+		eventlog.Write(logger, logEntries(e)...)
+	})
+}
+
+func logEntries(e Event) []eventlog.Entry {
+	switch e := e.(type) {
+	case ScrubSucceeded:
+		r := e.Report
+		if r.OK() {
+			return []eventlog.Entry{eventlog.Debug("scrub succeeded", "verified", len(r.Verified), "duration", e.Duration)}
+		}
+		var entries []eventlog.Entry
+		for _, corrupted := range r.Corrupted {
+			entries = append(entries, eventlog.Error("scrubber found corrupted version",
+				"version", corrupted.Version.Time, "error", corrupted.Err))
+		}
+		for _, unverifiable := range r.Unverifiable {
+			entries = append(entries, eventlog.Error("scrubber could not verify version",
+				"version", unverifiable.Version.Time, "error", unverifiable.Err))
+		}
+		return append(entries, eventlog.Error("scrubber found problems",
+			"verified", len(r.Verified),
+			"corrupted", len(r.Corrupted),
+			"unverifiable", len(r.Unverifiable),
+			"orphanedDataFiles", r.OrphanedDataFiles,
+			"strayChecksumFiles", r.StrayChecksumFiles,
+			"unparsableFiles", r.UnparsableFiles,
+			"quarantined", r.Quarantined,
+			"duration", e.Duration))
+	case ScrubFailed:
+		return []eventlog.Entry{eventlog.Error("scrub failed", "error", e.Err, "duration", e.Duration)}
+	}
+	return nil
+}
+
+func (o *Options) emit(e Event) {
+	for _, listener := range o.listeners {
+		listener(e)
+	}
+}
@@ -0,0 +1,153 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+// Package scrubber periodically verifies integrity of all versions in the store. Data on disk can silently
+// degrade over time, and corruption of old versions would otherwise be noticed only when they are read.
+package scrubber
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jacekolszak/deebee/store"
+	"github.com/jacekolszak/deebee/throttle"
+)
+
+type Store interface {
+	Verify(context.Context, ...store.VerifyOption) (store.VerifyReport, error)
+}
+
+// RunOnce verifies all versions in the store
+func RunOnce(ctx context.Context, s Store, options ...Option) (store.VerifyReport, error) {
+	if s == nil {
+		return store.VerifyReport{}, errors.New("nil store")
+	}
+
+	opts, err := applyOptions(options)
+	if err != nil {
+		return store.VerifyReport{}, err
+	}
+
+	return opts.scrub(ctx, s)
+}
+
+// Start verifies all versions in the store periodically, in one hour intervals by default. It blocks until
+// context is cancelled.
+func Start(ctx context.Context, s Store, options ...Option) error {
+	if s == nil {
+		return errors.New("nil store")
+	}
+
+	opts, err := applyOptions(options)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-time.After(opts.interval):
+			report, err := opts.scrub(ctx, s)
+			if len(opts.listeners) > 0 || ctx.Err() != nil {
+				continue
+			}
+			if err != nil {
+				log.Printf("scrubber.RunOnce failed: %s", err)
+			} else if !report.OK() {
+				log.Printf("scrubber found problems: %d corrupted versions, %d unverifiable versions, "+
+					"%d orphaned data files, %d stray checksum files, %d unparsable files", len(report.Corrupted),
+					len(report.Unverifiable), len(report.OrphanedDataFiles), len(report.StrayChecksumFiles),
+					len(report.UnparsableFiles))
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (o *Options) scrub(ctx context.Context, s Store) (store.VerifyReport, error) {
+	start := time.Now()
+	var verifyOptions []store.VerifyOption
+	if o.quarantine {
+		verifyOptions = append(verifyOptions, store.Quarantine)
+	}
+	if o.limiter != nil {
+		verifyOptions = append(verifyOptions, store.VerifyThrottle(o.limiter))
+	}
+	var (
+		report store.VerifyReport
+		err    error
+	)
+	verify := func() {
+		report, err = s.Verify(ctx, verifyOptions...)
+	}
+	if o.lowIO {
+		throttle.LowIOPriority(verify)
+	} else {
+		verify()
+	}
+	if err != nil {
+		o.emit(ScrubFailed{Report: report, Err: err, Duration: time.Since(start)})
+		return report, err
+	}
+	o.emit(ScrubSucceeded{Report: report, Duration: time.Since(start)})
+	return report, nil
+}
+
+type Option func(*Options) error
+
+type Options struct {
+	interval   time.Duration
+	quarantine bool
+	limiter    *throttle.Limiter
+	lowIO      bool
+	listeners  []func(Event)
+}
+
+func Interval(d time.Duration) Option {
+	return func(options *Options) error {
+		options.interval = d
+		return nil
+	}
+}
+
+// Quarantine moves corrupted versions into quarantine subdirectory of the store. See store.Quarantine.
+var Quarantine Option = func(options *Options) error {
+	options.quarantine = true
+	return nil
+}
+
+// Throttle limits the rate of reading versions. See store.VerifyThrottle.
+func Throttle(limiter *throttle.Limiter) Option {
+	return func(options *Options) error {
+		if limiter == nil {
+			return errors.New("nil limiter")
+		}
+		options.limiter = limiter
+		return nil
+	}
+}
+
+// LowIOPriority runs verification with idle I/O scheduling class. See throttle.LowIOPriority for details.
+var LowIOPriority Option = func(options *Options) error {
+	options.lowIO = true
+	return nil
+}
+
+func applyOptions(options []Option) (*Options, error) {
+	opts := &Options{
+		interval: time.Hour,
+	}
+
+	for _, apply := range options {
+		if apply == nil {
+			continue
+		}
+		if err := apply(opts); err != nil {
+			return nil, fmt.Errorf("error applying option: %w", err)
+		}
+	}
+	return opts, nil
+}
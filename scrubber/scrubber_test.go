@@ -0,0 +1,190 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package scrubber_test
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/scrubber"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/jacekolszak/deebee/throttle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunOnce(t *testing.T) {
+
+	t.Run("should return error for nil store", func(t *testing.T) {
+		_, err := scrubber.RunOnce(context.Background(), nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("should return report", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s := openStore(t, dir)
+		tests.WriteData(t, s, []byte("v1"))
+		v2 := tests.WriteData(t, s, []byte("v2"))
+		tests.CorruptFile(t, path.Join(dir, dataFilename(v2)))
+		// when
+		report, err := scrubber.RunOnce(context.Background(), s)
+		// then
+		require.NoError(t, err)
+		assert.Len(t, report.Verified, 1)
+		assert.Len(t, report.Corrupted, 1)
+		assert.Empty(t, report.Quarantined)
+	})
+
+	t.Run("should quarantine corrupted versions", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s := openStore(t, dir)
+		v := tests.WriteData(t, s, []byte("data"))
+		tests.CorruptFile(t, path.Join(dir, dataFilename(v)))
+		// when
+		report, err := scrubber.RunOnce(context.Background(), s, scrubber.Quarantine)
+		// then
+		require.NoError(t, err)
+		assert.Len(t, report.Quarantined, 2)
+		versions, err := s.Versions()
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+
+	t.Run("should not quarantine version written with unknown checksum algorithm", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		md5Algorithm := store.ChecksumAlgorithm{Name: "md5", NewHash: md5.New}
+		s, err := store.Open(dir, store.Checksum(md5Algorithm))
+		require.NoError(t, err)
+		tests.WriteData(t, s, []byte("data"))
+		// when
+		report, err := scrubber.RunOnce(context.Background(), openStore(t, dir), scrubber.Quarantine)
+		// then
+		require.NoError(t, err)
+		assert.Len(t, report.Unverifiable, 1)
+		assert.Empty(t, report.Corrupted)
+		assert.Empty(t, report.Quarantined)
+	})
+}
+
+func TestThrottle(t *testing.T) {
+
+	t.Run("should return error for nil limiter", func(t *testing.T) {
+		_, err := scrubber.RunOnce(context.Background(), tests.OpenStore(t), scrubber.Throttle(nil))
+		assert.Error(t, err)
+	})
+
+	t.Run("should verify versions with limited throughput", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		tests.WriteData(t, s, make([]byte, 600))
+		limiter, err := throttle.NewLimiter(10000, 100)
+		require.NoError(t, err)
+		start := time.Now()
+		// when
+		report, err := scrubber.RunOnce(context.Background(), s, scrubber.Throttle(limiter), scrubber.LowIOPriority)
+		// then
+		require.NoError(t, err)
+		assert.Len(t, report.Verified, 1)
+		assert.GreaterOrEqual(t, int64(time.Since(start)), int64(40*time.Millisecond))
+	})
+}
+
+func TestStart(t *testing.T) {
+
+	t.Run("should return error for nil store", func(t *testing.T) {
+		err := scrubber.Start(context.Background(), nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("should verify store periodically until context is cancelled", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		tests.WriteData(t, s, []byte("data"))
+		recorder := &tests.EventRecorder{}
+		ctx, cancel := context.WithCancel(context.Background())
+		async := tests.RunAsync(func() {
+			_ = scrubber.Start(ctx, s, scrubber.Interval(time.Millisecond), scrubber.OnEvent(recordEvent(recorder)))
+		})
+		// then
+		assert.Eventually(t, func() bool {
+			return len(recorder.Events()) >= 2
+		}, time.Second, time.Millisecond)
+		succeeded, ok := recorder.Events()[0].(scrubber.ScrubSucceeded)
+		require.True(t, ok, "ScrubSucceeded expected")
+		assert.Len(t, succeeded.Report.Verified, 1)
+		// cleanup
+		cancel()
+		async.WaitOrFailAfter(t, time.Second)
+	})
+}
+
+func TestLog(t *testing.T) {
+
+	t.Run("should log success with debug level", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		tests.WriteData(t, s, []byte("data"))
+		logger := &tests.LoggerMock{}
+		// when
+		_, err := scrubber.RunOnce(context.Background(), s, scrubber.Log(logger))
+		// then
+		require.NoError(t, err)
+		entries := logger.Entries()
+		require.Len(t, entries, 1)
+		assert.Equal(t, "DEBUG", entries[0].Level)
+	})
+
+	t.Run("should log corrupted versions", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s := openStore(t, dir)
+		v := tests.WriteData(t, s, []byte("data"))
+		tests.CorruptFile(t, path.Join(dir, dataFilename(v)))
+		logger := &tests.LoggerMock{}
+		// when
+		_, err := scrubber.RunOnce(context.Background(), s, scrubber.Log(logger))
+		// then
+		require.NoError(t, err)
+		entries := logger.Entries()
+		require.Len(t, entries, 2)
+		assert.Equal(t, "ERROR", entries[0].Level)
+		assert.Equal(t, "scrubber found corrupted version", entries[0].Msg)
+		assert.Equal(t, "ERROR", entries[1].Level)
+	})
+
+	t.Run("should log failure", func(t *testing.T) {
+		logger := &tests.LoggerMock{}
+		// when
+		_, err := scrubber.RunOnce(context.Background(), failingStore{}, scrubber.Log(logger))
+		// then
+		require.Error(t, err)
+		entries := logger.Entries()
+		require.Len(t, entries, 1)
+		assert.Equal(t, "ERROR", entries[0].Level)
+		assert.Equal(t, "scrub failed", entries[0].Msg)
+	})
+}
+
+func openStore(t *testing.T, dir string) *store.Store {
+	s, err := store.Open(dir)
+	require.NoError(t, err)
+	return s
+}
+
+func dataFilename(version store.Version) string {
+	return version.Time.UTC().Format("2006-01-02T15_04_05.999999999Z") + ".data"
+}
+
+func recordEvent(recorder *tests.EventRecorder) func(scrubber.Event) {
+	return func(e scrubber.Event) {
+		recorder.Record(e)
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Verify(context.Context, ...store.VerifyOption) (store.VerifyReport, error) {
+	return store.VerifyReport{}, errors.New("verify failed")
+}
@@ -4,10 +4,214 @@
 package store
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"hash"
 	"hash/crc32"
+	"hash/crc64"
+	"io/fs"
+	"regexp"
+	"strings"
+	"time"
 )
 
-func newHash() hash.Hash {
-	return crc32.NewIEEE()
+// ChecksumAlgorithm calculates checksum of version data. Name is saved in the checksum file along with the checksum,
+// so each version is verified using the algorithm it was written with.
+type ChecksumAlgorithm struct {
+	Name    string
+	NewHash func() hash.Hash
+}
+
+var (
+	CRC32 = ChecksumAlgorithm{
+		Name:    "crc32",
+		NewHash: func() hash.Hash { return crc32.NewIEEE() },
+	}
+	CRC64 = ChecksumAlgorithm{
+		Name:    "crc64",
+		NewHash: func() hash.Hash { return crc64.New(crc64.MakeTable(crc64.ECMA)) },
+	}
+	SHA256 = ChecksumAlgorithm{
+		Name:    "sha256",
+		NewHash: sha256.New,
+	}
+	SHA512 = ChecksumAlgorithm{
+		Name:    "sha512",
+		NewHash: sha512.New,
+	}
+)
+
+var checksumAlgorithmName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+const compressionAttribute = "compression:"
+
+// Checksum sets the algorithm used for new versions. By default CRC32 is used. Versions written with built-in
+// algorithms (CRC32, CRC64, SHA256, SHA512) can always be read.
+//
+// xxHash and BLAKE2 are not built in, because they are not part of the standard library. They can be used, like any
+// other custom algorithm, by passing ChecksumAlgorithm with their hash.Hash implementation, for example:
+//
+//	store.Checksum(store.ChecksumAlgorithm{
+//		Name:    "blake2b-256",
+//		NewHash: func() hash.Hash { h, _ := blake2b.New256(nil); return h },
+//	})
+//
+// Checksum option with a custom algorithm must be used each time the store is opened in order to read versions
+// written with that algorithm.
+func Checksum(algorithm ChecksumAlgorithm) Option {
+	return func(s *Store) error {
+		if err := algorithm.Validate(); err != nil {
+			return err
+		}
+		s.checksumAlgorithm = algorithm
+		s.checksumAlgorithms[algorithm.Name] = algorithm
+		return nil
+	}
+}
+
+// Validate returns error when algorithm cannot be saved in the checksum file
+func (a ChecksumAlgorithm) Validate() error {
+	if !checksumAlgorithmName.MatchString(a.Name) {
+		return fmt.Errorf("invalid checksum algorithm name %q: must contain only a-z, 0-9, _ or -", a.Name)
+	}
+	if a.NewHash == nil {
+		return errors.New("nil checksum algorithm NewHash")
+	}
+	return nil
+}
+
+func builtInChecksumAlgorithms() map[string]ChecksumAlgorithm {
+	algorithms := map[string]ChecksumAlgorithm{}
+	for _, algorithm := range []ChecksumAlgorithm{CRC32, CRC64, SHA256, SHA512} {
+		algorithms[algorithm.Name] = algorithm
+	}
+	return algorithms
+}
+
+// FormatChecksum returns content of checksum file. First line is in a form of "<algorithm>:<hex encoded checksum>".
+// When data is compressed the second line is "compression:<compressor name>".
+func FormatChecksum(c VersionChecksum) []byte {
+	content := fmt.Sprintf("%s:%x\n", c.Algorithm, c.Sum)
+	if c.Compression != "" {
+		content += compressionAttribute + c.Compression + "\n"
+	}
+	return []byte(content)
+}
+
+// ParseChecksum parses checksum file content written by FormatChecksum. Raw CRC32 checksum written by older
+// versions of the library, and "ALTERED" written by hand in place of the checksum, are also accepted. Error is
+// returned for any other content.
+func ParseChecksum(content []byte) (VersionChecksum, error) {
+	if isLegacyChecksum(content) {
+		return VersionChecksum{Algorithm: CRC32.Name, Sum: content}, nil
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	firstLine := strings.TrimSpace(lines[0])
+	separator := strings.IndexByte(firstLine, ':')
+	if separator <= 0 {
+		return VersionChecksum{}, fmt.Errorf("invalid checksum %q: must be in a form of <algorithm>:<hex>", firstLine)
+	}
+	name := firstLine[:separator]
+	if !checksumAlgorithmName.MatchString(name) {
+		return VersionChecksum{}, fmt.Errorf("invalid checksum algorithm name %q", name)
+	}
+	sum, err := hex.DecodeString(firstLine[separator+1:])
+	if err != nil || len(sum) == 0 {
+		return VersionChecksum{}, fmt.Errorf("invalid hex encoded checksum %q", firstLine[separator+1:])
+	}
+
+	parsed := VersionChecksum{Algorithm: name, Sum: sum}
+	for _, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, compressionAttribute) || len(line) == len(compressionAttribute) {
+			return VersionChecksum{}, fmt.Errorf("invalid checksum attribute %q", line)
+		}
+		parsed.Compression = line[len(compressionAttribute):]
+	}
+	return parsed, nil
+}
+
+// isLegacyChecksum returns true for raw CRC32 checksum written by older versions of the library, or for checksum
+// replaced by hand with "ALTERED" (see areChecksumsEqual)
+func isLegacyChecksum(content []byte) bool {
+	if len(content) == crc32.Size {
+		return true
+	}
+	switch string(content) {
+	case "ALTERED", "ALTERED\n", "ALTERED\r\n":
+		return true
+	}
+	return false
+}
+
+type parsedChecksum struct {
+	algorithm        ChecksumAlgorithm
+	sum              []byte
+	unknownAlgorithm string
+	compressor       string
+}
+
+func parseChecksum(content []byte, algorithms map[string]ChecksumAlgorithm) (parsedChecksum, error) {
+	c, err := ParseChecksum(content)
+	if err != nil {
+		return parsedChecksum{}, err
+	}
+	algorithm, ok := algorithms[c.Algorithm]
+	if !ok {
+		return parsedChecksum{algorithm: CRC32, sum: c.Sum, unknownAlgorithm: c.Algorithm, compressor: c.Compression}, nil
+	}
+	return parsedChecksum{algorithm: algorithm, sum: c.Sum, compressor: c.Compression}, nil
+}
+
+// VersionChecksum is a checksum of version data as stored on disk. When data is compressed, the checksum is
+// calculated from compressed data.
+type VersionChecksum struct {
+	Algorithm   string
+	Sum         []byte
+	Compression string // name of compressor, empty when data is not compressed
+}
+
+// Comparable returns true when both checksums were calculated the same way, i.e. versions were written using
+// the same checksum algorithm and compression.
+func (c VersionChecksum) Comparable(other VersionChecksum) bool {
+	return c.Algorithm == other.Algorithm && c.Compression == other.Compression
+}
+
+// Equal returns true when checksums are comparable and equal
+func (c VersionChecksum) Equal(other VersionChecksum) bool {
+	return c.Comparable(other) && bytes.Equal(c.Sum, other.Sum)
+}
+
+func (c VersionChecksum) String() string {
+	return strings.TrimSpace(string(FormatChecksum(c)))
+}
+
+// Checksum returns the checksum of version with a given time. Only the checksum file is read, so it is cheap
+// to call and can be used for comparing versions in different stores without reading their data.
+func (s *Store) Checksum(t time.Time) (VersionChecksum, error) {
+	checksumFile := ChecksumFileName(s.dataFilename(t))
+	content, err := readFile(s.fs, checksumFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return VersionChecksum{}, NewVersionNotFoundError(fmt.Sprintf("version %s not found", t))
+	}
+	if err != nil {
+		return VersionChecksum{}, fmt.Errorf("error reading checksum file %s: %w", checksumFile, err)
+	}
+	parsed, err := parseChecksum(content, s.checksumAlgorithms)
+	if err != nil {
+		return VersionChecksum{}, fmt.Errorf("error parsing checksum file %s: %w", checksumFile, err)
+	}
+	if parsed.unknownAlgorithm != "" {
+		return VersionChecksum{}, fmt.Errorf("unknown checksum algorithm %s in file %s", parsed.unknownAlgorithm, checksumFile)
+	}
+	return VersionChecksum{
+		Algorithm:   parsed.algorithm.Name,
+		Sum:         parsed.sum,
+		Compression: parsed.compressor,
+	}, nil
 }
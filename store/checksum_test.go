@@ -0,0 +1,311 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package store_test
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var md5Algorithm = store.ChecksumAlgorithm{
+	Name:    "md5",
+	NewHash: md5.New,
+}
+
+func TestChecksum(t *testing.T) {
+
+	t.Run("should return error for invalid algorithm", func(t *testing.T) {
+		algorithms := map[string]store.ChecksumAlgorithm{
+			"empty name":   {Name: "", NewHash: md5.New},
+			"invalid name": {Name: "md5:", NewHash: md5.New},
+			"nil NewHash":  {Name: "md5"},
+		}
+		for name, algorithm := range algorithms {
+			t.Run(name, func(t *testing.T) {
+				s, err := store.Open(tests.TempDir(t), store.Checksum(algorithm))
+				assert.Error(t, err)
+				assert.Nil(t, s)
+			})
+		}
+	})
+
+	t.Run("should read data written using given algorithm", func(t *testing.T) {
+		algorithms := []store.ChecksumAlgorithm{store.CRC32, store.CRC64, store.SHA256, store.SHA512, md5Algorithm}
+		for _, algorithm := range algorithms {
+			t.Run(algorithm.Name, func(t *testing.T) {
+				s := tests.OpenStore(t, store.Checksum(algorithm))
+				data := []byte("data")
+				tests.WriteData(t, s, data)
+				// when
+				dataRead := tests.ReadData(t, s)
+				// then
+				assert.Equal(t, data, dataRead)
+			})
+		}
+	})
+
+	t.Run("should record algorithm name in checksum file", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s, err := store.Open(dir, store.Checksum(store.SHA256))
+		require.NoError(t, err)
+		// when
+		version := tests.WriteData(t, s, []byte("data"))
+		// then
+		sum := readChecksumFile(t, dir, version)
+		assert.Equal(t, "sha256:3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7\n", sum)
+	})
+
+	t.Run("should use CRC32 by default", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s, err := store.Open(dir)
+		require.NoError(t, err)
+		// when
+		version := tests.WriteData(t, s, []byte("data"))
+		// then
+		sum := readChecksumFile(t, dir, version)
+		assert.True(t, strings.HasPrefix(sum, "crc32:"), "unexpected checksum %s", sum)
+	})
+
+	t.Run("should read versions written with previously used algorithm", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s, err := store.Open(dir, store.Checksum(store.SHA512))
+		require.NoError(t, err)
+		oldData := []byte("old")
+		oldVersion := tests.WriteData(t, s, oldData)
+		// when
+		s, err = store.Open(dir, store.Checksum(store.SHA256))
+		require.NoError(t, err)
+		tests.WriteData(t, s, []byte("new"))
+		// then
+		assert.Equal(t, oldData, tests.ReadData(t, s, store.Time(oldVersion.Time)))
+	})
+
+	t.Run("should read version with raw CRC32 checksum written by older library versions", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		data := []byte("data")
+		writeLegacyVersion(t, dir, data)
+		s, err := store.Open(dir, store.Checksum(store.SHA256))
+		require.NoError(t, err)
+		// when
+		dataRead := tests.ReadData(t, s)
+		// then
+		assert.Equal(t, data, dataRead)
+	})
+
+	t.Run("should read version written with custom algorithm after reopening the store", func(t *testing.T) {
+		// xxHash or BLAKE2 can be registered the same way
+		fnv128a := store.ChecksumAlgorithm{Name: "fnv128a", NewHash: fnv.New128a}
+		dir := tests.TempDir(t)
+		s, err := store.Open(dir, store.Checksum(fnv128a))
+		require.NoError(t, err)
+		data := []byte("data")
+		version := tests.WriteData(t, s, data)
+		// when
+		s, err = store.Open(dir, store.Checksum(fnv128a))
+		require.NoError(t, err)
+		// then
+		assert.Equal(t, data, tests.ReadData(t, s))
+		assert.True(t, strings.HasPrefix(readChecksumFile(t, dir, version), "fnv128a:"))
+	})
+
+	t.Run("should return error when version was written with unknown algorithm", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s, err := store.Open(dir, store.Checksum(md5Algorithm))
+		require.NoError(t, err)
+		tests.WriteData(t, s, []byte("data"))
+		s, err = store.Open(dir)
+		require.NoError(t, err)
+		reader, err := s.Reader()
+		require.NoError(t, err)
+		defer closeSilently(reader)
+		// when
+		err = readAllDiscarding(reader, 8)
+		// then
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "md5")
+	})
+
+	t.Run("should return error when version is corrupted", func(t *testing.T) {
+		for _, algorithm := range []store.ChecksumAlgorithm{store.CRC64, store.SHA256, store.SHA512} {
+			t.Run(algorithm.Name, func(t *testing.T) {
+				dir := tests.TempDir(t)
+				s, err := store.Open(dir, store.Checksum(algorithm))
+				require.NoError(t, err)
+				version := tests.WriteData(t, s, []byte("data"))
+				tests.CorruptFile(t, path.Join(dir, dataFilename(version)))
+				reader, err := s.Reader()
+				require.NoError(t, err)
+				defer closeSilently(reader)
+				// when
+				err = readAllDiscarding(reader, 8)
+				// then
+				assert.Error(t, err)
+			})
+		}
+	})
+}
+
+func readChecksumFile(t *testing.T, dir string, version store.Version) string {
+	sum, err := ioutil.ReadFile(path.Join(dir, dataFilename(version)+".sum"))
+	require.NoError(t, err)
+	return string(sum)
+}
+
+func writeLegacyVersion(t *testing.T, dir string, data []byte) {
+	name := path.Join(dir, dataFilename(store.Version{Time: time.Now()}))
+	require.NoError(t, ioutil.WriteFile(name, data, 0664))
+	sum := crc32.NewIEEE()
+	_, _ = sum.Write(data)
+	require.NoError(t, ioutil.WriteFile(name+".sum", sum.Sum(nil), 0664))
+}
+
+func formatSHA256(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+func dataFilename(version store.Version) string {
+	return version.Time.UTC().Format("2006-01-02T15_04_05.999999999Z") + ".data"
+}
+
+func TestStore_Checksum(t *testing.T) {
+
+	t.Run("should return error when version does not exist", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		_, err := s.Checksum(time.Now())
+		assert.True(t, store.IsVersionNotFound(err))
+	})
+
+	t.Run("should return checksum of written version", func(t *testing.T) {
+		s := tests.OpenStore(t, store.Checksum(store.SHA256))
+		data := []byte("data")
+		v := tests.WriteData(t, s, data)
+		// when
+		checksum, err := s.Checksum(v.Time)
+		// then
+		require.NoError(t, err)
+		sum := sha256.Sum256(data)
+		assert.Equal(t, store.VersionChecksum{Algorithm: "sha256", Sum: sum[:]}, checksum)
+		assert.Equal(t, fmt.Sprintf("sha256:%x", sum), checksum.String())
+	})
+
+	t.Run("should return equal checksums for the same data", func(t *testing.T) {
+		s1, s2 := tests.OpenStore(t), tests.OpenStore(t)
+		v1 := tests.WriteData(t, s1, []byte("data"))
+		v2 := tests.WriteData(t, s2, []byte("data"))
+		// when
+		checksum1, err := s1.Checksum(v1.Time)
+		require.NoError(t, err)
+		checksum2, err := s2.Checksum(v2.Time)
+		require.NoError(t, err)
+		// then
+		assert.True(t, checksum1.Equal(checksum2))
+	})
+
+	t.Run("should return checksums which are not comparable", func(t *testing.T) {
+		s1, s2 := tests.OpenStore(t), tests.OpenStore(t, store.Checksum(store.SHA256))
+		v1 := tests.WriteData(t, s1, []byte("data"))
+		v2 := tests.WriteData(t, s2, []byte("data"))
+		// when
+		checksum1, err := s1.Checksum(v1.Time)
+		require.NoError(t, err)
+		checksum2, err := s2.Checksum(v2.Time)
+		require.NoError(t, err)
+		// then
+		assert.False(t, checksum1.Comparable(checksum2))
+		assert.False(t, checksum1.Equal(checksum2))
+	})
+
+	t.Run("should return compression", func(t *testing.T) {
+		s := tests.OpenStore(t, store.Compression(store.Gzip))
+		v := tests.WriteData(t, s, []byte("data"))
+		// when
+		checksum, err := s.Checksum(v.Time)
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "gzip", checksum.Compression)
+	})
+}
+
+func TestParseChecksum(t *testing.T) {
+
+	t.Run("should parse formatted checksum", func(t *testing.T) {
+		checksums := []store.VersionChecksum{
+			{Algorithm: "sha256", Sum: []byte{1, 2, 3}},
+			{Algorithm: "crc32", Sum: []byte{4, 5, 6, 7}, Compression: "gzip"},
+		}
+		for _, checksum := range checksums {
+			t.Run(checksum.String(), func(t *testing.T) {
+				// when
+				parsed, err := store.ParseChecksum(store.FormatChecksum(checksum))
+				// then
+				require.NoError(t, err)
+				assert.Equal(t, checksum, parsed)
+			})
+		}
+	})
+
+	t.Run("should parse legacy raw CRC32 checksum", func(t *testing.T) {
+		contents := [][]byte{
+			crc32.NewIEEE().Sum([]byte{}),
+			[]byte("a:00"), // raw checksum which looks like formatted one
+		}
+		for _, content := range contents {
+			// when
+			parsed, err := store.ParseChecksum(content)
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, store.VersionChecksum{Algorithm: "crc32", Sum: content}, parsed)
+		}
+	})
+
+	t.Run("should parse checksum altered by hand", func(t *testing.T) {
+		parsed, err := store.ParseChecksum([]byte("ALTERED\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "crc32", parsed.Algorithm)
+	})
+
+	t.Run("should parse checksum of unknown algorithm", func(t *testing.T) {
+		parsed, err := store.ParseChecksum([]byte("md5:0102\n"))
+		require.NoError(t, err)
+		assert.Equal(t, store.VersionChecksum{Algorithm: "md5", Sum: []byte{1, 2}}, parsed)
+	})
+
+	t.Run("should return error for malformed checksum", func(t *testing.T) {
+		contents := map[string]string{
+			"empty":                "",
+			"no separator":         "crc32\n",
+			"empty algorithm":      ":01020304\n",
+			"invalid algorithm":    "CRC 32:01020304\n",
+			"empty sum":            "crc32:\n",
+			"not hex sum":          "crc32:xyz\n",
+			"odd hex sum":          "crc32:010\n",
+			"unknown attribute":    "crc32:01020304\nencryption:aes\n",
+			"empty compression":    "crc32:01020304\ncompression:\n",
+			"truncated legacy":     "\x01\x02\x03",
+			"too long legacy":      "\x01\x02\x03\x04\x05",
+			"corrupted algorithm":  "drc32:0102030\n",
+			"garbage after legacy": "\x01\x02\x03\x04\n",
+			"altered with garbage": "ALTERED!\n",
+		}
+		for name, content := range contents {
+			t.Run(name, func(t *testing.T) {
+				_, err := store.ParseChecksum([]byte(content))
+				assert.Error(t, err)
+			})
+		}
+	})
+}
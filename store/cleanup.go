@@ -0,0 +1,152 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"time"
+)
+
+// CleanupReport is a result of Store.Cleanup
+type CleanupReport struct {
+	// Removed contains names of removed files
+	Removed []string
+}
+
+const defaultGracePeriod = time.Hour
+
+type CleanupOption func(*CleanupOptions) error
+
+type CleanupOptions struct {
+	gracePeriod time.Duration
+	report      func(CleanupReport)
+}
+
+// GracePeriod sets the minimum age of a file to be removed. Files which are younger might belong to a write
+// which is still in progress. Default is one hour.
+func GracePeriod(d time.Duration) CleanupOption {
+	return func(o *CleanupOptions) error {
+		if d < 0 {
+			return errors.New("negative grace period")
+		}
+		o.gracePeriod = d
+		return nil
+	}
+}
+
+// ReportCleanup registers a function receiving the report. It is useful together with CleanupOnOpen, where
+// the report cannot be returned.
+func ReportCleanup(report func(CleanupReport)) CleanupOption {
+	return func(o *CleanupOptions) error {
+		o.report = report
+		return nil
+	}
+}
+
+// CleanupOnOpen runs Cleanup when the store is opened. It cannot be used together with SharedLock.
+func CleanupOnOpen(options ...CleanupOption) Option {
+	return func(s *Store) error {
+		opts, err := ApplyCleanupOptions(options)
+		if err != nil {
+			return err
+		}
+		s.cleanupOnOpen = opts
+		return nil
+	}
+}
+
+// Cleanup removes files left by interrupted writes: data files without checksum files, checksum files without data
+// files and temporary files. Only files older than grace period are removed.
+func (s *Store) Cleanup(options ...CleanupOption) (CleanupReport, error) {
+	opts, err := ApplyCleanupOptions(options)
+	if err != nil {
+		return CleanupReport{}, err
+	}
+	return s.cleanup(opts)
+}
+
+// ApplyCleanupOptions returns options which can be used by other implementations of the store to interpret
+// CleanupOption arguments
+func ApplyCleanupOptions(options []CleanupOption) (*CleanupOptions, error) {
+	opts := &CleanupOptions{
+		gracePeriod: defaultGracePeriod,
+	}
+	for _, apply := range options {
+		if apply == nil {
+			continue
+		}
+		if err := apply(opts); err != nil {
+			return nil, fmt.Errorf("error applying option: %w", err)
+		}
+	}
+	return opts, nil
+}
+
+// GracePeriod returns the minimum age of a file to be removed
+func (o *CleanupOptions) GracePeriod() time.Duration {
+	return o.gracePeriod
+}
+
+// Report passes the report to the function registered with ReportCleanup option, if any
+func (o *CleanupOptions) Report(report CleanupReport) {
+	if o.report != nil {
+		o.report(report)
+	}
+}
+
+func (s *Store) cleanup(opts *CleanupOptions) (CleanupReport, error) {
+	if err := s.checkWritable(); err != nil {
+		return CleanupReport{}, err
+	}
+
+	files, err := s.fs.ReadDir(s.dir)
+	if err != nil {
+		return CleanupReport{}, fmt.Errorf("reading dir %s failed: %w", s.dir, err)
+	}
+
+	existing := map[string]struct{}{}
+	for _, file := range files {
+		existing[file.Name()] = struct{}{}
+	}
+
+	report := CleanupReport{}
+	olderThan := time.Now().Add(-opts.gracePeriod)
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !file.ModTime().Before(olderThan) || !isIncompleteWrite(name, existing) {
+			continue
+		}
+		err = s.fs.Remove(path.Join(s.dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return report, fmt.Errorf("error removing file %s: %w", name, err)
+		}
+		report.Removed = append(report.Removed, name)
+	}
+
+	opts.Report(report)
+	return report, nil
+}
+
+// isIncompleteWrite returns true for files which will never become part of a version
+func isIncompleteWrite(name string, existing map[string]struct{}) bool {
+	switch {
+	case isTempFile(name):
+		return true
+	case isDataFile(name):
+		_, hasChecksum := existing[ChecksumFileName(name)]
+		return !hasChecksum
+	case isChecksum(name):
+		dataFile, _ := DataFileNameOf(name)
+		_, hasData := existing[dataFile]
+		return !hasData
+	default:
+		return false
+	}
+}
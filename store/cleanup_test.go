@@ -0,0 +1,111 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package store_test
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_Cleanup(t *testing.T) {
+
+	t.Run("should return error for negative grace period", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		_, err := s.Cleanup(store.GracePeriod(-1))
+		assert.Error(t, err)
+	})
+
+	t.Run("should remove old files left by interrupted writes", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s := openStore(t, dir)
+		version := tests.WriteData(t, s, []byte("data"))
+		touchOldFile(t, dir, "2021-01-01T00_00_00Z.data")
+		touchOldFile(t, dir, "2021-01-02T00_00_00Z.data.sum")
+		touchOldFile(t, dir, "2021-01-03T00_00_00Z.data.tmp")
+		// when
+		report, err := s.Cleanup()
+		// then
+		require.NoError(t, err)
+		assert.ElementsMatch(t,
+			[]string{"2021-01-01T00_00_00Z.data", "2021-01-02T00_00_00Z.data.sum", "2021-01-03T00_00_00Z.data.tmp"},
+			report.Removed)
+		assert.Equal(t, []string{dataFilename(version), dataFilename(version) + ".sum"}, filesIn(t, dir))
+	})
+
+	t.Run("should not remove files younger than grace period", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s := openStore(t, dir)
+		tests.TouchFile(t, path.Join(dir, "2021-01-01T00_00_00Z.data"))
+		tests.TouchFile(t, path.Join(dir, "2021-01-02T00_00_00Z.data.sum"))
+		// when
+		report, err := s.Cleanup(store.GracePeriod(time.Minute))
+		// then
+		require.NoError(t, err)
+		assert.Empty(t, report.Removed)
+		assert.Len(t, filesIn(t, dir), 2)
+	})
+
+	t.Run("should not remove old versions and unknown files", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s := openStore(t, dir)
+		touchOldFile(t, dir, "2021-01-01T00_00_00Z.data")
+		touchOldFile(t, dir, "2021-01-01T00_00_00Z.data.sum")
+		touchOldFile(t, dir, "notes.txt")
+		// when
+		report, err := s.Cleanup()
+		// then
+		require.NoError(t, err)
+		assert.Empty(t, report.Removed)
+		assert.Len(t, filesIn(t, dir), 3)
+	})
+
+	t.Run("should return error when store was opened with shared lock", func(t *testing.T) {
+		s := tests.OpenStore(t, store.SharedLock)
+		_, err := s.Cleanup()
+		assert.Error(t, err)
+	})
+}
+
+func TestCleanupOnOpen(t *testing.T) {
+
+	t.Run("should remove orphaned files when store is opened", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		touchOldFile(t, dir, "2021-01-01T00_00_00Z.data")
+		var report store.CleanupReport
+		// when
+		_, err := store.Open(dir, store.CleanupOnOpen(store.ReportCleanup(func(r store.CleanupReport) {
+			report = r
+		})))
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, []string{"2021-01-01T00_00_00Z.data"}, report.Removed)
+		assert.Empty(t, filesIn(t, dir))
+	})
+
+	t.Run("should fail when used with shared lock", func(t *testing.T) {
+		s, err := store.Open(tests.TempDir(t), store.CleanupOnOpen(), store.SharedLock)
+		assert.Error(t, err)
+		assert.Nil(t, s)
+	})
+
+	t.Run("should return error for invalid option", func(t *testing.T) {
+		s, err := store.Open(tests.TempDir(t), store.CleanupOnOpen(store.GracePeriod(-1)))
+		assert.Error(t, err)
+		assert.Nil(t, s)
+	})
+}
+
+func touchOldFile(t *testing.T, dir, name string) {
+	file := path.Join(dir, name)
+	tests.TouchFile(t, file)
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(file, old, old))
+}
@@ -0,0 +1,68 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package store
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Compressor compresses version data before it is written to disk and decompresses it when version is read.
+// Name is saved in the checksum file, so each version is decompressed using the compressor it was written with.
+// Checksum is always calculated for compressed (stored) bytes.
+type Compressor interface {
+	Name() string
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// Gzip compresses data using gzip with default compression level
+var Gzip Compressor = GzipLevel(gzip.DefaultCompression)
+
+// GzipLevel compresses data using gzip with a given compression level (see compress/gzip for possible values)
+func GzipLevel(level int) Compressor {
+	return gzipCompressor{level: level}
+}
+
+type gzipCompressor struct {
+	level int
+}
+
+func (g gzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (g gzipCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, g.level)
+}
+
+func (g gzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// Compression enables compression of new versions. By default data is not compressed. Versions compressed with Gzip
+// can always be read. Custom compressors (for example zstd) must be passed with Compression option each time
+// the store is opened in order to read versions compressed with them.
+func Compression(compressor Compressor) Option {
+	return func(s *Store) error {
+		if compressor == nil {
+			return errors.New("nil compressor")
+		}
+		name := compressor.Name()
+		if !checksumAlgorithmName.MatchString(name) {
+			return fmt.Errorf("invalid compressor name %q: must contain only a-z, 0-9, _ or -", name)
+		}
+		s.compressor = compressor
+		s.compressors[name] = compressor
+		return nil
+	}
+}
+
+func builtInCompressors() map[string]Compressor {
+	return map[string]Compressor{
+		Gzip.Name(): Gzip,
+	}
+}
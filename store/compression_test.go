@@ -0,0 +1,136 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package store_test
+
+import (
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type zlibCompressor struct{}
+
+func (z zlibCompressor) Name() string {
+	return "zlib"
+}
+
+func (z zlibCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zlib.NewWriter(w), nil
+}
+
+func (z zlibCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+func TestCompression(t *testing.T) {
+
+	compressibleData := []byte(strings.Repeat("data", 1000))
+
+	t.Run("should return error for nil compressor", func(t *testing.T) {
+		s, err := store.Open(tests.TempDir(t), store.Compression(nil))
+		assert.Error(t, err)
+		assert.Nil(t, s)
+	})
+
+	t.Run("should read compressed data", func(t *testing.T) {
+		compressors := []store.Compressor{store.Gzip, store.GzipLevel(9), zlibCompressor{}}
+		for _, compressor := range compressors {
+			t.Run(compressor.Name(), func(t *testing.T) {
+				s := tests.OpenStore(t, store.Compression(compressor))
+				tests.WriteData(t, s, compressibleData)
+				// when
+				dataRead := tests.ReadData(t, s)
+				// then
+				assert.Equal(t, compressibleData, dataRead)
+			})
+		}
+	})
+
+	t.Run("should store compressed data", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s, err := store.Open(dir, store.Compression(store.Gzip))
+		require.NoError(t, err)
+		// when
+		version := tests.WriteData(t, s, compressibleData)
+		// then
+		storedData, err := ioutil.ReadFile(path.Join(dir, dataFilename(version)))
+		require.NoError(t, err)
+		assert.Less(t, len(storedData), len(compressibleData))
+		assert.Equal(t, int64(len(storedData)), version.Size)
+		assert.Equal(t, version.Size, readVersions(t, s)[0].Size)
+		// and
+		sum := readChecksumFile(t, dir, version)
+		assert.True(t, strings.HasSuffix(sum, "\ncompression:gzip\n"), "unexpected checksum file %s", sum)
+	})
+
+	t.Run("should read data written with and without compression", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s, err := store.Open(dir)
+		require.NoError(t, err)
+		notCompressed := tests.WriteData(t, s, []byte("not compressed"))
+		s, err = store.Open(dir, store.Compression(store.Gzip))
+		require.NoError(t, err)
+		compressed := tests.WriteData(t, s, []byte("compressed"))
+		// when
+		s, err = store.Open(dir)
+		require.NoError(t, err)
+		// then
+		assert.Equal(t, []byte("not compressed"), tests.ReadData(t, s, store.Time(notCompressed.Time)))
+		assert.Equal(t, []byte("compressed"), tests.ReadData(t, s, store.Time(compressed.Time)))
+	})
+
+	t.Run("should return error when version was compressed with unknown compressor", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s, err := store.Open(dir, store.Compression(zlibCompressor{}))
+		require.NoError(t, err)
+		tests.WriteData(t, s, compressibleData)
+		s, err = store.Open(dir)
+		require.NoError(t, err)
+		reader, err := s.Reader()
+		require.NoError(t, err)
+		defer closeSilently(reader)
+		// when
+		_, err = io.ReadAll(reader)
+		// then
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "zlib")
+	})
+
+	t.Run("should return error when compressed version is corrupted", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s, err := store.Open(dir, store.Compression(store.Gzip))
+		require.NoError(t, err)
+		version := writeLargeData(t, s, 100, 1000)
+		tests.CorruptFile(t, path.Join(dir, dataFilename(version)))
+		reader, err := s.Reader()
+		require.NoError(t, err)
+		defer closeSilently(reader)
+		// when
+		err = readAllDiscarding(reader, 33)
+		// then
+		assert.Error(t, err)
+	})
+
+	t.Run("should verify checksum of stored bytes", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s, err := store.Open(dir, store.Compression(store.Gzip), store.Checksum(store.SHA256))
+		require.NoError(t, err)
+		version := tests.WriteData(t, s, compressibleData)
+		storedData, err := ioutil.ReadFile(path.Join(dir, dataFilename(version)))
+		require.NoError(t, err)
+		// when
+		sum := readChecksumFile(t, dir, version)
+		// then
+		expected := formatSHA256(storedData)
+		assert.True(t, strings.HasPrefix(sum, expected+"\n"), "unexpected checksum file %s", sum)
+	})
+}
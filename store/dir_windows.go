@@ -0,0 +1,12 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+//go:build windows
+// +build windows
+
+package store
+
+// syncDir is a no-op on Windows, because directory handles cannot be flushed there
+func syncDir(string) error {
+	return nil
+}
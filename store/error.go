@@ -18,6 +18,19 @@ func IsVersionAlreadyExists(err error) bool {
 	return ok
 }
 
+// IsLocked returns true when store could not be opened because its directory is locked by someone else
+func IsLocked(err error) bool {
+	target := lockedError{}
+	return errors.As(err, &target)
+}
+
+// IsChecksumMismatch returns true when version data does not match its checksum or the checksum file is malformed,
+// i.e. the version is corrupted
+func IsChecksumMismatch(err error) bool {
+	target := checksumMismatchError{}
+	return errors.As(err, &target)
+}
+
 func NewVersionNotFoundError(msg string) error {
 	return versionNotFoundError{msg: msg}
 }
@@ -38,6 +51,10 @@ func (e versionNotFoundError) Error() string {
 	return fmt.Sprintf("%s: %s", e.msg, e.cause)
 }
 
+func NewVersionAlreadyExistsError(msg string) error {
+	return versionAlreadyExistsError{msg: msg}
+}
+
 type versionAlreadyExistsError struct {
 	msg string
 }
@@ -45,3 +62,27 @@ type versionAlreadyExistsError struct {
 func (v versionAlreadyExistsError) Error() string {
 	return v.msg
 }
+
+type lockedError struct {
+	msg string
+}
+
+func (e lockedError) Error() string {
+	return e.msg
+}
+
+type unknownChecksumAlgorithmError struct {
+	msg string
+}
+
+func (e unknownChecksumAlgorithmError) Error() string {
+	return e.msg
+}
+
+type checksumMismatchError struct {
+	msg string
+}
+
+func (e checksumMismatchError) Error() string {
+	return e.msg
+}
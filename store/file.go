@@ -4,6 +4,9 @@
 package store
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"path"
 	"strings"
 	"time"
@@ -13,26 +16,73 @@ const (
 	dataFileDateFormat = "2006-01-02T15_04_05.999999999Z"
 	dataFileSuffix     = ".data"
 	checksumFileSuffix = ".sum"
+	tempFileSuffix     = ".tmp"
 )
 
 func (s *Store) dataFilename(t time.Time) string {
-	name := t.UTC().Format(dataFileDateFormat) + dataFileSuffix
-	return path.Join(s.dir, name)
+	return path.Join(s.dir, DataFileName(t))
+}
+
+// DataFileName returns name of the file keeping data of version with a given time. Together with ChecksumFileName,
+// DataFileNameOf, ParseDataFileName, FormatChecksum and ParseChecksum it can be used by stores keeping versions
+// in the same format as Store, such as s3store.
+func DataFileName(t time.Time) string {
+	return t.UTC().Format(dataFileDateFormat) + dataFileSuffix
 }
 
 func isDataFile(name string) bool {
 	return strings.HasSuffix(name, dataFileSuffix)
 }
 
-func timeFromDataFile(name string) (time.Time, error) {
-	t := name[:len(name)-len(dataFileSuffix)]
-	return time.Parse(dataFileDateFormat, t)
+// ParseDataFileName returns time of version kept in the data file with a given name
+func ParseDataFileName(name string) (time.Time, error) {
+	if !isDataFile(name) {
+		return time.Time{}, fmt.Errorf("%s is not a data file", name)
+	}
+	return time.Parse(dataFileDateFormat, strings.TrimSuffix(name, dataFileSuffix))
 }
 
 func isChecksum(name string) bool {
 	return strings.HasSuffix(name, checksumFileSuffix)
 }
 
-func checksumFileForDataFile(name string) string {
-	return name + checksumFileSuffix
+// ChecksumFileName returns name of the checksum file of a given data file
+func ChecksumFileName(dataFile string) string {
+	return dataFile + checksumFileSuffix
+}
+
+// DataFileNameOf returns name of the data file of a given checksum file. It returns false when the name is not
+// a name of checksum file.
+func DataFileNameOf(checksumFile string) (string, bool) {
+	if !isChecksum(checksumFile) {
+		return "", false
+	}
+	return strings.TrimSuffix(checksumFile, checksumFileSuffix), true
+}
+
+func isTempFile(name string) bool {
+	return strings.HasSuffix(name, tempFileSuffix)
+}
+
+func tempFileFor(name string) string {
+	return name + tempFileSuffix
+}
+
+// removeTempFiles removes temporary files left by writers interrupted by a crash. Only files modified before
+// olderThan are removed.
+func removeTempFiles(fsys FS, dir string, olderThan time.Time) error {
+	files, err := fsys.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("reading dir %s failed: %w", dir, err)
+	}
+	for _, file := range files {
+		if !isTempFile(file.Name()) || file.ModTime().After(olderThan) {
+			continue
+		}
+		name := path.Join(dir, file.Name())
+		if err = fsys.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("error removing temporary file %s: %w", name, err)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,41 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package store_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jacekolszak/deebee/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataFileName(t *testing.T) {
+
+	t.Run("should return name in UTC", func(t *testing.T) {
+		tm := time.Date(2021, 1, 2, 4, 4, 5, 6, time.FixedZone("CET", 3600))
+		// when
+		name := store.DataFileName(tm)
+		// then
+		assert.Equal(t, "2021-01-02T03_04_05.000000006Z.data", name)
+		assert.Equal(t, "2021-01-02T03_04_05.000000006Z.data.sum", store.ChecksumFileName(name))
+	})
+
+	t.Run("should parse returned name", func(t *testing.T) {
+		tm := time.Date(2021, 1, 2, 3, 4, 5, 6, time.UTC)
+		// when
+		parsed, err := store.ParseDataFileName(store.DataFileName(tm))
+		// then
+		require.NoError(t, err)
+		assert.True(t, tm.Equal(parsed))
+	})
+
+	t.Run("should return error for invalid name", func(t *testing.T) {
+		for _, name := range []string{"2021-01-02T03_04_05Z.data.sum", "invalid.data", "other"} {
+			_, err := store.ParseDataFileName(name)
+			assert.Error(t, err, name)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package store
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+)
+
+// FS is a filesystem used by Store to access files. OS is used by default, memfs package provides an in-memory
+// implementation which can be used in tests.
+//
+// Errors returned for missing and already existing files must match fs.ErrNotExist and fs.ErrExist
+// (checked with errors.Is).
+type FS interface {
+	// OpenFile works like os.OpenFile. Store uses os.O_RDONLY for reading, and os.O_WRONLY together with os.O_CREATE
+	// and either os.O_EXCL or os.O_TRUNC for writing.
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	// ReadDir returns entries of the directory sorted by name, like ioutil.ReadDir
+	ReadDir(dir string) ([]fs.FileInfo, error)
+	Stat(name string) (fs.FileInfo, error)
+	// Lstat returns information about the file without following symbolic links
+	Lstat(name string) (fs.FileInfo, error)
+	MkdirAll(dir string, perm fs.FileMode) error
+	Remove(name string) error
+	// Rename replaces newName atomically when it already exists
+	Rename(oldName, newName string) error
+	// SyncDir makes changes of directory entries (created, renamed and removed files) durable
+	SyncDir(dir string) error
+}
+
+// File is an open file returned by FS.OpenFile. *os.File implements this interface.
+type File interface {
+	io.ReadWriteCloser
+	// Name returns the name as passed to FS.OpenFile
+	Name() string
+	// Sync commits the content of the file to stable storage
+	Sync() error
+}
+
+// FileSystem sets the filesystem used to access the store directory. Default is OS.
+func FileSystem(fsys FS) Option {
+	return func(s *Store) error {
+		if fsys == nil {
+			return errors.New("nil filesystem")
+		}
+		s.fs = fsys
+		return nil
+	}
+}
+
+// OS is the filesystem of the operating system, accessed using os package
+var OS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	file, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err // avoid returning non-nil interface holding nil *os.File
+	}
+	return file, nil
+}
+
+func (osFS) ReadDir(dir string) ([]fs.FileInfo, error) {
+	return ioutil.ReadDir(dir)
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (osFS) MkdirAll(dir string, perm fs.FileMode) error {
+	return os.MkdirAll(dir, perm)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (osFS) SyncDir(dir string) error {
+	return syncDir(dir)
+}
+
+func readFile(fsys FS, name string) ([]byte, error) {
+	file, err := fsys.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return content, file.Close()
+}
@@ -0,0 +1,118 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package store_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/memfs"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSystem(t *testing.T) {
+
+	t.Run("should return error for nil filesystem", func(t *testing.T) {
+		_, err := store.Open("dir", store.FileSystem(nil))
+		assert.Error(t, err)
+	})
+
+	t.Run("should write and read versions using in-memory filesystem", func(t *testing.T) {
+		fsys := memfs.New()
+		s, err := store.Open("dir", store.FileSystem(fsys))
+		require.NoError(t, err)
+		v1 := tests.WriteData(t, s, []byte("v1"))
+		v2 := tests.WriteData(t, s, []byte("v2"))
+		// when
+		versions, err := s.Versions()
+		// then
+		require.NoError(t, err)
+		assertVersionsEqual(t, []store.Version{v1, v2}, versions)
+		assert.Equal(t, []byte("v2"), tests.ReadData(t, s))
+		files, err := fsys.ReadDir("dir")
+		require.NoError(t, err)
+		assert.Len(t, files, 4)
+	})
+
+	t.Run("should not share files between filesystems", func(t *testing.T) {
+		s1, err := store.Open("dir", store.FileSystem(memfs.New()))
+		require.NoError(t, err)
+		tests.WriteData(t, s1, []byte("data"))
+		// when
+		s2, err := store.Open("dir", store.FileSystem(memfs.New()))
+		// then
+		require.NoError(t, err)
+		versions, err := s2.Versions()
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+
+	t.Run("should delete version", func(t *testing.T) {
+		s, err := store.Open("dir", store.FileSystem(memfs.New()))
+		require.NoError(t, err)
+		v := tests.WriteData(t, s, []byte("data"))
+		// when
+		err = s.DeleteVersion(v.Time)
+		// then
+		require.NoError(t, err)
+		err = s.DeleteVersion(v.Time)
+		assert.True(t, store.IsVersionNotFound(err))
+	})
+
+	t.Run("should lock directory", func(t *testing.T) {
+		fsys := memfs.New()
+		s, err := store.Open("dir", store.FileSystem(fsys), store.ExclusiveLock)
+		require.NoError(t, err)
+		// when
+		_, err = store.Open("dir", store.FileSystem(fsys), store.ExclusiveLock)
+		// then
+		assert.True(t, store.IsLocked(err))
+		require.NoError(t, s.Close())
+		s, err = store.Open("dir", store.FileSystem(fsys), store.ExclusiveLock)
+		require.NoError(t, err)
+		assert.NoError(t, s.Close())
+	})
+
+	t.Run("should quarantine corrupted version", func(t *testing.T) {
+		fsys := memfs.New()
+		s, err := store.Open("dir", store.FileSystem(fsys))
+		require.NoError(t, err)
+		v := tests.WriteData(t, s, []byte("data"))
+		corruptMemFile(t, fsys, "dir/"+dataFilename(v))
+		// when
+		report, err := s.Verify(context.Background(), store.Quarantine)
+		// then
+		require.NoError(t, err)
+		assert.Len(t, report.Quarantined, 2)
+		quarantined, err := fsys.ReadDir("dir/quarantine")
+		require.NoError(t, err)
+		assert.Len(t, quarantined, 2)
+	})
+
+	t.Run("should open read-only store only when directory exists", func(t *testing.T) {
+		fsys := memfs.New()
+		_, err := store.OpenReadOnly("dir", store.FileSystem(fsys))
+		require.Error(t, err)
+		require.NoError(t, fsys.MkdirAll("dir", 0775))
+		// when
+		s, err := store.OpenReadOnly("dir", store.FileSystem(fsys))
+		// then
+		require.NoError(t, err)
+		versions, err := s.Versions()
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+}
+
+func corruptMemFile(t *testing.T, fsys *memfs.FileSystem, name string) {
+	file, err := fsys.OpenFile(name, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = file.Write([]byte("X"))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+}
@@ -0,0 +1,304 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	exclusiveLockFile    = "deebee.lock"
+	sharedLockFilePrefix = "deebee-shared-"
+	lockFileSuffix       = ".lock"
+	staleLockRetries     = 1
+)
+
+type lockMode int
+
+const (
+	noLock lockMode = iota
+	exclusiveLockMode
+	sharedLockMode
+)
+
+var sharedLockCounter uint64
+
+// lock is a cooperative, cross-process lock backed by files in the store directory. Each lock file contains
+// the PID and hostname of the owner, which are used to detect stale locks left by processes which are gone.
+//
+// Lock file is kept open until the lock is released. Where supported, the open file is also locked with an OS
+// advisory lock (flock), which is released by the OS when the owner dies. Stale lock can be removed only by
+// a process holding its advisory lock, so two processes cannot remove the same stale lock and then both
+// acquire the store.
+type lock struct {
+	fs     FS
+	file   string
+	handle File
+}
+
+func acquireLock(fsys FS, dir string, mode lockMode) (*lock, error) {
+	switch mode {
+	case exclusiveLockMode:
+		return acquireExclusiveLock(fsys, dir)
+	case sharedLockMode:
+		return acquireSharedLock(fsys, dir)
+	default:
+		return nil, nil
+	}
+}
+
+func acquireExclusiveLock(fsys FS, dir string) (*lock, error) {
+	name := path.Join(dir, exclusiveLockFile)
+	handle, err := createLockFile(fsys, name, staleLockRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &lock{fs: fsys, file: name, handle: handle}
+	sharedLocks, err := sharedLockFiles(fsys, dir)
+	if err != nil {
+		_ = l.release()
+		return nil, err
+	}
+	for _, sharedLock := range sharedLocks {
+		if err = removeIfStale(fsys, sharedLock); err != nil {
+			_ = l.release()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func acquireSharedLock(fsys FS, dir string) (*lock, error) {
+	n := atomic.AddUint64(&sharedLockCounter, 1)
+	filename := fmt.Sprintf("%s%d-%d-%d%s", sharedLockFilePrefix, os.Getpid(), time.Now().UnixNano(), n, lockFileSuffix)
+	name := path.Join(dir, filename)
+	handle, err := createLockFile(fsys, name, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &lock{fs: fsys, file: name, handle: handle}
+	if err := removeIfStale(fsys, path.Join(dir, exclusiveLockFile)); err != nil {
+		_ = l.release()
+		return nil, err
+	}
+	return l, nil
+}
+
+// createLockFile returns the created lock file, which is kept open (and locked with advisory lock) until
+// the lock is released
+func createLockFile(fsys FS, name string, retries int) (File, error) {
+	file, err := fsys.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0664)
+	if errors.Is(err, fs.ErrExist) && retries > 0 {
+		if err = removeIfStale(fsys, name); err != nil {
+			return nil, err
+		}
+		return createLockFile(fsys, name, retries-1)
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return nil, lockedError{msg: fmt.Sprintf("lock file %s already exists", name)}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating lock file %s: %w", name, err)
+	}
+
+	// blocking, because the file can be locked only for a moment by someone checking whether it is stale
+	if err = lockFile(file); err != nil {
+		_ = file.Close()
+		_ = fsys.Remove(name)
+		return nil, fmt.Errorf("error locking lock file %s: %w", name, err)
+	}
+	_, err = io.WriteString(file, currentLockOwner().String())
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		_ = file.Close()
+		_ = fsys.Remove(name)
+		return nil, fmt.Errorf("error writing lock file %s: %w", name, err)
+	}
+	return file, nil
+}
+
+// removeIfStale removes the lock file when its owner is no longer running. Returns lockedError when the owner
+// is still alive (or when it cannot be determined, for example because the lock was taken on a different host).
+//
+// Where advisory locks are supported, the owner holds the advisory lock until the lock is released or the owner
+// dies. Therefore, the lock file which can be locked is stale, even when its PID was reused by another process (for
+// example PID 1 in containers). The file is locked during the whole operation, so a fresh lock file cannot be even
+// moved. Elsewhere, the owner is checked using its PID. The stale file is first claimed by moving it to a unique name,
+// and its owner is checked again after the move. Thanks to that, a fresh lock file created in the meantime by another
+// process is never removed.
+func removeIfStale(fsys FS, name string) error {
+	file, err := fsys.OpenFile(name, os.O_RDONLY, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening lock file %s: %w", name, err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	locked, err := tryLockFile(file)
+	if err != nil {
+		return fmt.Errorf("error locking lock file %s: %w", name, err)
+	}
+	if !locked {
+		return lockedError{msg: fmt.Sprintf("store is locked (lock file %s is locked by another process)", name)}
+	}
+	if same, err := isSameFile(fsys, name, file); err != nil || !same {
+		return nil // lock file was already removed, and maybe created again, by someone else
+	}
+	advisoryLocked := supportsFileLocks(file)
+	if err = checkStale(fsys, name, advisoryLocked); err != nil {
+		return err
+	}
+
+	claimed := fmt.Sprintf("%s.stale-%d-%d", name, os.Getpid(), time.Now().UnixNano())
+	if err = fsys.Rename(name, claimed); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error moving stale lock file %s: %w", name, err)
+	}
+	if err = checkStale(fsys, claimed, advisoryLocked); err != nil {
+		// fresh lock file was moved - put it back
+		if renameErr := fsys.Rename(claimed, name); renameErr != nil {
+			return fmt.Errorf("error restoring lock file %s: %w", name, renameErr)
+		}
+		return err
+	}
+	if err = fsys.Remove(claimed); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error removing stale lock file %s: %w", claimed, err)
+	}
+	return nil
+}
+
+// checkStale returns lockedError when owner of the lock file is still running or cannot be determined.
+// advisoryLocked is true when the caller holds the advisory lock of the file, which proves that the owner is gone.
+func checkStale(fsys FS, name string, advisoryLocked bool) error {
+	content, err := readFile(fsys, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading lock file %s: %w", name, err)
+	}
+
+	owner, err := parseLockOwner(string(content))
+	if err != nil {
+		// also when the lock file was just created and the owner is waiting for the advisory lock
+		return lockedError{msg: fmt.Sprintf("store is locked by unknown owner (lock file %s): %s", name, err)}
+	}
+	if !owner.isStale(advisoryLocked) {
+		return lockedError{msg: fmt.Sprintf("store is locked by process %d on host %s (lock file %s)", owner.pid, owner.host, name)}
+	}
+	return nil
+}
+
+// isSameFile returns true when name still refers to the open file. It returns true when it cannot be checked,
+// because the filesystem does not support advisory locks.
+func isSameFile(fsys FS, name string, file File) (bool, error) {
+	f, ok := file.(interface{ Stat() (fs.FileInfo, error) })
+	if !ok || !supportsFileLocks(file) {
+		return true, nil
+	}
+	openStat, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	pathStat, err := fsys.Stat(name)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(openStat, pathStat), nil
+}
+
+func sharedLockFiles(fsys FS, dir string) ([]string, error) {
+	files, err := fsys.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading dir %s failed: %w", dir, err)
+	}
+	var names []string
+	for _, file := range files {
+		if isSharedLockFile(file.Name()) {
+			names = append(names, path.Join(dir, file.Name()))
+		}
+	}
+	return names, nil
+}
+
+func isSharedLockFile(name string) bool {
+	return strings.HasPrefix(name, sharedLockFilePrefix) && strings.HasSuffix(name, lockFileSuffix)
+}
+
+func (l *lock) release() error {
+	if l == nil {
+		return nil
+	}
+	// file must be closed before removing on Windows. Nobody can remove it in the meantime, because the owner is
+	// still running.
+	_ = l.handle.Close()
+	if err := l.fs.Remove(l.file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error removing lock file %s: %w", l.file, err)
+	}
+	return nil
+}
+
+type lockOwner struct {
+	pid  int
+	host string
+}
+
+func currentLockOwner() lockOwner {
+	host, _ := os.Hostname()
+	return lockOwner{
+		pid:  os.Getpid(),
+		host: host,
+	}
+}
+
+func (o lockOwner) String() string {
+	return fmt.Sprintf("%d %s\n", o.pid, o.host)
+}
+
+func parseLockOwner(s string) (lockOwner, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return lockOwner{}, errors.New("empty lock file")
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return lockOwner{}, fmt.Errorf("invalid pid %s: %w", fields[0], err)
+	}
+	owner := lockOwner{pid: pid}
+	if len(fields) > 1 {
+		owner.host = fields[1]
+	}
+	return owner, nil
+}
+
+// isStale returns true when the owner is gone. Advisory locks may not be shared between hosts on network
+// filesystems, so owner running on a different host is never considered stale.
+func (o lockOwner) isStale(advisoryLocked bool) bool {
+	host, err := os.Hostname()
+	if err != nil || host != o.host {
+		return false // process running on a different host cannot be checked
+	}
+	if advisoryLocked {
+		return true // PID could be reused by a different process
+	}
+	return !isProcessRunning(o.pid)
+}
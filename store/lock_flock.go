@@ -0,0 +1,47 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package store
+
+import "syscall"
+
+type fdFile interface {
+	Fd() uintptr
+}
+
+func supportsFileLocks(file File) bool {
+	_, ok := file.(fdFile)
+	return ok
+}
+
+// lockFile locks the file exclusively with flock, waiting until it is possible. It is a no-op for files which
+// are not backed by OS file descriptor.
+func lockFile(file File) error {
+	f, ok := file.(fdFile)
+	if !ok {
+		return nil
+	}
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// tryLockFile locks the file exclusively with flock without waiting. Returns false when the file is locked by
+// someone else. Returns true for files which are not backed by OS file descriptor.
+func tryLockFile(file File) (bool, error) {
+	f, ok := file.(fdFile)
+	if !ok {
+		return true, nil
+	}
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,32 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package store_test
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExclusiveLock_AdvisoryLocks(t *testing.T) {
+
+	t.Run("should remove stale lock with PID reused by running process", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		// lock file left by a process which had the same PID, for example PID 1 in a restarted container
+		writeLockFile(t, path.Join(dir, "deebee.lock"), os.Getpid(), hostname(t))
+		// when
+		s, err := store.Open(dir, store.ExclusiveLock)
+		// then
+		require.NoError(t, err)
+		assert.NoError(t, s.Close())
+	})
+}
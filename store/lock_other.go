@@ -0,0 +1,22 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package store
+
+// Advisory locks are not used on this system. On Windows, lock file held open by the owner cannot be moved or
+// removed by others, which gives similar guarantees.
+
+func supportsFileLocks(File) bool {
+	return false
+}
+
+func lockFile(File) error {
+	return nil
+}
+
+func tryLockFile(File) (bool, error) {
+	return true, nil
+}
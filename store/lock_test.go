@@ -0,0 +1,251 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package store_test
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const notRunningPID = 2147483646
+
+func TestExclusiveLock(t *testing.T) {
+
+	t.Run("should open store with exclusive lock", func(t *testing.T) {
+		s, err := store.Open(tests.TempDir(t), store.ExclusiveLock)
+		require.NoError(t, err)
+		assert.NoError(t, s.Close())
+	})
+
+	t.Run("should return error when store is already locked exclusively", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s := openStore(t, dir, store.ExclusiveLock)
+		defer closeSilently(s)
+		// when
+		s2, err := store.Open(dir, store.ExclusiveLock)
+		// then
+		require.Error(t, err)
+		assert.True(t, store.IsLocked(err))
+		assert.Nil(t, s2)
+	})
+
+	t.Run("should return error when store is locked with shared lock", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s := openStore(t, dir, store.SharedLock)
+		defer closeSilently(s)
+		// when
+		_, err := store.Open(dir, store.ExclusiveLock)
+		// then
+		assert.True(t, store.IsLocked(err))
+	})
+
+	t.Run("should open store again after Close", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s := openStore(t, dir, store.ExclusiveLock)
+		require.NoError(t, s.Close())
+		// when
+		s2, err := store.Open(dir, store.ExclusiveLock)
+		// then
+		require.NoError(t, err)
+		assert.NoError(t, s2.Close())
+	})
+
+	t.Run("should remove stale lock", func(t *testing.T) {
+		lockFiles := map[string]string{
+			"exclusive": "deebee.lock",
+			"shared":    "deebee-shared-1-1-1.lock",
+		}
+		for name, lockFile := range lockFiles {
+			t.Run(name, func(t *testing.T) {
+				dir := tests.TempDir(t)
+				writeLockFile(t, path.Join(dir, lockFile), notRunningPID, hostname(t))
+				// when
+				s, err := store.Open(dir, store.ExclusiveLock)
+				// then
+				require.NoError(t, err)
+				assert.NoError(t, s.Close())
+			})
+		}
+	})
+
+	t.Run("should not let other opener acquire lock while stale lock is being removed", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		writeLockFile(t, path.Join(dir, "deebee.lock"), notRunningPID, hostname(t))
+		var other *store.Store
+		fsys := &hookFS{
+			FS: store.OS,
+			beforeRemovingLock: func() {
+				// other process opens the store in the middle of removing the stale lock
+				other, _ = store.Open(dir, store.ExclusiveLock)
+			},
+		}
+		// when
+		s, err := store.Open(dir, store.ExclusiveLock, store.FileSystem(fsys))
+		// then
+		assert.True(t, (err == nil) != (other != nil), "exactly one store should acquire exclusive lock")
+		for _, opened := range []*store.Store{s, other} {
+			if opened != nil {
+				closeSilently(opened)
+			}
+		}
+	})
+
+	t.Run("should not remove lock owned by running process when filesystem does not support advisory locks", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		writeLockFile(t, path.Join(dir, "deebee.lock"), os.Getpid(), hostname(t))
+		// when
+		_, err := store.Open(dir, store.ExclusiveLock, store.FileSystem(noAdvisoryLocksFS{FS: store.OS}))
+		// then
+		assert.True(t, store.IsLocked(err))
+	})
+
+	t.Run("should not remove lock owned by process on a different host", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		writeLockFile(t, path.Join(dir, "deebee.lock"), notRunningPID, "other-"+hostname(t))
+		// when
+		_, err := store.Open(dir, store.ExclusiveLock)
+		// then
+		assert.True(t, store.IsLocked(err))
+	})
+
+	t.Run("should not lock store opened without lock option", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s := openStore(t, dir)
+		defer closeSilently(s)
+		// when
+		s2, err := store.Open(dir, store.ExclusiveLock)
+		// then
+		require.NoError(t, err)
+		assert.NoError(t, s2.Close())
+	})
+
+	t.Run("lock file should not be listed as version", func(t *testing.T) {
+		s := openStore(t, tests.TempDir(t), store.ExclusiveLock)
+		defer closeSilently(s)
+		versions, err := s.Versions()
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+}
+
+func TestSharedLock(t *testing.T) {
+
+	t.Run("should open store with many shared locks", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s1 := openStore(t, dir, store.SharedLock)
+		defer closeSilently(s1)
+		// when
+		s2, err := store.Open(dir, store.SharedLock)
+		// then
+		require.NoError(t, err)
+		assert.NoError(t, s2.Close())
+	})
+
+	t.Run("should return error when store is locked exclusively", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s := openStore(t, dir, store.ExclusiveLock)
+		defer closeSilently(s)
+		// when
+		_, err := store.Open(dir, store.SharedLock)
+		// then
+		assert.True(t, store.IsLocked(err))
+	})
+
+	t.Run("should read data written before", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		data := []byte("data")
+		tests.WriteData(t, openStore(t, dir), data)
+		s := openStore(t, dir, store.SharedLock)
+		defer closeSilently(s)
+		// when
+		dataRead := tests.ReadData(t, s)
+		// then
+		assert.Equal(t, data, dataRead)
+	})
+
+	t.Run("should not allow to write", func(t *testing.T) {
+		s := openStore(t, tests.TempDir(t), store.SharedLock)
+		defer closeSilently(s)
+		// when
+		w, err := s.Writer()
+		// then
+		assert.Error(t, err)
+		assert.Nil(t, w)
+	})
+
+	t.Run("should not allow to delete version", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		version := tests.WriteData(t, openStore(t, dir), []byte("data"))
+		s := openStore(t, dir, store.SharedLock)
+		defer closeSilently(s)
+		// when
+		err := s.DeleteVersion(version.Time)
+		// then
+		assert.Error(t, err)
+		assert.False(t, store.IsVersionNotFound(err))
+	})
+}
+
+func openStore(t *testing.T, dir string, options ...store.Option) *store.Store {
+	s, err := store.Open(dir, options...)
+	require.NoError(t, err)
+	return s
+}
+
+func writeLockFile(t *testing.T, name string, pid int, host string) {
+	content := fmt.Sprintf("%d %s\n", pid, host)
+	require.NoError(t, ioutil.WriteFile(name, []byte(content), 0664))
+}
+
+func hostname(t *testing.T) string {
+	host, err := os.Hostname()
+	require.NoError(t, err)
+	return host
+}
+
+// hookFS calls beforeRemovingLock once, before the lock file is removed or moved
+type hookFS struct {
+	store.FS
+	beforeRemovingLock func()
+	called             bool
+}
+
+func (f *hookFS) Remove(name string) error {
+	f.hook(name)
+	return f.FS.Remove(name)
+}
+
+func (f *hookFS) Rename(oldName, newName string) error {
+	f.hook(oldName)
+	return f.FS.Rename(oldName, newName)
+}
+
+func (f *hookFS) hook(name string) {
+	if path.Base(name) == "deebee.lock" && !f.called {
+		f.called = true
+		f.beforeRemovingLock()
+	}
+}
+
+// noAdvisoryLocksFS hides file descriptors of opened files, so advisory locks cannot be used
+type noAdvisoryLocksFS struct {
+	store.FS
+}
+
+func (f noAdvisoryLocksFS) OpenFile(name string, flag int, perm fs.FileMode) (store.File, error) {
+	file, err := f.FS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return struct{ store.File }{file}, nil
+}
@@ -3,7 +3,10 @@
 
 package store
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
 type Metrics struct {
 	Read  ReadMetrics
@@ -11,9 +14,20 @@ type Metrics struct {
 }
 
 type ReadMetrics struct {
-	ReaderCalls    int // Number of Store.Reader() calls
-	TotalBytesRead int
-	TotalTime      time.Duration
+	ReaderCalls      int // Number of Store.Reader() calls
+	TotalBytesRead   int
+	TotalTime        time.Duration
+	ChecksumFailures int // Number of versions which were read until the end, but data did not match the checksum
+	Errors           ReadErrors
+	Latency          Histogram // Time of reading a version - from Store.Reader() call until Reader.Close()
+}
+
+// ReadErrors contains number of errors by kind
+type ReadErrors struct {
+	VersionNotFound int // Number of Store.Reader() calls failed because version was not found
+	Open            int // Number of Store.Reader() calls failed for other reasons
+	Read            int // Number of Reader.Read() calls failed, not including checksum failures
+	Close           int // Number of Reader.Close() calls failed, not including checksum failures
 }
 
 type WriteMetrics struct {
@@ -22,4 +36,93 @@ type WriteMetrics struct {
 	Aborted           int // Number of aborted writes (when Writer.AbortAndClose was called)
 	TotalBytesWritten int
 	TotalTime         time.Duration
+	TotalSyncTime     time.Duration // Time spent on syncing data file, checksum file and directory
+	Errors            WriteErrors
+	Latency           Histogram // Time of writing a version - from Store.Writer() call until successful Writer.Close()
+	SyncLatency       Histogram // Time spent on syncing a version during Writer.Close()
+}
+
+// WriteErrors contains number of errors by kind
+type WriteErrors struct {
+	VersionAlreadyExists int // Number of writes failed because version already exists
+	Open                 int // Number of Store.Writer() calls failed for other reasons
+	Write                int // Number of Writer.Write() calls failed
+	Sync                 int // Number of Writer.Close() calls failed because files could not be synced
+	Commit               int // Number of Writer.Close() calls failed for other reasons
+}
+
+// histogramBounds are upper bounds of Histogram buckets
+var histogramBounds = [...]time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Histogram is a latency distribution. It is a value type, so copying it copies all observations.
+type Histogram struct {
+	Count  int           // Number of observations
+	Sum    time.Duration // Sum of all observations
+	counts [len(histogramBounds)]int
+}
+
+// Bucket contains number of observations less than or equal to UpperBound
+type Bucket struct {
+	UpperBound time.Duration
+	Count      int // cumulative count, includes observations from all buckets with lower UpperBound
+}
+
+// Buckets returns cumulative buckets ordered by UpperBound. Observations greater than the last UpperBound
+// are included only in Count.
+func (h Histogram) Buckets() []Bucket {
+	buckets := make([]Bucket, len(histogramBounds))
+	cumulative := 0
+	for i, bound := range histogramBounds {
+		cumulative += h.counts[i]
+		buckets[i] = Bucket{UpperBound: bound, Count: cumulative}
+	}
+	return buckets
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	for i, bound := range histogramBounds {
+		if d <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.Count++
+	h.Sum += d
+}
+
+// metricsRecorder synchronizes updates of Metrics made by many readers and writers used concurrently
+type metricsRecorder struct {
+	mutex   sync.Mutex
+	metrics Metrics
+}
+
+func (r *metricsRecorder) updateRead(update func(*ReadMetrics)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	update(&r.metrics.Read)
+}
+
+func (r *metricsRecorder) updateWrite(update func(*WriteMetrics)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	update(&r.metrics.Write)
+}
+
+func (r *metricsRecorder) snapshot() Metrics {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.metrics
 }
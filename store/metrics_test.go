@@ -4,11 +4,14 @@
 package store_test
 
 import (
+	"fmt"
+	"io"
 	"testing"
 
 	"github.com/jacekolszak/deebee/internal/tests"
 	"github.com/jacekolszak/deebee/store"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestStore_Metrics(t *testing.T) {
@@ -34,8 +37,14 @@ func TestStore_Metrics(t *testing.T) {
 				Aborted:           0,
 				TotalBytesWritten: len(data),
 				TotalTime:         metrics.TotalTime,
+				TotalSyncTime:     metrics.TotalSyncTime,
+				Latency:           metrics.Latency,
+				SyncLatency:       metrics.SyncLatency,
 			},
 			metrics)
+		assert.Equal(t, 1, metrics.Latency.Count)
+		assert.Equal(t, 1, metrics.SyncLatency.Count)
+		assert.Equal(t, metrics.TotalSyncTime, metrics.SyncLatency.Sum)
 	})
 
 	t.Run("should update metrics after aborted write", func(t *testing.T) {
@@ -75,4 +84,86 @@ func TestStore_Metrics(t *testing.T) {
 			},
 			metrics)
 	})
+
+	t.Run("should record read latency when reader is closed", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		tests.WriteData(t, s, []byte("data"))
+		// when
+		tests.ReadData(t, s)
+		// then
+		latency := s.Metrics().Read.Latency
+		assert.Equal(t, 1, latency.Count)
+		assert.True(t, latency.Sum > 0)
+	})
+
+	t.Run("should count checksum failures", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s := openStore(t, dir)
+		tests.WriteData(t, s, []byte("data"))
+		tests.CorruptFiles(t, dir)
+		reader, err := s.Reader()
+		require.NoError(t, err)
+		// when
+		_, err = io.ReadAll(reader)
+		// then
+		require.Error(t, err)
+		_ = reader.Close()
+		metrics := s.Metrics().Read
+		assert.Equal(t, 1, metrics.ChecksumFailures)
+		assert.Equal(t, store.ReadErrors{}, metrics.Errors)
+	})
+
+	t.Run("should count failed Reader calls", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		_, _ = s.Reader()
+		tests.WriteData(t, s, []byte("data"))
+		_, _ = s.Reader(func(*store.ReaderOptions) error {
+			return fmt.Errorf("error")
+		})
+		// when
+		errors := s.Metrics().Read.Errors
+		// then
+		assert.Equal(t, store.ReadErrors{VersionNotFound: 1, Open: 1}, errors)
+	})
+
+	t.Run("should count failed Writer calls", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		version := tests.WriteData(t, s, []byte("data"))
+		_, _ = s.Writer(store.WriteTime(version.Time))
+		_, _ = s.Writer(func(*store.WriterOptions) error {
+			return fmt.Errorf("error")
+		})
+		// when
+		errors := s.Metrics().Write.Errors
+		// then
+		assert.Equal(t, store.WriteErrors{VersionAlreadyExists: 1, Open: 1}, errors)
+	})
+}
+
+func TestHistogram_Buckets(t *testing.T) {
+
+	t.Run("should return empty buckets for zero value", func(t *testing.T) {
+		buckets := store.Histogram{}.Buckets()
+		require.NotEmpty(t, buckets)
+		for i, bucket := range buckets {
+			assert.Zero(t, bucket.Count)
+			if i > 0 {
+				assert.True(t, bucket.UpperBound > buckets[i-1].UpperBound)
+			}
+		}
+	})
+
+	t.Run("should return cumulative buckets", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		tests.WriteData(t, s, []byte("data"))
+		tests.WriteData(t, s, []byte("data"))
+		// when
+		buckets := s.Metrics().Write.Latency.Buckets()
+		// then
+		last := buckets[len(buckets)-1]
+		assert.LessOrEqual(t, last.Count, 2)
+		for i := 1; i < len(buckets); i++ {
+			assert.GreaterOrEqual(t, buckets[i].Count, buckets[i-1].Count)
+		}
+	})
 }
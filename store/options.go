@@ -0,0 +1,64 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// ApplyReaderOptions returns options which can be used by other implementations of the store, such as remote ones,
+// to interpret ReaderOption arguments.
+func ApplyReaderOptions(options []ReaderOption) (*ReaderOptions, error) {
+	opts := &ReaderOptions{
+		chooseVersion: func(versions []Version) (Version, error) {
+			return versions[len(versions)-1], nil
+		},
+	}
+	for _, apply := range options {
+		if apply == nil {
+			continue
+		}
+		if err := apply(opts); err != nil {
+			return nil, fmt.Errorf("error applying option: %w", err)
+		}
+	}
+	return opts, nil
+}
+
+// ChooseVersion returns the version which should be read. Versions must be sorted by time, oldest first.
+// By default, the latest version is returned.
+func (o *ReaderOptions) ChooseVersion(versions []Version) (Version, error) {
+	if len(versions) == 0 {
+		return Version{}, versionNotFoundError{msg: "no version found"}
+	}
+	return o.chooseVersion(versions)
+}
+
+// ApplyWriterOptions returns options which can be used by other implementations of the store to interpret
+// WriterOption arguments. defaultTime is used when WriteTime option was not given.
+func ApplyWriterOptions(options []WriterOption, defaultTime time.Time) (*WriterOptions, error) {
+	opts := &WriterOptions{
+		time: defaultTime,
+	}
+	for _, apply := range options {
+		if apply == nil {
+			continue
+		}
+		if err := apply(opts); err != nil {
+			return nil, fmt.Errorf("error applying option: %w", err)
+		}
+	}
+	return opts, nil
+}
+
+// Time returns the time of the version to be written
+func (o *WriterOptions) Time() time.Time {
+	return o.time
+}
+
+// Synced returns false when NoSync option was used
+func (o *WriterOptions) Synced() bool {
+	return !o.noSync
+}
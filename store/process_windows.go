@@ -0,0 +1,22 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+//go:build windows
+// +build windows
+
+package store
+
+import "os"
+
+func isProcessRunning(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	// on Windows FindProcess opens a process handle and fails when process does not exist
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = process.Release()
+	return true
+}
@@ -13,46 +13,57 @@ import (
 )
 
 func (s *Store) openReader(options []ReaderOption, areChecksumsEqual func(expected, actual []byte) bool) (Reader, error) {
-	opts := &ReaderOptions{
-		chooseVersion: func(versions []Version) (Version, error) {
-			return versions[len(versions)-1], nil
-		},
-	}
+	start := time.Now()
 
-	for _, apply := range options {
-		if apply == nil {
-			continue
-		}
-		if err := apply(opts); err != nil {
-			return nil, fmt.Errorf("error applying option: %w", err)
-		}
+	opts, err := ApplyReaderOptions(options)
+	if err != nil {
+		return nil, err
 	}
 
 	versions, err := s.Versions()
 	if err != nil {
 		return nil, fmt.Errorf("error reading versions in directory %s: %w", s.dir, err)
 	}
-	if len(versions) == 0 {
-		return nil, versionNotFoundError{msg: "no version found"}
-	}
-
-	version, err := opts.chooseVersion(versions)
+	version, err := opts.ChooseVersion(versions)
 	if err != nil {
 		return nil, err
 	}
 
+	return s.openVersionReader(version, start, areChecksumsEqual)
+}
+
+// openVersionReader opens reader for a version without listing the directory
+func (s *Store) openVersionReader(version Version, start time.Time, areChecksumsEqual func(expected, actual []byte) bool) (*reader, error) {
 	name := s.dataFilename(version.Time)
-	file, err := os.Open(name)
+	checksumFile := ChecksumFileName(name)
+	checksumContent, err := readFile(s.fs, checksumFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading checksum file %s: %w", checksumFile, err)
+	}
+	expected, err := parseChecksum(checksumContent, s.checksumAlgorithms)
+	if err != nil {
+		return nil, checksumMismatchError{msg: fmt.Sprintf("error parsing checksum file %s: %s", checksumFile, err)}
+	}
+
+	file, err := s.fs.OpenFile(name, os.O_RDONLY, 0)
 	if err != nil {
 		return nil, fmt.Errorf("error opening file %s for reading: %w", name, err)
 	}
 
 	r := &reader{
 		file:              file,
+		start:             start,
 		version:           version,
-		checksum:          newHash(),
+		checksum:          expected.algorithm.NewHash(),
+		expected:          expected,
 		areChecksumsEqual: areChecksumsEqual,
-		metrics:           &s.metrics.Read,
+		metrics:           &s.metrics,
+	}
+	if expected.compressor != "" {
+		r.compressor = s.compressors[expected.compressor]
+		if r.compressor == nil {
+			r.unknownCompressor = expected.compressor
+		}
 	}
 	return r, nil
 }
@@ -62,53 +73,124 @@ type ReaderOptions struct {
 }
 
 type reader struct {
-	file    *os.File
+	file    File
+	start   time.Time // time when Store.Reader was called
+	closed  bool
 	version Version
 
 	checksum          hash.Hash
+	expected          parsedChecksum
 	areChecksumsEqual func(expected, actual []byte) bool
 
-	metrics *ReadMetrics
+	checksumFailed bool // data was read until the end, but did not match the checksum
+
+	compressor        Compressor // nil when data is not compressed
+	unknownCompressor string
+	data              io.Reader // decompressed data, created lazily on first Read
+	decompressor      io.ReadCloser
+
+	metrics *metricsRecorder
 }
 
 func (r *reader) Read(p []byte) (int, error) {
 	defer r.addElapsedTime(time.Now())
 
-	n, err := r.file.Read(p)
+	n, err := r.read(p)
+
+	r.metrics.updateRead(func(m *ReadMetrics) {
+		m.TotalBytesRead += n
+		if err != nil && err != io.EOF && !r.checksumFailed {
+			m.Errors.Read++
+		}
+	})
+	return n, err
+}
+
+func (r *reader) read(p []byte) (int, error) {
+	if r.data == nil {
+		if err := r.openData(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.data.Read(p)
 	if err == io.EOF {
+		// decompressor might not read trailing bytes, but checksum covers the whole file
+		if _, err2 := io.Copy(ioutil.Discard, readerFunc(r.readStored)); err2 != nil {
+			return n, err2
+		}
 		if err2 := r.validateChecksum(); err2 != nil {
+			if !r.checksumFailed {
+				r.checksumFailed = true
+				r.metrics.updateRead(func(m *ReadMetrics) {
+					m.ChecksumFailures++
+				})
+			}
 			return n, err2
 		}
 	}
-	r.checksum.Write(p[:n])
+	return n, err
+}
+
+func (r *reader) openData() error {
+	if r.unknownCompressor != "" {
+		return fmt.Errorf("unknown compressor %s used for file %s", r.unknownCompressor, r.file.Name())
+	}
+	if r.compressor == nil {
+		r.data = readerFunc(r.readStored)
+		return nil
+	}
+	decompressor, err := r.compressor.NewReader(readerFunc(r.readStored))
+	if err != nil {
+		return fmt.Errorf("error creating %s decompressor for file %s: %w", r.compressor.Name(), r.file.Name(), err)
+	}
+	r.decompressor = decompressor
+	r.data = decompressor
+	return nil
+}
 
-	r.metrics.TotalBytesRead += n
+// readStored reads data from the file as-is. Read bytes are included in the checksum.
+func (r *reader) readStored(p []byte) (int, error) {
+	n, err := r.file.Read(p)
+	r.checksum.Write(p[:n])
 	return n, err
 }
 
 func (r *reader) validateChecksum() error {
 	actual := r.checksum.Sum([]byte{})
-	expected, err := r.readChecksum()
-	if err != nil {
-		return fmt.Errorf("error reading checksum: %w", err)
+	if r.areChecksumsEqual(r.expected.sum, actual) {
+		return nil
 	}
-	if !r.areChecksumsEqual(expected, actual) {
-		return fmt.Errorf("invalid checksum when reading file %s", r.file.Name())
+	if r.expected.unknownAlgorithm != "" {
+		return r.unknownAlgorithmError()
 	}
-	return nil
+	return checksumMismatchError{msg: fmt.Sprintf("invalid checksum when reading file %s", r.file.Name())}
 }
 
-func (r *reader) readChecksum() ([]byte, error) {
-	checksumFile := checksumFileForDataFile(r.file.Name())
-	return ioutil.ReadFile(checksumFile)
+func (r *reader) unknownAlgorithmError() error {
+	return unknownChecksumAlgorithmError{
+		msg: fmt.Sprintf("unknown checksum algorithm %s used for file %s", r.expected.unknownAlgorithm, r.file.Name()),
+	}
 }
 
 func (r *reader) Close() error {
 	defer r.addElapsedTime(time.Now())
 
+	if r.decompressor != nil {
+		_ = r.decompressor.Close()
+	}
 	if err := r.file.Close(); err != nil {
+		r.metrics.updateRead(func(m *ReadMetrics) {
+			m.Errors.Close++
+		})
 		return fmt.Errorf("error closing file: %w", err)
 	}
+	if !r.closed {
+		r.closed = true
+		r.metrics.updateRead(func(m *ReadMetrics) {
+			m.Latency.observe(time.Since(r.start))
+		})
+	}
 	return r.validateChecksum()
 }
 
@@ -117,5 +199,14 @@ func (r *reader) Version() Version {
 }
 
 func (r *reader) addElapsedTime(start time.Time) {
-	r.metrics.TotalTime += time.Since(start)
+	elapsed := time.Since(start)
+	r.metrics.updateRead(func(m *ReadMetrics) {
+		m.TotalTime += elapsed
+	})
+}
+
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
 }
@@ -0,0 +1,85 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// OpenReadOnly opens existing store for reading only. Nothing is written to the directory - missing directory is
+// not created and temporary files are not removed - so it can be used on read-only filesystems and mounts, and
+// while another process is writing to the same directory.
+//
+// Options related to reading, such as Checksum, Compression or NoIntegrityCheck, can be used. ExclusiveLock and
+// CleanupOnOpen return error. SharedLock can be used, but it creates a lock file in the directory.
+func OpenReadOnly(dir string, options ...Option) (*ReadOnlyStore, error) {
+	s, err := newStore(dir, options)
+	if err != nil {
+		return nil, err
+	}
+	s.readOnly = true
+
+	if s.lockMode == exclusiveLockMode {
+		return nil, errors.New("ExclusiveLock cannot be used in read-only mode")
+	}
+	if s.cleanupOnOpen != nil {
+		return nil, errors.New("CleanupOnOpen cannot be used in read-only mode")
+	}
+
+	stat, err := s.fs.Stat(dir)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, fmt.Errorf("store directory %s does not exist", dir)
+	case err != nil:
+		return nil, fmt.Errorf("stat failed for directory %s: %w", dir, err)
+	case !stat.IsDir():
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	s.lock, err = acquireLock(s.fs, dir, s.lockMode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReadOnlyStore{store: s}, nil
+}
+
+// ReadOnlyStore can be used everywhere where only reading is needed - with codec.Read, replicator.ReadLatest
+// or as a source of replication.
+type ReadOnlyStore struct {
+	store *Store
+}
+
+func (s *ReadOnlyStore) Reader(options ...ReaderOption) (Reader, error) {
+	return s.store.Reader(options...)
+}
+
+// Versions return slice sorted by time, oldest first
+func (s *ReadOnlyStore) Versions() ([]Version, error) {
+	return s.store.Versions()
+}
+
+// Checksum returns the checksum of version with a given time. See Store.Checksum.
+func (s *ReadOnlyStore) Checksum(t time.Time) (VersionChecksum, error) {
+	return s.store.Checksum(t)
+}
+
+// Verify reads all versions and validates their checksums. Quarantine option returns error. See Store.Verify.
+func (s *ReadOnlyStore) Verify(ctx context.Context, options ...VerifyOption) (VerifyReport, error) {
+	return s.store.Verify(ctx, options...)
+}
+
+// Metrics returns a snapshot of metrics. Only read metrics are updated.
+func (s *ReadOnlyStore) Metrics() Metrics {
+	return s.store.Metrics()
+}
+
+// Close releases the lock acquired by OpenReadOnly. It is a no-op when store was opened without SharedLock.
+func (s *ReadOnlyStore) Close() error {
+	return s.store.Close()
+}
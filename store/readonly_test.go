@@ -0,0 +1,129 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package store_test
+
+import (
+	"context"
+	"io"
+	"path"
+	"testing"
+
+	"github.com/jacekolszak/deebee/codec"
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenReadOnly(t *testing.T) {
+
+	t.Run("should return error when dir is empty", func(t *testing.T) {
+		s, err := store.OpenReadOnly("")
+		assert.Error(t, err)
+		assert.Nil(t, s)
+	})
+
+	t.Run("should not create missing directory", func(t *testing.T) {
+		dir := path.Join(tests.TempDir(t), "missing")
+		// when
+		s, err := store.OpenReadOnly(dir)
+		// then
+		assert.Error(t, err)
+		assert.Nil(t, s)
+		assert.NoDirExists(t, dir)
+	})
+
+	t.Run("should return error when path is a file", func(t *testing.T) {
+		file := path.Join(tests.TempDir(t), "file")
+		tests.TouchFile(t, file)
+		// when
+		s, err := store.OpenReadOnly(file)
+		// then
+		assert.Error(t, err)
+		assert.Nil(t, s)
+	})
+
+	t.Run("should return error for options modifying the directory", func(t *testing.T) {
+		options := map[string]store.Option{
+			"ExclusiveLock": store.ExclusiveLock,
+			"CleanupOnOpen": store.CleanupOnOpen(),
+		}
+		for name, option := range options {
+			t.Run(name, func(t *testing.T) {
+				s, err := store.OpenReadOnly(tests.TempDir(t), option)
+				assert.Error(t, err)
+				assert.Nil(t, s)
+			})
+		}
+	})
+
+	t.Run("should not modify the directory", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		tests.WriteData(t, openStore(t, dir), []byte("data"))
+		tests.TouchFile(t, path.Join(dir, "2021-01-01T00_00_00Z.data.tmp"))
+		files := filesIn(t, dir)
+		// when
+		s, err := store.OpenReadOnly(dir)
+		// then
+		require.NoError(t, err)
+		_, err = s.Versions()
+		require.NoError(t, err)
+		_, err = s.Verify(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, s.Close())
+		assert.Equal(t, files, filesIn(t, dir))
+	})
+
+	t.Run("should read versions written by Store", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		v1 := tests.WriteData(t, openStore(t, dir), []byte("v1"))
+		v2 := tests.WriteData(t, openStore(t, dir), []byte("v2"))
+		s, err := store.OpenReadOnly(dir)
+		require.NoError(t, err)
+		// when
+		versions, err := s.Versions()
+		// then
+		require.NoError(t, err)
+		assertVersionsEqual(t, []store.Version{v1, v2}, versions)
+		assert.Equal(t, []byte("v2"), readAll(t, s))
+		assert.Equal(t, []byte("v1"), readAll(t, s, store.Time(v1.Time)))
+		assert.Equal(t, 2, s.Metrics().Read.ReaderCalls)
+	})
+
+	t.Run("should not quarantine corrupted versions", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		v := tests.WriteData(t, openStore(t, dir), []byte("data"))
+		tests.CorruptFile(t, path.Join(dir, dataFilename(v)))
+		s, err := store.OpenReadOnly(dir)
+		require.NoError(t, err)
+		// when
+		_, err = s.Verify(context.Background(), store.Quarantine)
+		// then
+		assert.Error(t, err)
+		assert.FileExists(t, path.Join(dir, dataFilename(v)))
+	})
+
+	t.Run("should fail when directory is locked exclusively and SharedLock is used", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		openStore(t, dir, store.ExclusiveLock)
+		// when
+		s, err := store.OpenReadOnly(dir, store.SharedLock)
+		// then
+		assert.True(t, store.IsLocked(err))
+		assert.Nil(t, s)
+	})
+
+	t.Run("should be usable as codec.ReadOnlyStore", func(t *testing.T) {
+		var _ codec.ReadOnlyStore = &store.ReadOnlyStore{}
+	})
+}
+
+func readAll(t *testing.T, s *store.ReadOnlyStore, options ...store.ReaderOption) []byte {
+	reader, err := s.Reader(options...)
+	require.NoError(t, err)
+	defer closeSilently(reader)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return data
+}
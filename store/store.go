@@ -8,21 +8,75 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"os"
+	"io/fs"
+	"sync"
 	"time"
 )
 
+// Open opens the store in a given directory. Temporary files left by writes interrupted by a crash are removed,
+// unless NoTempFilesCleanup option was used. When the store is opened with ExclusiveLock, nobody else can write
+// to the directory, so all temporary files are removed. Otherwise, only files older than one hour are removed,
+// because younger ones might belong to a write still in progress in another process. Nothing is removed when
+// the store is opened with SharedLock. Use Cleanup or CleanupOnOpen to remove other files left by interrupted writes.
 func Open(dir string, options ...Option) (*Store, error) {
+	s, err := newStore(dir, options)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := s.fs.Lstat(dir)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if s.failWhenMissingDir {
+			return nil, fmt.Errorf("store directory %s does not exist", dir)
+		}
+		if mkdirErr := s.fs.MkdirAll(dir, 0775); mkdirErr != nil {
+			return nil, fmt.Errorf("mkdir failed for directory %s: %w", dir, mkdirErr)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("lstat failed for directory %s: %w", dir, err)
+	case !stat.IsDir():
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	s.lock, err = acquireLock(s.fs, dir, s.lockMode)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.noTempFilesCleanup && s.lockMode != sharedLockMode {
+		gracePeriod := defaultGracePeriod
+		if s.lockMode == exclusiveLockMode {
+			gracePeriod = 0
+		}
+		if err = removeTempFiles(s.fs, dir, time.Now().Add(-gracePeriod)); err != nil {
+			_ = s.lock.release()
+			return nil, err
+		}
+	}
+
+	if s.cleanupOnOpen != nil {
+		if _, err = s.cleanup(s.cleanupOnOpen); err != nil {
+			_ = s.lock.release()
+			return nil, fmt.Errorf("cleanup failed: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+func newStore(dir string, options []Option) (*Store, error) {
 	if dir == "" {
 		return nil, errors.New("dir is empty: must be a valid directory path")
 	}
 
 	s := &Store{
-		dir: dir,
-		areChecksumsEqual: func(expected, actual []byte) bool {
-			return bytes.Equal(expected, actual) ||
-				string(expected) == "ALTERED" || string(expected) == "ALTERED\n" || string(expected) == "ALTERED\r\n"
-		},
+		dir:                dir,
+		fs:                 OS,
+		checksumAlgorithm:  CRC32,
+		checksumAlgorithms: builtInChecksumAlgorithms(),
+		compressors:        builtInCompressors(),
+		areChecksumsEqual:  areChecksumsEqual,
 	}
 
 	for _, apply := range options {
@@ -33,25 +87,16 @@ func Open(dir string, options ...Option) (*Store, error) {
 			return nil, fmt.Errorf("error applying option: %w", err)
 		}
 	}
-
-	stat, err := os.Lstat(dir)
-	switch {
-	case os.IsNotExist(err):
-		if s.failWhenMissingDir {
-			return nil, fmt.Errorf("store directory %s does not exist", dir)
-		}
-		if mkdirErr := os.MkdirAll(dir, 0775); mkdirErr != nil {
-			return nil, fmt.Errorf("mkdir failed for directory %s: %w", dir, mkdirErr)
-		}
-	case err != nil:
-		return nil, fmt.Errorf("lstat failed for directory %s: %w", dir, err)
-	case !stat.IsDir():
-		return nil, fmt.Errorf("%s is not a directory", dir)
-	}
-
 	return s, nil
 }
 
+// areChecksumsEqual is the default checksum comparison. Checksum can be replaced by "ALTERED" when data was
+// updated by hand.
+func areChecksumsEqual(expected, actual []byte) bool {
+	return bytes.Equal(expected, actual) ||
+		string(expected) == "ALTERED" || string(expected) == "ALTERED\n" || string(expected) == "ALTERED\r\n"
+}
+
 type Option func(s *Store) error
 
 var FailWhenMissingDir Option = func(s *Store) error {
@@ -66,18 +111,70 @@ var NoIntegrityCheck Option = func(s *Store) error {
 	return nil
 }
 
+// NoTempFilesCleanup disables removing temporary files left by interrupted writes on Open
+var NoTempFilesCleanup Option = func(s *Store) error {
+	s.noTempFilesCleanup = true
+	return nil
+}
+
+// ExclusiveLock locks the store directory for exclusive use by this Store instance. Open will fail when directory
+// is already locked by another Store, opened either with ExclusiveLock or SharedLock.
+//
+// Locks are cooperative: stores opened without a lock option do not check them at all. Lock owned by a process
+// which is no longer running (on the same host) is considered stale and is removed. Lock is released by Store.Close.
+var ExclusiveLock Option = func(s *Store) error {
+	s.lockMode = exclusiveLockMode
+	return nil
+}
+
+// SharedLock locks the store directory for reading. Many stores can hold a shared lock at the same time, but
+// Open will fail when directory is locked exclusively. Store opened with SharedLock is read-only - Writer and
+// DeleteVersion return error.
+var SharedLock Option = func(s *Store) error {
+	s.lockMode = sharedLockMode
+	return nil
+}
+
+// Store is safe for concurrent use by multiple goroutines. Returned Reader and Writer are not though - each instance
+// should be used by a single goroutine at a time.
 type Store struct {
 	failWhenMissingDir bool
 	areChecksumsEqual  func(expected, actual []byte) bool
+	checksumAlgorithm  ChecksumAlgorithm            // used for writing
+	checksumAlgorithms map[string]ChecksumAlgorithm // used for reading
+	compressor         Compressor                   // used for writing, nil when data is not compressed
+	compressors        map[string]Compressor        // used for reading
 	dir                string
-	lastVersionTime    time.Time
-	metrics            Metrics
+	fs                 FS
+	lockMode           lockMode
+	readOnly           bool
+	cleanupOnOpen      *CleanupOptions // nil when cleanup should not be run on Open
+	noTempFilesCleanup bool
+	metrics            metricsRecorder
+	watchers           watchers
+
+	mutex           sync.Mutex // guards fields below
+	lastVersionTime time.Time
+	lock            *lock
 }
 
 func (s *Store) Reader(options ...ReaderOption) (Reader, error) {
-	s.metrics.Read.ReaderCalls++
+	s.metrics.updateRead(func(m *ReadMetrics) {
+		m.ReaderCalls++
+	})
 
-	return s.openReader(options, s.areChecksumsEqual)
+	reader, err := s.openReader(options, s.areChecksumsEqual)
+	if err != nil {
+		s.metrics.updateRead(func(m *ReadMetrics) {
+			if IsVersionNotFound(err) {
+				m.Errors.VersionNotFound++
+			} else {
+				m.Errors.Open++
+			}
+		})
+		return nil, err
+	}
+	return reader, nil
 }
 
 type ReaderOption func(*ReaderOptions) error
@@ -102,16 +199,29 @@ type Reader interface {
 }
 
 func (s *Store) Writer(options ...WriterOption) (Writer, error) {
-	s.metrics.Write.WriterCalls++
+	s.metrics.updateWrite(func(m *WriteMetrics) {
+		m.WriterCalls++
+	})
 
-	return s.openWriter(options)
+	writer, err := s.openWriter(options)
+	if err != nil {
+		s.metrics.updateWrite(func(m *WriteMetrics) {
+			if IsVersionAlreadyExists(err) {
+				m.Errors.VersionAlreadyExists++
+			} else {
+				m.Errors.Open++
+			}
+		})
+		return nil, err
+	}
+	return writer, nil
 }
 
 type WriterOption func(*WriterOptions) error
 
 type WriterOptions struct {
-	time time.Time
-	sync func(*os.File) error
+	time   time.Time
+	noSync bool
 }
 
 // WriteTime is not named Time to avoid name conflict with ReaderOption
@@ -123,15 +233,14 @@ func WriteTime(t time.Time) WriterOption {
 }
 
 var NoSync WriterOption = func(o *WriterOptions) error {
-	o.sync = func(file *os.File) error {
-		return nil
-	}
+	o.noSync = true
 	return nil
 }
 
 type Writer interface {
 	io.Writer
-	// Close must be called to make version readable
+	// Close must be called to make version readable. Data is synced to disk and then atomically made visible,
+	// so version is either available completely or not at all, even after a crash.
 	Close() error
 	Version() Version
 	// AbortAndClose aborts writing version. Version will not be available to read.
@@ -146,16 +255,21 @@ func (s *Store) Versions() ([]Version, error) {
 type Version struct {
 	// Time uniquely identifies version
 	Time time.Time
+	// Size of data stored on disk. When Compression is used, this is the size of compressed data.
 	Size int64
 }
 
 func (s *Store) DeleteVersion(t time.Time) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+
 	dataFile := s.dataFilename(t)
-	checksumFile := checksumFileForDataFile(dataFile)
+	checksumFile := ChecksumFileName(dataFile)
 
 	for _, file := range []string{dataFile, checksumFile} {
-		err := os.Remove(file)
-		if os.IsNotExist(err) {
+		err := s.fs.Remove(file)
+		if errors.Is(err, fs.ErrNotExist) {
 			return NewVersionNotFoundError(fmt.Sprintf("version %s does not exist", t))
 		}
 		if err != nil {
@@ -165,6 +279,28 @@ func (s *Store) DeleteVersion(t time.Time) error {
 	return nil
 }
 
+func (s *Store) checkWritable() error {
+	switch {
+	case s.readOnly:
+		return fmt.Errorf("store %s opened in read-only mode", s.dir)
+	case s.lockMode == sharedLockMode:
+		return fmt.Errorf("store %s opened with shared lock is read-only", s.dir)
+	default:
+		return nil
+	}
+}
+
+// Metrics returns a snapshot of metrics. It can be safely called while other goroutines are reading and writing.
 func (s *Store) Metrics() Metrics {
-	return s.metrics
+	return s.metrics.snapshot()
+}
+
+// Close releases the lock acquired by Open. It is a no-op when store was opened without a lock option.
+func (s *Store) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.lock.release()
+	s.lock = nil
+	return err
 }
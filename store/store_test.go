@@ -7,6 +7,7 @@ import (
 	"errors"
 	"io"
 	"path"
+	"sync"
 	"testing"
 
 	"github.com/jacekolszak/deebee/internal/tests"
@@ -54,6 +55,51 @@ func TestOpen(t *testing.T) {
 		assert.Nil(t, s)
 	})
 
+	t.Run("should remove temporary files left by interrupted writes when store is locked exclusively", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		tests.TouchFile(t, path.Join(dir, "2021-01-01T00_00_00Z.data.tmp"))
+		tests.TouchFile(t, path.Join(dir, "2021-01-01T00_00_00Z.data.sum.tmp"))
+		// when
+		s, err := store.Open(dir, store.ExclusiveLock)
+		// then
+		require.NoError(t, err)
+		require.NoError(t, s.Close())
+		assert.Empty(t, filesIn(t, dir))
+	})
+
+	t.Run("should remove only old temporary files when store is not locked", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		touchOldFile(t, dir, "2021-01-01T00_00_00Z.data.tmp")
+		tests.TouchFile(t, path.Join(dir, "2021-01-01T00_00_01Z.data.tmp"))
+		// when
+		_, err := store.Open(dir)
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, []string{"2021-01-01T00_00_01Z.data.tmp"}, filesIn(t, dir))
+	})
+
+	t.Run("should not remove temporary files when store is opened with shared lock", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		touchOldFile(t, dir, "2021-01-01T00_00_00Z.data.tmp")
+		// when
+		s, err := store.Open(dir, store.SharedLock)
+		// then
+		require.NoError(t, err)
+		require.NoError(t, s.Close())
+		assert.Len(t, filesIn(t, dir), 1)
+	})
+
+	t.Run("should not remove temporary files when NoTempFilesCleanup option was used", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		touchOldFile(t, dir, "2021-01-01T00_00_00Z.data.tmp")
+		// when
+		s, err := store.Open(dir, store.ExclusiveLock, store.NoTempFilesCleanup)
+		// then
+		require.NoError(t, err)
+		require.NoError(t, s.Close())
+		assert.Len(t, filesIn(t, dir), 1)
+	})
+
 	t.Run("should accept nil option", func(t *testing.T) {
 		s, err := store.Open(tests.TempDir(t), nil)
 		require.NoError(t, err)
@@ -189,6 +235,69 @@ func TestReadAfterWrite(t *testing.T) {
 	})
 }
 
+func TestStore_ConcurrentUse(t *testing.T) {
+
+	const goroutines = 10
+
+	t.Run("should write versions in parallel", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		// when
+		runInParallel(goroutines, func() {
+			tests.WriteData(t, s, []byte("data"))
+		})
+		// then
+		versions, err := s.Versions()
+		require.NoError(t, err)
+		assert.Len(t, versions, goroutines)
+		metrics := s.Metrics().Write
+		assert.Equal(t, goroutines, metrics.WriterCalls)
+		assert.Equal(t, goroutines, metrics.Successful)
+		assert.Equal(t, goroutines*len("data"), metrics.TotalBytesWritten)
+	})
+
+	t.Run("should read versions in parallel", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		data := []byte("data")
+		tests.WriteData(t, s, data)
+		// when
+		runInParallel(goroutines, func() {
+			assert.Equal(t, data, tests.ReadData(t, s))
+		})
+		// then
+		metrics := s.Metrics().Read
+		assert.Equal(t, goroutines, metrics.ReaderCalls)
+		assert.Equal(t, goroutines*len(data), metrics.TotalBytesRead)
+	})
+
+	t.Run("should write, read, delete versions and get metrics in parallel", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		initial := tests.WriteData(t, s, []byte("initial"))
+		// when
+		runInParallel(goroutines, func() {
+			version := tests.WriteData(t, s, []byte("data"))
+			_ = s.Metrics()
+			assert.Equal(t, []byte("initial"), tests.ReadData(t, s, store.Time(initial.Time)))
+			assert.NoError(t, s.DeleteVersion(version.Time))
+		})
+		// then
+		versions, err := s.Versions()
+		require.NoError(t, err)
+		assert.Len(t, versions, 1)
+	})
+}
+
+func runInParallel(goroutines int, f func()) {
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for i := 0; i < goroutines; i++ {
+		go func() {
+			defer wg.Done()
+			f()
+		}()
+	}
+	wg.Wait()
+}
+
 func assertNotCorrupted(t *testing.T, reader store.Reader) {
 	err1 := readAllDiscarding(reader, 8)
 	err2 := reader.Close()
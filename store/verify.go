@@ -0,0 +1,220 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+
+	"github.com/jacekolszak/deebee/throttle"
+)
+
+// quarantineDir is a subdirectory of the store directory where corrupted versions are moved
+const quarantineDir = "quarantine"
+
+// VerifyReport is a result of Store.Verify
+type VerifyReport struct {
+	// Verified contains versions which data matches the checksum
+	Verified []Version
+	// Corrupted contains versions which data does not match the checksum or which checksum file is malformed
+	Corrupted []CorruptedVersion
+	// Unverifiable contains versions which could not be verified, because they were written with checksum algorithm
+	// unknown to the store (use Checksum option to pass the algorithm) or because of other errors, such as I/O or
+	// permission errors. Their data might be intact, therefore they are never quarantined.
+	Unverifiable []CorruptedVersion
+	// OrphanedDataFiles contains names of data files without the checksum file. Such files are not visible
+	// as versions.
+	OrphanedDataFiles []string
+	// StrayChecksumFiles contains names of checksum files without the data file
+	StrayChecksumFiles []string
+	// UnparsableFiles contains names of data files which time could not be parsed
+	UnparsableFiles []string
+	// Quarantined contains names of files moved to the quarantine subdirectory
+	Quarantined []string
+}
+
+type CorruptedVersion struct {
+	Version Version
+	Err     error
+}
+
+// OK returns true when no problems were found
+func (r VerifyReport) OK() bool {
+	return len(r.Corrupted) == 0 &&
+		len(r.Unverifiable) == 0 &&
+		len(r.OrphanedDataFiles) == 0 &&
+		len(r.StrayChecksumFiles) == 0 &&
+		len(r.UnparsableFiles) == 0
+}
+
+type VerifyOption func(*VerifyOptions) error
+
+type VerifyOptions struct {
+	quarantine bool
+	limiter    *throttle.Limiter
+}
+
+// Quarantine moves data and checksum files of corrupted versions into "quarantine" subdirectory of the store
+// directory. Quarantined versions are no longer visible, but they can be inspected and restored by hand.
+var Quarantine VerifyOption = func(o *VerifyOptions) error {
+	o.quarantine = true
+	return nil
+}
+
+// VerifyThrottle limits the rate of reading versions, so verification does not starve the application
+func VerifyThrottle(limiter *throttle.Limiter) VerifyOption {
+	return func(o *VerifyOptions) error {
+		if limiter == nil {
+			return errors.New("nil limiter")
+		}
+		o.limiter = limiter
+		return nil
+	}
+}
+
+// Verify reads all versions and validates their checksums. Stored bytes are validated without decompressing,
+// so versions compressed with a compressor unknown to the store are verified as well. Verify also looks for files
+// which are not part of any version. Verify stops when context is cancelled, returning report for the files checked
+// so far along with the context error.
+//
+// Verify ignores NoIntegrityCheck option - data is always validated.
+func (s *Store) Verify(ctx context.Context, options ...VerifyOption) (VerifyReport, error) {
+	opts := &VerifyOptions{}
+	for _, apply := range options {
+		if apply == nil {
+			continue
+		}
+		if err := apply(opts); err != nil {
+			return VerifyReport{}, fmt.Errorf("error applying option: %w", err)
+		}
+	}
+	if opts.quarantine {
+		if err := s.checkWritable(); err != nil {
+			return VerifyReport{}, err
+		}
+	}
+
+	files, err := s.fs.ReadDir(s.dir)
+	if err != nil {
+		return VerifyReport{}, fmt.Errorf("reading dir %s failed: %w", s.dir, err)
+	}
+
+	report := VerifyReport{}
+	dataFiles := map[string]struct{}{}
+	checksums := checksumSet(files)
+	var versions []Version
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !isDataFile(name) {
+			continue
+		}
+		dataFiles[name] = struct{}{}
+		t, err := ParseDataFileName(name)
+		if err != nil {
+			report.UnparsableFiles = append(report.UnparsableFiles, name)
+			continue
+		}
+		if _, hasChecksum := checksums[ChecksumFileName(name)]; !hasChecksum {
+			report.OrphanedDataFiles = append(report.OrphanedDataFiles, name)
+			continue
+		}
+		versions = append(versions, Version{Time: t, Size: file.Size()})
+	}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !isChecksum(name) {
+			continue
+		}
+		if _, hasData := dataFiles[name[:len(name)-len(checksumFileSuffix)]]; !hasData {
+			report.StrayChecksumFiles = append(report.StrayChecksumFiles, name)
+		}
+	}
+
+	for _, version := range versions {
+		if err = ctx.Err(); err != nil {
+			return report, err
+		}
+		err = s.verifyVersion(ctx, version, opts.limiter)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return report, ctxErr
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // version was deleted in the meantime
+		}
+		if err == nil {
+			report.Verified = append(report.Verified, version)
+			continue
+		}
+		if !IsChecksumMismatch(err) {
+			// for example unknown checksum algorithm, I/O or permission error
+			report.Unverifiable = append(report.Unverifiable, CorruptedVersion{Version: version, Err: err})
+			continue
+		}
+		report.Corrupted = append(report.Corrupted, CorruptedVersion{Version: version, Err: err})
+		if opts.quarantine {
+			quarantined, err := s.quarantine(version)
+			report.Quarantined = append(report.Quarantined, quarantined...)
+			if err != nil {
+				return report, err
+			}
+		}
+	}
+	return report, nil
+}
+
+// verifyVersion validates the checksum of stored bytes. Data is not decompressed.
+func (s *Store) verifyVersion(ctx context.Context, version Version, limiter *throttle.Limiter) error {
+	r, err := s.openVersionReader(version, time.Now(), areChecksumsEqual)
+	if err != nil {
+		return err
+	}
+	if r.expected.unknownAlgorithm != "" {
+		_ = r.file.Close()
+		return r.unknownAlgorithmError()
+	}
+	var data io.Reader = readerFunc(r.readStored)
+	if limiter != nil {
+		data = throttle.NewReader(ctx, data, limiter)
+	}
+	block := make([]byte, 32*1024)
+	for {
+		if err = ctx.Err(); err != nil {
+			_ = r.Close()
+			return err
+		}
+		_, err = data.Read(block)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			_ = r.Close()
+			return err
+		}
+	}
+	return r.Close()
+}
+
+// quarantine moves version files into quarantine directory. Checksum file is moved first, so the version
+// disappears immediately. Names of moved files are returned.
+func (s *Store) quarantine(version Version) ([]string, error) {
+	dir := path.Join(s.dir, quarantineDir)
+	if err := s.fs.MkdirAll(dir, 0775); err != nil {
+		return nil, fmt.Errorf("error creating quarantine directory %s: %w", dir, err)
+	}
+	dataFile := s.dataFilename(version.Time)
+	var moved []string
+	for _, file := range []string{ChecksumFileName(dataFile), dataFile} {
+		name := path.Base(file)
+		if err := s.fs.Rename(file, path.Join(dir, name)); err != nil {
+			return moved, fmt.Errorf("error moving file %s to quarantine: %w", file, err)
+		}
+		moved = append(moved, name)
+	}
+	return moved, nil
+}
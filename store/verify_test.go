@@ -0,0 +1,258 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package store_test
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/jacekolszak/deebee/store"
+	"github.com/jacekolszak/deebee/throttle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_Verify(t *testing.T) {
+
+	t.Run("should return empty report for empty store", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		// when
+		report, err := s.Verify(context.Background())
+		// then
+		require.NoError(t, err)
+		assert.True(t, report.OK())
+		assert.Empty(t, report.Verified)
+	})
+
+	t.Run("should verify all versions", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		v1 := tests.WriteData(t, s, []byte("v1"))
+		v2 := tests.WriteData(t, s, []byte("v2"))
+		// when
+		report, err := s.Verify(context.Background())
+		// then
+		require.NoError(t, err)
+		assert.True(t, report.OK())
+		assertVersionsEqual(t, []store.Version{v1, v2}, report.Verified)
+	})
+
+	t.Run("should return error for nil limiter", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		// when
+		_, err := s.Verify(context.Background(), store.VerifyThrottle(nil))
+		// then
+		assert.Error(t, err)
+	})
+
+	t.Run("should verify all versions with limited throughput", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		v := tests.WriteData(t, s, make([]byte, 600))
+		limiter, err := throttle.NewLimiter(10000, 100)
+		require.NoError(t, err)
+		// when
+		report, err := s.Verify(context.Background(), store.VerifyThrottle(limiter))
+		// then
+		require.NoError(t, err)
+		assertVersionsEqual(t, []store.Version{v}, report.Verified)
+	})
+
+	t.Run("should report corrupted version", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s := openStore(t, dir)
+		v1 := tests.WriteData(t, s, []byte("v1"))
+		v2 := tests.WriteData(t, s, []byte("v2"))
+		tests.CorruptFile(t, path.Join(dir, dataFilename(v1)))
+		// when
+		report, err := s.Verify(context.Background())
+		// then
+		require.NoError(t, err)
+		assert.False(t, report.OK())
+		assertVersionsEqual(t, []store.Version{v2}, report.Verified)
+		require.Len(t, report.Corrupted, 1)
+		assertVersionsEqual(t, []store.Version{v1}, []store.Version{report.Corrupted[0].Version})
+		assert.Error(t, report.Corrupted[0].Err)
+		assert.Empty(t, report.Quarantined)
+	})
+
+	t.Run("should report corrupted version even when store was opened with NoIntegrityCheck", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s := openStore(t, dir, store.NoIntegrityCheck)
+		v := tests.WriteData(t, s, []byte("data"))
+		tests.CorruptFile(t, path.Join(dir, dataFilename(v)))
+		// when
+		report, err := s.Verify(context.Background())
+		// then
+		require.NoError(t, err)
+		assert.Len(t, report.Corrupted, 1)
+	})
+
+	t.Run("should report files not belonging to any version", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s := openStore(t, dir)
+		tests.WriteData(t, s, []byte("data"))
+		tests.TouchFile(t, path.Join(dir, "2021-01-01T00_00_00Z.data"))
+		tests.TouchFile(t, path.Join(dir, "2021-01-02T00_00_00Z.data.sum"))
+		tests.TouchFile(t, path.Join(dir, "invalid.data"))
+		tests.TouchFile(t, path.Join(dir, "invalid.data.sum"))
+		tests.TouchFile(t, path.Join(dir, "other-file"))
+		// when
+		report, err := s.Verify(context.Background())
+		// then
+		require.NoError(t, err)
+		assert.False(t, report.OK())
+		assert.Len(t, report.Verified, 1)
+		assert.Equal(t, []string{"2021-01-01T00_00_00Z.data"}, report.OrphanedDataFiles)
+		assert.Equal(t, []string{"2021-01-02T00_00_00Z.data.sum"}, report.StrayChecksumFiles)
+		assert.Equal(t, []string{"invalid.data"}, report.UnparsableFiles)
+	})
+
+	t.Run("should quarantine corrupted version", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s := openStore(t, dir)
+		v1 := tests.WriteData(t, s, []byte("v1"))
+		v2 := tests.WriteData(t, s, []byte("v2"))
+		tests.CorruptFile(t, path.Join(dir, dataFilename(v1)))
+		// when
+		report, err := s.Verify(context.Background(), store.Quarantine)
+		// then
+		require.NoError(t, err)
+		expectedFiles := []string{dataFilename(v1) + ".sum", dataFilename(v1)}
+		assert.Equal(t, expectedFiles, report.Quarantined)
+		for _, file := range expectedFiles {
+			assert.FileExists(t, path.Join(dir, "quarantine", file))
+		}
+		versions, err := s.Versions()
+		require.NoError(t, err)
+		assertVersionsEqual(t, []store.Version{v2}, versions)
+	})
+
+	t.Run("should quarantine version with malformed checksum file", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s := openStore(t, dir)
+		v := tests.WriteData(t, s, []byte("data"))
+		require.NoError(t, os.WriteFile(path.Join(dir, dataFilename(v)+".sum"), []byte("garbage"), 0664))
+		// when
+		report, err := s.Verify(context.Background(), store.Quarantine)
+		// then
+		require.NoError(t, err)
+		require.Len(t, report.Corrupted, 1)
+		assert.True(t, store.IsChecksumMismatch(report.Corrupted[0].Err))
+		assert.Len(t, report.Quarantined, 2)
+	})
+
+	t.Run("should not quarantine version which could not be read", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		v := tests.WriteData(t, openStore(t, dir), []byte("data"))
+		s := openStore(t, dir, store.FileSystem(failingReadFS{FS: store.OS}))
+		// when
+		report, err := s.Verify(context.Background(), store.Quarantine)
+		// then
+		require.NoError(t, err)
+		assert.False(t, report.OK())
+		assert.Empty(t, report.Corrupted)
+		require.Len(t, report.Unverifiable, 1)
+		assertVersionsEqual(t, []store.Version{v}, []store.Version{report.Unverifiable[0].Version})
+		assert.ErrorIs(t, report.Unverifiable[0].Err, syscall.EIO)
+		assert.Empty(t, report.Quarantined)
+		versions, err := s.Versions()
+		require.NoError(t, err)
+		assert.Len(t, versions, 1)
+	})
+
+	t.Run("should verify version compressed with unknown compressor", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		v := tests.WriteData(t, openStore(t, dir, store.Compression(zlibCompressor{})), []byte("data"))
+		s := openStore(t, dir)
+		// when
+		report, err := s.Verify(context.Background(), store.Quarantine)
+		// then
+		require.NoError(t, err)
+		assert.True(t, report.OK())
+		assertVersionsEqual(t, []store.Version{v}, report.Verified)
+	})
+
+	t.Run("should not quarantine version written with unknown checksum algorithm", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		v := tests.WriteData(t, openStore(t, dir, store.Checksum(md5Algorithm)), []byte("data"))
+		s := openStore(t, dir)
+		// when
+		report, err := s.Verify(context.Background(), store.Quarantine)
+		// then
+		require.NoError(t, err)
+		assert.False(t, report.OK())
+		assert.Empty(t, report.Corrupted)
+		require.Len(t, report.Unverifiable, 1)
+		assertVersionsEqual(t, []store.Version{v}, []store.Version{report.Unverifiable[0].Version})
+		assert.Contains(t, report.Unverifiable[0].Err.Error(), "md5")
+		assert.Empty(t, report.Quarantined)
+		versions, err := s.Versions()
+		require.NoError(t, err)
+		assert.Len(t, versions, 1)
+	})
+
+	t.Run("should not quarantine when store was opened with shared lock", func(t *testing.T) {
+		s := tests.OpenStore(t, store.SharedLock)
+		// when
+		_, err := s.Verify(context.Background(), store.Quarantine)
+		// then
+		assert.Error(t, err)
+	})
+
+	t.Run("should stop when context is cancelled", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		tests.WriteData(t, s, []byte("data"))
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		// when
+		report, err := s.Verify(ctx)
+		// then
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Empty(t, report.Verified)
+		assert.Empty(t, report.Corrupted)
+	})
+
+	t.Run("should return error when directory was removed", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s := openStore(t, dir)
+		require.NoError(t, os.RemoveAll(dir))
+		// when
+		_, err := s.Verify(context.Background())
+		// then
+		assert.Error(t, err)
+	})
+}
+
+func assertVersionsEqual(t *testing.T, expected, actual []store.Version) {
+	require.Len(t, actual, len(expected))
+	for i, v := range expected {
+		assert.True(t, v.Time.Equal(actual[i].Time), "times not equal")
+		assert.Equal(t, v.Size, actual[i].Size)
+	}
+}
+
+// failingReadFS returns I/O error when data file is read
+type failingReadFS struct {
+	store.FS
+}
+
+func (f failingReadFS) OpenFile(name string, flag int, perm fs.FileMode) (store.File, error) {
+	file, err := f.FS.OpenFile(name, flag, perm)
+	if err != nil || path.Ext(name) != ".data" {
+		return file, err
+	}
+	return failingReadFile{File: file}, nil
+}
+
+type failingReadFile struct {
+	store.File
+}
+
+func (f failingReadFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: f.Name(), Err: syscall.EIO}
+}
@@ -6,11 +6,10 @@ package store
 import (
 	"fmt"
 	"io/fs"
-	"io/ioutil"
 )
 
 func (s *Store) versions() ([]Version, error) {
-	files, err := ioutil.ReadDir(s.dir)
+	files, err := s.fs.ReadDir(s.dir)
 	if err != nil {
 		return nil, fmt.Errorf("reading dir %s failed: %w", s.dir, err)
 	}
@@ -21,11 +20,11 @@ func (s *Store) versions() ([]Version, error) {
 	for _, file := range files {
 		filename := file.Name()
 		if isDataFile(filename) {
-			_, hasChecksum := checksums[checksumFileForDataFile(filename)]
+			_, hasChecksum := checksums[ChecksumFileName(filename)]
 			if !hasChecksum {
 				continue
 			}
-			t, err := timeFromDataFile(filename)
+			t, err := ParseDataFileName(filename)
 			if err != nil {
 				return nil, fmt.Errorf("parsing filename %s failed: %w", file, err)
 			}
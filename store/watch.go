@@ -0,0 +1,63 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package store
+
+import (
+	"context"
+	"sync"
+)
+
+// watchBufferSize is the number of notifications buffered for each watcher
+const watchBufferSize = 16
+
+// Watch returns a channel receiving versions successfully written by this Store instance. Versions written by
+// other processes or other Store instances opened in the same directory are not reported.
+//
+// Notifications are never blocking the writer - when the subscriber does not keep up and the channel buffer is
+// full, the notification is dropped. Therefore, the notification should be treated as a signal that new versions
+// are available, and Versions should be used to find them. The channel is closed once ctx is done.
+func (s *Store) Watch(ctx context.Context) <-chan Version {
+	ch := make(chan Version, watchBufferSize)
+	s.watchers.add(ch)
+	go func() {
+		<-ctx.Done()
+		s.watchers.remove(ch)
+		close(ch)
+	}()
+	return ch
+}
+
+type watchers struct {
+	mutex    sync.Mutex
+	channels map[chan Version]struct{}
+}
+
+func (w *watchers) add(ch chan Version) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.channels == nil {
+		w.channels = map[chan Version]struct{}{}
+	}
+	w.channels[ch] = struct{}{}
+}
+
+func (w *watchers) remove(ch chan Version) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	delete(w.channels, ch)
+}
+
+func (w *watchers) notify(v Version) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for ch := range w.channels {
+		select {
+		case ch <- v:
+		default:
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package store_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jacekolszak/deebee/internal/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_Watch(t *testing.T) {
+
+	t.Run("should notify about written version", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		watch := s.Watch(ctx)
+		// when
+		v := tests.WriteData(t, s, []byte("data"))
+		// then
+		select {
+		case notified := <-watch:
+			assert.Equal(t, v, notified)
+		case <-time.After(time.Second):
+			require.FailNow(t, "timeout waiting for notification")
+		}
+	})
+
+	t.Run("should notify all watchers", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		watch1 := s.Watch(ctx)
+		watch2 := s.Watch(ctx)
+		// when
+		tests.WriteData(t, s, []byte("data"))
+		// then
+		assert.Len(t, watch1, 1)
+		assert.Len(t, watch2, 1)
+	})
+
+	t.Run("should not notify about aborted version", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		watch := s.Watch(ctx)
+		writer, err := s.Writer()
+		require.NoError(t, err)
+		// when
+		writer.AbortAndClose()
+		// then
+		assert.Empty(t, watch)
+	})
+
+	t.Run("should not block writer when watcher does not receive notifications", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s.Watch(ctx)
+		async := tests.RunAsync(func() {
+			for i := 0; i < 100; i++ {
+				tests.WriteData(t, s, []byte("data"))
+			}
+		})
+		// expect
+		async.WaitOrFailAfter(t, 5*time.Second)
+	})
+
+	t.Run("should close channel once context is cancelled", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		watch := s.Watch(ctx)
+		// when
+		cancel()
+		// then
+		assert.Eventually(t, func() bool {
+			select {
+			case _, ok := <-watch:
+				return !ok
+			default:
+				return false
+			}
+		}, time.Second, time.Millisecond)
+		tests.WriteData(t, s, []byte("data"))
+	})
+}
@@ -4,83 +4,178 @@
 package store
 
 import (
+	"errors"
 	"fmt"
 	"hash"
-	"io/ioutil"
+	"io"
+	"io/fs"
 	"os"
 	"time"
 )
 
 func (s *Store) openWriter(options []WriterOption) (Writer, error) {
-	opts := &WriterOptions{
-		time: s.nextVersionTime(),
-		sync: (*os.File).Sync,
+	start := time.Now()
+
+	if err := s.checkWritable(); err != nil {
+		return nil, err
 	}
-	for _, apply := range options {
-		if apply == nil {
-			continue
-		}
-		if err := apply(opts); err != nil {
-			return nil, fmt.Errorf("error applying option: %w", err)
-		}
+
+	opts, err := ApplyWriterOptions(options, s.nextVersionTime())
+	if err != nil {
+		return nil, err
 	}
 
 	name := s.dataFilename(opts.time)
-	file, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0664)
-	if os.IsExist(err) {
-		return nil, versionAlreadyExistsError{msg: fmt.Sprintf("version %s already exists: %s", opts.time, err)}
+	if _, err = s.fs.Lstat(name); err == nil {
+		return nil, versionAlreadyExistsError{msg: fmt.Sprintf("version %s already exists", opts.time)}
+	}
+	tempName := tempFileFor(name)
+	file, err := s.fs.OpenFile(tempName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0664)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, versionAlreadyExistsError{msg: fmt.Sprintf("version %s is already being written: %s", opts.time, err)}
 	}
 	if err != nil {
-		return nil, fmt.Errorf("error opening the file %s for writing: %w", name, err)
+		return nil, fmt.Errorf("error opening the file %s for writing: %w", tempName, err)
 	}
 	w := &writer{
-		file:     file,
-		time:     opts.time,
-		sync:     opts.sync,
-		checksum: newHash(),
-		metrics:  &s.metrics.Write,
+		dir:               s.dir,
+		fs:                s.fs,
+		name:              name,
+		file:              file,
+		start:             start,
+		time:              opts.time,
+		noSync:            opts.noSync,
+		checksum:          s.checksumAlgorithm.NewHash(),
+		checksumAlgorithm: s.checksumAlgorithm.Name,
+		metrics:           &s.metrics,
+		watchers:          &s.watchers,
+	}
+	if s.compressor != nil {
+		w.compressorName = s.compressor.Name()
+		w.compressor, err = s.compressor.NewWriter(writerFunc(w.writeStored))
+		if err != nil {
+			_ = file.Close()
+			_ = s.fs.Remove(tempName)
+			return nil, fmt.Errorf("error creating %s compressor: %w", w.compressorName, err)
+		}
 	}
 	return w, nil
 }
 
 func (s *Store) nextVersionTime() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	t := time.Now()
-	if s.lastVersionTime == t {
-		t = t.Add(time.Nanosecond)
+	if !t.After(s.lastVersionTime) {
+		t = s.lastVersionTime.Add(time.Nanosecond)
 	}
 	s.lastVersionTime = t
 	return t
 }
 
+// writer writes data and checksum into temporary files first. Close makes them durable and atomically renames
+// them to final names, data file first. Version is visible only when both files exist, so a crash at any point
+// never exposes a version which data is not durable.
 type writer struct {
-	file     *os.File
+	dir      string
+	fs       FS
+	name     string // final name of data file
+	file     File
+	closed   bool
+	start    time.Time // time when Store.Writer was called
 	time     time.Time
-	sync     func(*os.File) error
+	noSync   bool
 	size     int64
 	checksum hash.Hash
 
-	metrics *WriteMetrics
+	syncTime   time.Duration // time spent on syncing
+	syncFailed bool
+
+	checksumAlgorithm string
+
+	compressor     io.WriteCloser // nil when data is not compressed
+	compressorName string
+
+	metrics  *metricsRecorder
+	watchers *watchers
 }
 
 func (w *writer) Write(p []byte) (int, error) {
 	defer w.addElapsedTime(time.Now())
 
+	if w.closed {
+		return 0, fmt.Errorf("writer for version %s already closed", w.time)
+	}
+
+	var n int
+	var err error
+	if w.compressor != nil {
+		n, err = w.compressor.Write(p)
+	} else {
+		n, err = w.writeStored(p)
+	}
+
+	w.metrics.updateWrite(func(m *WriteMetrics) {
+		m.TotalBytesWritten += n
+		if err != nil {
+			m.Errors.Write++
+		}
+	})
+	return n, err
+}
+
+// writeStored writes data to the file as-is. Written bytes are included in the checksum and version size.
+func (w *writer) writeStored(p []byte) (int, error) {
 	n, err := w.file.Write(p)
 	w.size += int64(n)
 	w.checksum.Write(p[:n])
-
-	w.metrics.TotalBytesWritten += n
 	return n, err
 }
 
 func (w *writer) Close() error {
 	defer w.addElapsedTime(time.Now())
 
-	if err := w.writeChecksum(); err != nil {
-		_ = w.file.Close()
-		return fmt.Errorf("error writing checksum: %w", err)
+	if w.closed {
+		return fmt.Errorf("writer for version %s already closed", w.time)
+	}
+	w.closed = true
+
+	if err := w.commit(); err != nil {
+		_ = w.fs.Remove(w.file.Name())
+		_ = w.fs.Remove(tempFileFor(ChecksumFileName(w.name)))
+		w.metrics.updateWrite(func(m *WriteMetrics) {
+			m.TotalSyncTime += w.syncTime
+			switch {
+			case w.syncFailed:
+				m.Errors.Sync++
+			case IsVersionAlreadyExists(err):
+				m.Errors.VersionAlreadyExists++
+			default:
+				m.Errors.Commit++
+			}
+		})
+		return err
+	}
+
+	w.metrics.updateWrite(func(m *WriteMetrics) {
+		m.Successful++
+		m.TotalSyncTime += w.syncTime
+		m.SyncLatency.observe(w.syncTime)
+		m.Latency.observe(time.Since(w.start))
+	})
+	w.watchers.notify(w.Version())
+	return nil
+}
+
+func (w *writer) commit() error {
+	if w.compressor != nil {
+		if err := w.compressor.Close(); err != nil {
+			_ = w.file.Close()
+			return fmt.Errorf("error closing %s compressor: %w", w.compressorName, err)
+		}
 	}
-	if err := w.sync(w.file); err != nil {
+	if err := w.syncFile(w.file); err != nil {
 		_ = w.file.Close()
 		return fmt.Errorf("error syncing file: %w", err)
 	}
@@ -88,14 +183,71 @@ func (w *writer) Close() error {
 		return fmt.Errorf("error closing file: %w", err)
 	}
 
-	w.metrics.Successful++
+	checksumFile := ChecksumFileName(w.name)
+	tempChecksumFile := tempFileFor(checksumFile)
+	if err := w.writeChecksum(tempChecksumFile); err != nil {
+		return fmt.Errorf("error writing checksum: %w", err)
+	}
+
+	if _, err := w.fs.Lstat(w.name); err == nil {
+		return versionAlreadyExistsError{msg: fmt.Sprintf("version %s already exists", w.time)}
+	}
+	if err := w.fs.Rename(w.file.Name(), w.name); err != nil {
+		return fmt.Errorf("error renaming file %s: %w", w.file.Name(), err)
+	}
+	if err := w.fs.Rename(tempChecksumFile, checksumFile); err != nil {
+		_ = w.fs.Remove(w.name)
+		return fmt.Errorf("error renaming file %s: %w", tempChecksumFile, err)
+	}
+	if err := w.syncDir(); err != nil {
+		return fmt.Errorf("error syncing directory %s: %w", w.dir, err)
+	}
 	return nil
 }
 
-func (w *writer) writeChecksum() error {
-	checksumFile := checksumFileForDataFile(w.file.Name())
-	sum := w.checksum.Sum([]byte{})
-	return ioutil.WriteFile(checksumFile, sum, 0664)
+func (w *writer) writeChecksum(name string) error {
+	content := FormatChecksum(VersionChecksum{
+		Algorithm:   w.checksumAlgorithm,
+		Sum:         w.checksum.Sum([]byte{}),
+		Compression: w.compressorName,
+	})
+	file, err := w.fs.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0664)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(content); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = w.syncFile(file); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (w *writer) syncFile(file File) error {
+	return w.timeSync(file.Sync)
+}
+
+func (w *writer) syncDir() error {
+	return w.timeSync(func() error {
+		return w.fs.SyncDir(w.dir)
+	})
+}
+
+// timeSync runs sync unless NoSync was used. Time spent and failures are recorded for metrics.
+func (w *writer) timeSync(sync func() error) error {
+	if w.noSync {
+		return nil
+	}
+	start := time.Now()
+	err := sync()
+	w.syncTime += time.Since(start)
+	if err != nil {
+		w.syncFailed = true
+	}
+	return err
 }
 
 func (w *writer) Version() Version {
@@ -108,12 +260,28 @@ func (w *writer) Version() Version {
 func (w *writer) AbortAndClose() {
 	defer w.addElapsedTime(time.Now())
 
+	if w.closed {
+		return
+	}
+	w.closed = true
+
 	_ = w.file.Close()
-	_ = os.Remove(w.file.Name())
+	_ = w.fs.Remove(w.file.Name())
 
-	w.metrics.Aborted++
+	w.metrics.updateWrite(func(m *WriteMetrics) {
+		m.Aborted++
+	})
 }
 
 func (w *writer) addElapsedTime(start time.Time) {
-	w.metrics.TotalTime += time.Since(start)
+	elapsed := time.Since(start)
+	w.metrics.updateWrite(func(m *WriteMetrics) {
+		m.TotalTime += elapsed
+	})
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
 }
@@ -5,6 +5,8 @@ package store_test
 
 import (
 	"errors"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -46,6 +48,19 @@ func TestStore_Writer(t *testing.T) {
 		assert.Nil(t, writer)
 	})
 
+	t.Run("should return error when trying to open Writer with same time as not closed writer", func(t *testing.T) {
+		s := tests.OpenStore(t)
+		writeTime := time.Unix(1000, 0)
+		writer, err := s.Writer(store.WriteTime(writeTime))
+		require.NoError(t, err)
+		defer writer.AbortAndClose()
+		// when
+		writer2, err := s.Writer(store.WriteTime(writeTime))
+		// then
+		assert.True(t, store.IsVersionAlreadyExists(err))
+		assert.Nil(t, writer2)
+	})
+
 	t.Run("should accept nil option", func(t *testing.T) {
 		s := tests.OpenStore(t)
 		w, err := s.Writer(nil)
@@ -128,6 +143,19 @@ func TestWriter_AbortAndClose(t *testing.T) {
 		assert.Empty(t, versions)
 	})
 
+	t.Run("should remove all files of aborted version", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s, err := store.Open(dir)
+		require.NoError(t, err)
+		writer, _ := s.Writer()
+		_, err = writer.Write([]byte("data"))
+		require.NoError(t, err)
+		// when
+		writer.AbortAndClose()
+		// then
+		assert.Empty(t, filesIn(t, dir))
+	})
+
 	t.Run("aborted data for a store with previously written data should not be available for read", func(t *testing.T) {
 		s := tests.OpenStore(t)
 		oldData := []byte("old")
@@ -173,6 +201,36 @@ func TestWriter_Close(t *testing.T) {
 		assert.Error(t, err)
 	})
 
+	t.Run("should not create data or checksum file before Close", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s, err := store.Open(dir)
+		require.NoError(t, err)
+		writer, _ := s.Writer()
+		defer writer.AbortAndClose()
+		// when
+		_, err = writer.Write([]byte("data"))
+		// then
+		require.NoError(t, err)
+		for _, file := range filesIn(t, dir) {
+			assert.True(t, strings.HasSuffix(file, ".tmp"), "unexpected file %s", file)
+		}
+	})
+
+	t.Run("should leave only data and checksum files after Close", func(t *testing.T) {
+		dir := tests.TempDir(t)
+		s, err := store.Open(dir)
+		require.NoError(t, err)
+		writer, _ := s.Writer()
+		// when
+		err = writer.Close()
+		// then
+		require.NoError(t, err)
+		files := filesIn(t, dir)
+		require.Len(t, files, 2)
+		assert.True(t, strings.HasSuffix(files[0], ".data"))
+		assert.True(t, strings.HasSuffix(files[1], ".data.sum"))
+	})
+
 	t.Run("should no sync when closing the file", func(t *testing.T) {
 		s := tests.OpenStore(t)
 		writer, _ := s.Writer(store.NoSync)
@@ -183,6 +241,16 @@ func TestWriter_Close(t *testing.T) {
 	})
 }
 
+func filesIn(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
 func readVersions(t *testing.T, s *store.Store) []store.Version {
 	v, err := s.Versions()
 	require.NoError(t, err)
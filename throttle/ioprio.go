@@ -0,0 +1,39 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package throttle
+
+import "runtime"
+
+// LowIOPriority runs f with idle I/O scheduling class, so disk I/O done by f is served only when no other program
+// needs the disk. It is only a hint - it works on Linux with I/O schedulers supporting priorities (such as BFQ),
+// on other systems f is run normally. Network I/O is not affected.
+//
+// f is run in a separate goroutine locked to an OS thread, which is terminated afterwards, so the priority does
+// not leak to other goroutines. Goroutines started by f are run with normal priority. Panic in f is re-raised
+// in the calling goroutine.
+func LowIOPriority(f func()) {
+	var (
+		panicked  bool
+		recovered interface{}
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if panicked {
+				recovered = recover()
+			}
+		}()
+		// thread is terminated when goroutine exits without unlocking
+		runtime.LockOSThread()
+		setIdleIOPriority()
+		panicked = true
+		f()
+		panicked = false
+	}()
+	<-done
+	if panicked {
+		panic(recovered)
+	}
+}
@@ -0,0 +1,21 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+//go:build linux
+// +build linux
+
+package throttle
+
+import "syscall"
+
+const (
+	ioprioWhoProcess = 1 // with who=0 it is the calling thread
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+)
+
+// setIdleIOPriority sets idle I/O scheduling class for the current thread. Errors are ignored, because priority
+// is only a hint.
+func setIdleIOPriority() {
+	_, _, _ = syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, 0, ioprioClassIdle<<ioprioClassShift)
+}
@@ -0,0 +1,10 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+//go:build !linux
+// +build !linux
+
+package throttle
+
+// setIdleIOPriority is a no-op, because I/O priorities are supported only on Linux
+func setIdleIOPriority() {}
@@ -0,0 +1,135 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+// Package throttle limits the I/O done by background jobs - replicator, scrubber and compacter - so they do not
+// starve the application. Limiter can be shared by many jobs to limit their total throughput:
+//
+//	limiter, _ := throttle.NewLimiter(10*1024*1024, 1024*1024) // 10 MiB/s with 1 MiB burst
+//	go replicator.StartFromTo(ctx, local, nfs, replicator.Throttle(limiter))
+//	go scrubber.Start(ctx, local, scrubber.Throttle(limiter))
+package throttle
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// NewLimiter returns a token bucket limiter allowing bytesPerSecond on average, with bursts up to burst bytes.
+func NewLimiter(bytesPerSecond, burst int64) (*Limiter, error) {
+	if bytesPerSecond <= 0 {
+		return nil, errors.New("bytesPerSecond must be greater than zero")
+	}
+	if burst <= 0 {
+		return nil, errors.New("burst must be greater than zero")
+	}
+	return &Limiter{
+		rate:   float64(bytesPerSecond),
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}, nil
+}
+
+// Limiter is safe for concurrent use by multiple goroutines
+type Limiter struct {
+	rate  float64 // bytes per second
+	burst int64
+
+	mutex  sync.Mutex
+	tokens float64 // negative when bytes were taken in advance
+	last   time.Time
+}
+
+// Wait blocks until n bytes can be transferred or ctx is done. n can be greater than burst - then Wait blocks
+// for a proportionally longer time.
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	wait := l.take(float64(n))
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.take(-float64(n)) // give back tokens which were not used
+		return ctx.Err()
+	}
+}
+
+// take takes n tokens and returns how long the caller must wait until they are available
+func (l *Limiter) take(n float64) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+	l.last = now
+	l.tokens -= n
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// NewReader returns a reader limited by l. Read returns ctx error when ctx is done while waiting.
+func NewReader(ctx context.Context, r io.Reader, l *Limiter) io.Reader {
+	return &reader{ctx: ctx, reader: r, limiter: l}
+}
+
+type reader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if int64(len(p)) > r.limiter.burst {
+		p = p[:r.limiter.burst]
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.Wait(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// NewWriter returns a writer limited by l. Write returns ctx error when ctx is done while waiting.
+func NewWriter(ctx context.Context, w io.Writer, l *Limiter) io.Writer {
+	return &writer{ctx: ctx, writer: w, limiter: l}
+}
+
+type writer struct {
+	ctx     context.Context
+	writer  io.Writer
+	limiter *Limiter
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if int64(len(chunk)) > w.limiter.burst {
+			chunk = chunk[:w.limiter.burst]
+		}
+		if err := w.limiter.Wait(w.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.writer.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,144 @@
+// (c) 2021 Jacek Olszak
+// This code is licensed under MIT license (see LICENSE for details)
+
+package throttle_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jacekolszak/deebee/throttle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLimiter(t *testing.T) {
+
+	t.Run("should return error for invalid arguments", func(t *testing.T) {
+		_, err := throttle.NewLimiter(0, 1)
+		assert.Error(t, err)
+		_, err = throttle.NewLimiter(1, 0)
+		assert.Error(t, err)
+	})
+}
+
+func TestLimiter_Wait(t *testing.T) {
+
+	t.Run("should not wait within burst", func(t *testing.T) {
+		limiter := newLimiter(t, 1, 1000)
+		start := time.Now()
+		// when
+		err := limiter.Wait(context.Background(), 1000)
+		// then
+		require.NoError(t, err)
+		assert.Less(t, int64(time.Since(start)), int64(100*time.Millisecond))
+	})
+
+	t.Run("should wait when burst was exceeded", func(t *testing.T) {
+		limiter := newLimiter(t, 10000, 100)
+		start := time.Now()
+		// when
+		err := limiter.Wait(context.Background(), 600)
+		// then
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, int64(time.Since(start)), int64(40*time.Millisecond))
+	})
+
+	t.Run("should return error when context was cancelled", func(t *testing.T) {
+		limiter := newLimiter(t, 1, 1)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		// when
+		err := limiter.Wait(ctx, 10)
+		// then
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestNewReader(t *testing.T) {
+
+	t.Run("should read all data", func(t *testing.T) {
+		data := bytes.Repeat([]byte("data"), 100)
+		reader := throttle.NewReader(context.Background(), bytes.NewReader(data), newLimiter(t, 1000000, 16))
+		// when
+		actual, err := io.ReadAll(reader)
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, data, actual)
+	})
+
+	t.Run("should limit throughput", func(t *testing.T) {
+		data := make([]byte, 600)
+		reader := throttle.NewReader(context.Background(), bytes.NewReader(data), newLimiter(t, 10000, 100))
+		start := time.Now()
+		// when
+		_, err := io.ReadAll(reader)
+		// then
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, int64(time.Since(start)), int64(40*time.Millisecond))
+	})
+
+	t.Run("should return error when context was cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		reader := throttle.NewReader(ctx, bytes.NewReader(make([]byte, 10)), newLimiter(t, 1, 1))
+		_, _ = reader.Read(make([]byte, 1)) // takes the burst
+		// when
+		_, err := reader.Read(make([]byte, 1))
+		// then
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestNewWriter(t *testing.T) {
+
+	t.Run("should write all data", func(t *testing.T) {
+		data := bytes.Repeat([]byte("data"), 100)
+		buffer := &bytes.Buffer{}
+		writer := throttle.NewWriter(context.Background(), buffer, newLimiter(t, 1000000, 16))
+		// when
+		n, err := writer.Write(data)
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, len(data), n)
+		assert.Equal(t, data, buffer.Bytes())
+	})
+
+	t.Run("should limit throughput", func(t *testing.T) {
+		writer := throttle.NewWriter(context.Background(), io.Discard, newLimiter(t, 10000, 100))
+		start := time.Now()
+		// when
+		_, err := writer.Write(make([]byte, 600))
+		// then
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, int64(time.Since(start)), int64(40*time.Millisecond))
+	})
+}
+
+func TestLowIOPriority(t *testing.T) {
+
+	t.Run("should run function", func(t *testing.T) {
+		executed := false
+		throttle.LowIOPriority(func() {
+			executed = true
+		})
+		assert.True(t, executed)
+	})
+
+	t.Run("should re-raise panic in calling goroutine", func(t *testing.T) {
+		assert.PanicsWithValue(t, "failure", func() {
+			throttle.LowIOPriority(func() {
+				panic("failure")
+			})
+		})
+	})
+}
+
+func newLimiter(t *testing.T, bytesPerSecond, burst int64) *throttle.Limiter {
+	limiter, err := throttle.NewLimiter(bytesPerSecond, burst)
+	require.NoError(t, err)
+	return limiter
+}